@@ -0,0 +1,196 @@
+package analytics
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rollupInterval controls how often likes and comments are folded into
+// article_daily_stats. Views are written on every flush instead.
+const rollupInterval = 5 * time.Minute
+
+var botMarkers = []string{"bot", "crawler", "spider", "slurp"}
+
+type viewKey struct {
+	articleID int
+	visitor   [sha256.Size]byte
+}
+
+// ViewTracker counts article views in memory and writes them to the database
+// in batches. A visitor is counted at most once per article within the
+// de-duplication window.
+type ViewTracker struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+
+	window   time.Duration
+	interval time.Duration
+
+	mu      sync.Mutex
+	seen    map[viewKey]time.Time
+	pending map[int]int64
+}
+
+func NewViewTracker(db *sql.DB, logger *logrus.Logger, window, interval time.Duration) *ViewTracker {
+	return &ViewTracker{
+		DB:       db,
+		Logger:   logger,
+		window:   window,
+		interval: interval,
+		seen:     make(map[viewKey]time.Time),
+		pending:  make(map[int]int64),
+	}
+}
+
+// Record registers a view of the article by the visitor identified by ip and
+// userAgent. Known crawlers are ignored.
+func (t *ViewTracker) Record(articleID int, ip, userAgent string) {
+	ua := strings.ToLower(userAgent)
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return
+		}
+	}
+
+	key := viewKey{articleID: articleID, visitor: sha256.Sum256([]byte(ip + "\x00" + userAgent))}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.seen[key]; ok && now.Sub(last) < t.window {
+		return
+	}
+	t.seen[key] = now
+	t.pending[articleID]++
+}
+
+// Run flushes buffered views every interval and rolls up likes and comments
+// until ctx is cancelled, then performs a final flush.
+func (t *ViewTracker) Run(ctx context.Context) {
+	flushTicker := time.NewTicker(t.interval)
+	defer flushTicker.Stop()
+	rollupTicker := time.NewTicker(rollupInterval)
+	defer rollupTicker.Stop()
+
+	t.rollup(ctx)
+
+	for {
+		select {
+		case <-flushTicker.C:
+			t.flush(ctx)
+		case <-rollupTicker.C:
+			t.rollup(ctx)
+		case <-ctx.Done():
+			// The request context is gone, but buffered views should still
+			// reach the database on shutdown.
+			t.flush(context.Background())
+			return
+		}
+	}
+}
+
+func (t *ViewTracker) flush(ctx context.Context) {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[int]int64)
+
+	cutoff := time.Now().Add(-t.window)
+	for key, last := range t.seen {
+		if last.Before(cutoff) {
+			delete(t.seen, key)
+		}
+	}
+	t.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := t.writeViews(ctx, pending); err != nil {
+		t.Logger.WithError(err).Errorf("Failed to flush views for %d articles", len(pending))
+
+		// Put the counts back so they are retried on the next flush.
+		t.mu.Lock()
+		for articleID, views := range pending {
+			t.pending[articleID] += views
+		}
+		t.mu.Unlock()
+		return
+	}
+
+	t.Logger.Infof("Flushed views for %d articles", len(pending))
+}
+
+func (t *ViewTracker) writeViews(ctx context.Context, pending map[int]int64) error {
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for articleID, views := range pending {
+		query := `UPDATE articles SET views_count = views_count + $1 WHERE id=$2`
+		result, err := tx.ExecContext(ctx, query, views, articleID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		// The article may have been deleted since it was viewed.
+		if updated, _ := result.RowsAffected(); updated == 0 {
+			continue
+		}
+
+		query = `INSERT INTO article_daily_stats (article_id, day, views) VALUES ($1, CURRENT_DATE, $2)
+		         ON CONFLICT (article_id, day) DO UPDATE SET views = article_daily_stats.views + EXCLUDED.views`
+		if _, err = tx.ExecContext(ctx, query, articleID, views); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rollup recomputes the like and comment counts of today and yesterday so
+// that late changes around midnight are picked up as well. The counts are
+// reset first so that days whose likes or comments were all removed drop to
+// 0; readers only see the result once it is complete.
+func (t *ViewTracker) rollup(ctx context.Context) {
+	queries := []string{
+		`UPDATE article_daily_stats SET likes = 0, comments = 0
+		 WHERE day >= CURRENT_DATE - 1 AND (likes <> 0 OR comments <> 0)`,
+		`INSERT INTO article_daily_stats (article_id, day, likes)
+		 SELECT article_id, created_at::date, COUNT(*)
+		 FROM article_likes
+		 WHERE created_at >= CURRENT_DATE - 1
+		 GROUP BY article_id, created_at::date
+		 ON CONFLICT (article_id, day) DO UPDATE SET likes = EXCLUDED.likes`,
+		`INSERT INTO article_daily_stats (article_id, day, comments)
+		 SELECT article_id, created_at::date, COUNT(*)
+		 FROM comments
+		 WHERE created_at >= CURRENT_DATE - 1 AND deleted_at IS NULL
+		 GROUP BY article_id, created_at::date
+		 ON CONFLICT (article_id, day) DO UPDATE SET comments = EXCLUDED.comments`,
+	}
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Logger.WithError(err).Error("Failed to begin transaction")
+		return
+	}
+	for _, query := range queries {
+		if _, err = tx.ExecContext(ctx, query); err != nil {
+			tx.Rollback()
+			t.Logger.WithError(err).Error("Failed to roll up daily article stats")
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		t.Logger.WithError(err).Error("Failed to roll up daily article stats")
+	}
+}
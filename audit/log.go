@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"GoArticles/models"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+const columns = `id, created_at, actor_id, action, target_type, target_id, before, after, ip, request_id, prev_hash, hash`
+
+// timeLayout is how created_at enters the hash; the column keeps
+// microseconds, so entries are timestamped at that precision.
+const timeLayout = "2006-01-02T15:04:05.000000"
+
+// Log is the append-only audit log. Every entry stores the hash of the entry
+// before it, so changing or removing an entry breaks the chain from there on.
+// The table rejects updates and deletes, which leaves rewriting it wholesale;
+// keeping the head hash reported by Verify elsewhere catches that too.
+type Log struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+}
+
+func NewLog(db *sql.DB, logger *logrus.Logger) *Log {
+	return &Log{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+// Filter narrows down the entries returned by Entries. Zero values match
+// everything; To is exclusive.
+type Filter struct {
+	ActorID    *int
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+}
+
+// Append adds the entry at the end of the chain in a transaction of its own,
+// filling in its ID, time and hashes.
+func (l *Log) Append(ctx context.Context, entry *models.AuditEntry) error {
+	tx, err := l.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = appendEntry(ctx, tx, entry); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// appendEntry adds the entry at the end of the chain in tx. Appends go one
+// at a time so that each links to the entry written right before it: the
+// chain head stays locked until tx ends.
+func appendEntry(ctx context.Context, tx *sql.Tx, entry *models.AuditEntry) error {
+	var prevHash string
+	if err := tx.QueryRowContext(ctx, `SELECT hash FROM audit_chain_head FOR UPDATE`).Scan(&prevHash); err != nil {
+		return err
+	}
+
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	entry.PrevHash = prevHash
+	entry.Hash = hashEntry(entry)
+
+	query := `INSERT INTO audit_log (created_at, actor_id, action, target_type, target_id, before, after, ip, request_id, prev_hash, hash)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`
+	err := tx.QueryRowContext(ctx, query, entry.CreatedAt, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID,
+		jsonValue(entry.Before), jsonValue(entry.After), entry.IP, entry.RequestID, entry.PrevHash, entry.Hash).Scan(&entry.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE audit_chain_head SET hash = $1`, entry.Hash)
+	return err
+}
+
+// Entries calls fn for every entry matching the filter, newest first. A
+// limit of 0 returns all of them.
+func (l *Log) Entries(ctx context.Context, f Filter, limit, offset int, fn func(*models.AuditEntry) error) error {
+	query := `SELECT ` + columns + ` FROM audit_log
+	          WHERE ($1::INTEGER IS NULL OR actor_id = $1)
+	            AND ($2::TEXT = '' OR action = $2)
+	            AND ($3::TEXT = '' OR target_type = $3)
+	            AND ($4::TEXT = '' OR target_id = $4)
+	            AND ($5::TIMESTAMP IS NULL OR created_at >= $5)
+	            AND ($6::TIMESTAMP IS NULL OR created_at < $6)
+	          ORDER BY id DESC
+	          LIMIT NULLIF($7, 0) OFFSET $8`
+	rows, err := l.DB.QueryContext(ctx, query, f.ActorID, f.Action, f.TargetType, f.TargetID, utc(f.From), utc(f.To), limit, offset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Verify walks the chain from the oldest entry and reports the first one
+// whose hash does not match its contents or whose link to the previous entry
+// is broken.
+func (l *Log) Verify(ctx context.Context) (*models.AuditVerification, error) {
+	rows, err := l.DB.QueryContext(ctx, `SELECT `+columns+` FROM audit_log ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &models.AuditVerification{Valid: true}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !verifyNext(result, entry) {
+			return result, nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// verifyNext adds the next entry in chain order to result and reports
+// whether the chain still holds. A broken chain is marked in result.
+func verifyNext(result *models.AuditVerification, entry *models.AuditEntry) bool {
+	result.Entries++
+	if entry.PrevHash != result.Head || hashEntry(entry) != entry.Hash {
+		result.Valid = false
+		result.BrokenAt = entry.ID
+		return false
+	}
+	result.Head = entry.Hash
+	return true
+}
+
+// hashEntry is the SHA-256 over the previous hash and every field of the
+// entry except its ID. Fields are length-prefixed so that no two different
+// entries hash the same input.
+func hashEntry(entry *models.AuditEntry) string {
+	actor := ""
+	if entry.ActorID != nil {
+		actor = strconv.Itoa(*entry.ActorID)
+	}
+
+	h := sha256.New()
+	for _, field := range []string{
+		entry.PrevHash,
+		entry.CreatedAt.UTC().Format(timeLayout),
+		actor,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		string(entry.Before),
+		string(entry.After),
+		entry.IP,
+		entry.RequestID,
+	} {
+		fmt.Fprintf(h, "%d:%s\n", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEntry(row rowScanner) (*models.AuditEntry, error) {
+	var (
+		entry         models.AuditEntry
+		before, after []byte
+	)
+	err := row.Scan(&entry.ID, &entry.CreatedAt, &entry.ActorID, &entry.Action, &entry.TargetType, &entry.TargetID,
+		&before, &after, &entry.IP, &entry.RequestID, &entry.PrevHash, &entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+	entry.Before, entry.After = before, after
+	return &entry, nil
+}
+
+// jsonValue passes a snapshot as text, so the json column stores exactly
+// the bytes that were hashed.
+func jsonValue(raw json.RawMessage) interface{} {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+func utc(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
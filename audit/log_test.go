@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"GoArticles/models"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// chain returns n linked entries as Append writes them.
+func chain(n int) []*models.AuditEntry {
+	entries := make([]*models.AuditEntry, n)
+	prevHash := ""
+	for i := range entries {
+		actor := i + 1
+		entry := &models.AuditEntry{
+			ID:         int64(i + 1),
+			CreatedAt:  time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+			ActorID:    &actor,
+			Action:     "PATCH /users/:id",
+			TargetType: "users",
+			TargetID:   "1",
+			Before:     json.RawMessage(`{"bio":""}`),
+			After:      json.RawMessage(`{"bio":"hello"}`),
+			IP:         "192.0.2.1",
+			RequestID:  "request",
+			PrevHash:   prevHash,
+		}
+		entry.Hash = hashEntry(entry)
+		prevHash = entry.Hash
+		entries[i] = entry
+	}
+	return entries
+}
+
+func verifyEntries(entries []*models.AuditEntry) *models.AuditVerification {
+	result := &models.AuditVerification{Valid: true}
+	for _, entry := range entries {
+		if !verifyNext(result, entry) {
+			break
+		}
+	}
+	return result
+}
+
+func TestVerifyNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(entries []*models.AuditEntry) []*models.AuditEntry
+		brokenAt int64
+	}{
+		{name: "intact", change: func(e []*models.AuditEntry) []*models.AuditEntry { return e }},
+		{name: "empty", change: func(e []*models.AuditEntry) []*models.AuditEntry { return nil }},
+		{name: "changed field", brokenAt: 2, change: func(e []*models.AuditEntry) []*models.AuditEntry {
+			e[1].After = json.RawMessage(`{"bio":"changed"}`)
+			return e
+		}},
+		{name: "changed actor", brokenAt: 3, change: func(e []*models.AuditEntry) []*models.AuditEntry {
+			other := 99
+			e[2].ActorID = &other
+			return e
+		}},
+		{name: "removed actor", brokenAt: 1, change: func(e []*models.AuditEntry) []*models.AuditEntry {
+			e[0].ActorID = nil
+			return e
+		}},
+		{name: "changed and rehashed", brokenAt: 3, change: func(e []*models.AuditEntry) []*models.AuditEntry {
+			e[1].Action = "DELETE /users/:id"
+			e[1].Hash = hashEntry(e[1])
+			return e
+		}},
+		{name: "removed entry", brokenAt: 3, change: func(e []*models.AuditEntry) []*models.AuditEntry {
+			return append(e[:1], e[2:]...)
+		}},
+		{name: "swapped entries", brokenAt: 3, change: func(e []*models.AuditEntry) []*models.AuditEntry {
+			e[1], e[2] = e[2], e[1]
+			return e
+		}},
+		{name: "fields shifted between columns", brokenAt: 1, change: func(e []*models.AuditEntry) []*models.AuditEntry {
+			e[0].TargetType, e[0].TargetID = "users1", ""
+			return e
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := tt.change(chain(4))
+			result := verifyEntries(entries)
+
+			if tt.brokenAt == 0 {
+				if !result.Valid || result.Entries != len(entries) {
+					t.Fatalf("verify = %+v, want a valid chain of %d entries", result, len(entries))
+				}
+				want := ""
+				if len(entries) > 0 {
+					want = entries[len(entries)-1].Hash
+				}
+				if result.Head != want {
+					t.Errorf("head = %q, want %q", result.Head, want)
+				}
+				return
+			}
+			if result.Valid || result.BrokenAt != tt.brokenAt {
+				t.Errorf("verify = %+v, want broken at %d", result, tt.brokenAt)
+			}
+		})
+	}
+}
+
+func TestHashEntryIgnoresID(t *testing.T) {
+	entry := chain(1)[0]
+	hash := hashEntry(entry)
+	entry.ID = 100
+	if got := hashEntry(entry); got != hash {
+		t.Errorf("hashEntry changed with the ID: %s != %s", got, hash)
+	}
+	entry.CreatedAt = entry.CreatedAt.Add(time.Microsecond)
+	if got := hashEntry(entry); got == hash {
+		t.Error("hashEntry did not change with the time")
+	}
+}
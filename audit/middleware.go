@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"GoArticles/auth"
+	"GoArticles/models"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	beforeKey    = "audit.before"
+	afterKey     = "audit.after"
+	targetKey    = "audit.target"
+	logKey       = "audit.log"
+	requestIDKey = "audit.request_id"
+	recordedKey  = "audit.recorded"
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Middleware gives every request an ID, taken from X-Request-ID if the
+// client sent a sensible one, and records every successful request that
+// changes something, i.e. anything but GET, HEAD and OPTIONS. The action is
+// the method and route, e.g. "DELETE /articles/:id"; the target is the
+// route's first path segment and parameter. Handlers add snapshots of the
+// target with Before and After.
+//
+// Handlers that make their change in a transaction write the entry in it
+// with Record. For the others the entry is written after the handler has
+// made its change, so a failing write is logged but cannot undo the request.
+func (l *Log) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			requestID := c.Request().Header.Get(echo.HeaderXRequestID)
+			if !requestIDPattern.MatchString(requestID) {
+				requestID = newRequestID()
+			}
+			c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+			c.Set(requestIDKey, requestID)
+			c.Set(logKey, l)
+
+			err := next(c)
+
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return err
+			}
+			if err != nil || c.Response().Status >= http.StatusBadRequest {
+				return err
+			}
+			if recorded, _ := c.Get(recordedKey).(bool); recorded {
+				return nil
+			}
+
+			entry := l.entry(c)
+			// The change is made, so record it even if the client has gone.
+			ctx := context.WithoutCancel(c.Request().Context())
+			if err := l.Append(ctx, entry); err != nil {
+				l.Logger.WithError(err).WithField("action", entry.Action).Error("Failed to write audit log entry")
+			}
+			return nil
+		}
+	}
+}
+
+// Record writes the request's entry in tx, the transaction making the
+// change, so that the change and its entry are committed together or not at
+// all; the middleware then writes none. Call it after Before and After, right
+// before committing: the chain stays locked until tx ends. Outside the
+// middleware it does nothing.
+func Record(c echo.Context, tx *sql.Tx) error {
+	l, ok := c.Get(logKey).(*Log)
+	if !ok {
+		return nil
+	}
+	if err := appendEntry(c.Request().Context(), tx, l.entry(c)); err != nil {
+		return err
+	}
+	c.Set(recordedKey, true)
+	return nil
+}
+
+// entry describes the request from the state handlers left in c.
+func (l *Log) entry(c echo.Context) *models.AuditEntry {
+	requestID, _ := c.Get(requestIDKey).(string)
+	entry := &models.AuditEntry{
+		Action:    c.Request().Method + " " + c.Path(),
+		IP:        c.RealIP(),
+		RequestID: requestID,
+	}
+	if userID, ok := auth.UserID(c); ok {
+		entry.ActorID = &userID
+	}
+	entry.TargetType, entry.TargetID = target(c)
+	entry.Before = l.snapshot(c, beforeKey)
+	entry.After = l.snapshot(c, afterKey)
+	return entry
+}
+
+// Before records the state of the request's target before the change.
+func Before(c echo.Context, v interface{}) {
+	c.Set(beforeKey, v)
+}
+
+// After records the state of the request's target after the change.
+func After(c echo.Context, v interface{}) {
+	c.Set(afterKey, v)
+}
+
+// Target names the target when the route does not, e.g. the ID of a
+// created row.
+func Target(c echo.Context, id interface{}) {
+	c.Set(targetKey, fmt.Sprint(id))
+}
+
+// target derives the target from the route: "/users/:user_id/roles/:role_id"
+// targets the user.
+func target(c echo.Context) (string, string) {
+	path := strings.TrimPrefix(c.Path(), "/")
+	targetType, _, _ := strings.Cut(path, "/")
+	if strings.HasPrefix(targetType, ":") {
+		targetType = ""
+	}
+
+	if id, ok := c.Get(targetKey).(string); ok {
+		return targetType, id
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			return targetType, c.Param(name)
+		}
+	}
+	return targetType, ""
+}
+
+func (l *Log) snapshot(c echo.Context, key string) json.RawMessage {
+	v := c.Get(key)
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		l.Logger.WithError(err).Warn("Failed to encode audit snapshot")
+		return nil
+	}
+	return data
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		permission string
+		want       bool
+	}{
+		{name: "access token", ctx: context.Background(), permission: "users.manage", want: true},
+		{name: "scope held", ctx: withScopes(context.Background(), []string{"articles.publish", ScopeArticlesWrite}), permission: ScopeArticlesWrite, want: true},
+		{name: "scope missing", ctx: withScopes(context.Background(), []string{"articles.publish"}), permission: ScopeUsersWrite},
+		{name: "no scopes", ctx: withScopes(context.Background(), nil), permission: "articles.publish"},
+		{name: "prefix of a scope", ctx: withScopes(context.Background(), []string{"articles.publish"}), permission: "articles"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScopeAllows(tt.ctx, tt.permission); got != tt.want {
+				t.Errorf("ScopeAllows(%q) = %t, want %t", tt.permission, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsOwnerScope(t *testing.T) {
+	for scope, want := range map[string]bool{
+		ScopeUsersWrite:    true,
+		ScopeArticlesWrite: true,
+		"users.manage":     false,
+		"":                 false,
+	} {
+		if got := IsOwnerScope(scope); got != want {
+			t.Errorf("IsOwnerScope(%q) = %t, want %t", scope, got, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+const (
+	userIDKey    = "auth.user_id"
+	sessionIDKey = "auth.session_id"
+)
+
+// SessionValidator checks that the session of an access token is still
+// active and records the request as its latest activity. Revoked and expired
+// sessions yield ErrInvalidToken.
+type SessionValidator func(ctx context.Context, sessionID, userID int, ip, userAgent string) error
+
+// Middleware authenticates requests carrying an "Authorization: Bearer"
+// header with an access token, whose session is checked by sessions, or an
+// API key, resolved by keys. Requests without one pass through anonymously;
+// a present but invalid token is rejected.
+func (m *TokenManager) Middleware(keys KeyAuthenticator, sessions SessionValidator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			if header == "" {
+				return next(c)
+			}
+
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unsupported authorization scheme"})
+			}
+
+			if strings.HasPrefix(token, APIKeyPrefix) {
+				userID, scopes, err := keys(c.Request().Context(), token)
+				if errors.Is(err, ErrInvalidToken) {
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid, revoked or expired API key"})
+				}
+				if err != nil {
+					c.Logger().Errorf("Failed to check API key: %v", err)
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check API key"})
+				}
+
+				c.Set(userIDKey, userID)
+				c.SetRequest(c.Request().WithContext(withScopes(c.Request().Context(), scopes)))
+				return next(c)
+			}
+
+			userID, sessionID, err := m.Parse(token)
+			if err == nil {
+				err = sessions(c.Request().Context(), sessionID, userID, c.RealIP(), c.Request().UserAgent())
+			}
+			if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrExpiredToken) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired token"})
+			}
+			if err != nil {
+				c.Logger().Errorf("Failed to check session: %v", err)
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check session"})
+			}
+
+			c.Set(userIDKey, userID)
+			c.Set(sessionIDKey, sessionID)
+			return next(c)
+		}
+	}
+}
+
+// RequireUser rejects anonymous requests.
+func RequireUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := UserID(c); !ok {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+		}
+		return next(c)
+	}
+}
+
+// UserID returns the authenticated user of the request, if any.
+func UserID(c echo.Context) (int, bool) {
+	userID, ok := c.Get(userIDKey).(int)
+	return userID, ok
+}
+
+// SessionID returns the session of a request authenticated with an access
+// token.
+func SessionID(c echo.Context) (int, bool) {
+	sessionID, ok := c.Get(sessionIDKey).(int)
+	return sessionID, ok
+}
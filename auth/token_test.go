@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenManager(t *testing.T) {
+	m := NewTokenManager("secret", time.Hour)
+
+	token, expires := m.Issue(42, 7)
+	if d := time.Until(expires); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("Issue() expires in %s, want about an hour", d)
+	}
+	userID, sessionID, err := m.Parse(token)
+	if err != nil || userID != 42 || sessionID != 7 {
+		t.Fatalf("Parse() = %d, %d, %v, want 42, 7, nil", userID, sessionID, err)
+	}
+
+	challenge, _ := m.IssueChallenge(42)
+	if userID, err = m.ParseChallenge(challenge); err != nil || userID != 42 {
+		t.Fatalf("ParseChallenge() = %d, %v, want 42, nil", userID, err)
+	}
+
+	expired, _ := NewTokenManager("secret", -time.Minute).Issue(42, 7)
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name  string
+		parse func(string) error
+		token string
+		want  error
+	}{
+		{name: "expired", parse: parseToken(m), token: expired, want: ErrExpiredToken},
+		{name: "other secret", parse: parseToken(NewTokenManager("other", time.Hour)), token: token, want: ErrInvalidToken},
+		{name: "changed user", parse: parseToken(m), token: strings.Join(append([]string{"1"}, parts[1:]...), "."), want: ErrInvalidToken},
+		{name: "extended expiry", parse: parseToken(m),
+			token: strings.Join([]string{parts[0], parts[1], strconv.FormatInt(expires.Add(time.Hour).Unix(), 10), parts[3]}, "."), want: ErrInvalidToken},
+		{name: "missing part", parse: parseToken(m), token: strings.Join(parts[:3], "."), want: ErrInvalidToken},
+		{name: "empty", parse: parseToken(m), token: "", want: ErrInvalidToken},
+		{name: "challenge as access token", parse: parseToken(m), token: challenge, want: ErrInvalidToken},
+		{name: "access token as challenge", parse: parseChallenge(m), token: token, want: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.parse(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("parse error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func parseToken(m *TokenManager) func(string) error {
+	return func(token string) error {
+		_, _, err := m.Parse(token)
+		return err
+	}
+}
+
+func parseChallenge(m *TokenManager) func(string) error {
+	return func(token string) error {
+		_, err := m.ParseChallenge(token)
+		return err
+	}
+}
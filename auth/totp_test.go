@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Key is the SHA-1 key of the RFC 6238 test vectors.
+var rfc6238Key = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B lists eight digits; the codes are their last six.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Key, tt.unix/totpPeriod); got != tt.code {
+			t.Errorf("totpCode(%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Key)
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64
+		valid  bool
+	}{
+		{name: "current step", secret: secret, code: totpCode(rfc6238Key, step), step: step, valid: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: totpCode(rfc6238Key, step), step: step, valid: true},
+		{name: "previous step", secret: secret, code: totpCode(rfc6238Key, step-1), step: step - 1, valid: true},
+		{name: "next step", secret: secret, code: totpCode(rfc6238Key, step+1), step: step + 1, valid: true},
+		{name: "outside skew", secret: secret, code: totpCode(rfc6238Key, step-2)},
+		{name: "wrong code", secret: secret, code: "000000"},
+		{name: "too short", secret: secret, code: "50471"},
+		{name: "invalid secret", secret: "not base32!", code: totpCode(rfc6238Key, step)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.valid {
+				t.Fatalf("ValidateTOTP() ok = %t, want %t", ok, tt.valid)
+			}
+			if ok && got != tt.step {
+				t.Errorf("ValidateTOTP() step = %d, want %d", got, tt.step)
+			}
+		})
+	}
+}
@@ -1 +1,208 @@
 package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// BaseURL is the public address of the site, used to build absolute links
+	// in feeds, sitemaps and emails.
+	BaseURL   string
+	SiteTitle string
+
+	FeedSize        int
+	FeedFullContent bool
+
+	// ViewDedupWindow is how long repeated views of an article by the same
+	// visitor are counted only once.
+	ViewDedupWindow   time.Duration
+	ViewFlushInterval time.Duration
+
+	// AnnounceInterval is how often articles scheduled for later are checked
+	// for having gone live, to notify the followers of their authors.
+	AnnounceInterval time.Duration
+
+	// AuthSecret signs access tokens and must be kept private.
+	AuthSecret   string
+	AuthTokenTTL time.Duration
+
+	// Languages articles can be written in; the first one is the default for
+	// new articles and for clients that state no preference.
+	Languages []string
+
+	// TrashRetention is how long deleted articles, comments and users can be
+	// restored before the purge job removes them for good.
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	// MediaDir is where uploaded files are stored; media file paths are
+	// relative to it.
+	MediaDir string
+
+	// ExportDir holds generated personal data archives, which can be
+	// downloaded for ExportTTL after they are built.
+	ExportDir string
+	ExportTTL time.Duration
+
+	// SMTP server for outgoing mail. Without SMTPHost emails are only
+	// logged.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+
+	// How long password reset and email verification links stay valid.
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+
+	// Failed logins are counted per account and per client address within
+	// LoginFailureWindow. Every failure delays the next attempt, starting at
+	// LoginBaseDelay and doubling up to LoginMaxDelay; reaching the maximum
+	// number of failures locks logins for LoginLockout.
+	LoginMaxFailures   int
+	LoginIPMaxFailures int
+	LoginFailureWindow time.Duration
+	LoginLockout       time.Duration
+	LoginBaseDelay     time.Duration
+	LoginMaxDelay      time.Duration
+
+	// TrustedProxies lists the address ranges, in CIDR notation, of reverse
+	// proxies whose X-Forwarded-For header is believed. Without any, the
+	// client address is the one of the connection and the header is ignored.
+	TrustedProxies []string
+
+	// Single sign-on with an OpenID Connect provider, enabled by setting
+	// OIDCIssuer. OIDCRoleMapping maps values of the OIDCGroupsClaim claim to
+	// role names.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	OIDCGroupsClaim  string
+	OIDCRoleMapping  map[string]string
+}
+
+func (c *Config) DefaultLanguage() string {
+	return c.Languages[0]
+}
+
+func Load() *Config {
+	cfg := &Config{
+		BaseURL:         strings.TrimRight(getEnv("BASE_URL", "http://localhost:8080"), "/"),
+		SiteTitle:       getEnv("SITE_TITLE", "GoArticles"),
+		FeedSize:        getEnvInt("FEED_SIZE", 20),
+		FeedFullContent: getEnvBool("FEED_FULL_CONTENT", true),
+
+		ViewDedupWindow:   getEnvDuration("VIEW_DEDUP_WINDOW", 30*time.Minute),
+		ViewFlushInterval: getEnvDuration("VIEW_FLUSH_INTERVAL", 10*time.Second),
+
+		AnnounceInterval: getEnvDuration("ANNOUNCE_INTERVAL", time.Minute),
+
+		AuthSecret:   os.Getenv("AUTH_SECRET"),
+		AuthTokenTTL: getEnvDuration("AUTH_TOKEN_TTL", 24*time.Hour),
+
+		Languages: getEnvList("LANGUAGES", []string{"ru", "en"}),
+
+		TrashRetention:     getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+		TrashPurgeInterval: getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour),
+
+		MediaDir: getEnv("MEDIA_DIR", "uploads"),
+
+		ExportDir: getEnv("EXPORT_DIR", "exports"),
+		ExportTTL: getEnvDuration("EXPORT_TTL", 72*time.Hour),
+
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     getEnvInt("SMTP_PORT", 587),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		MailFrom:     getEnv("MAIL_FROM", "GoArticles <noreply@localhost>"),
+
+		PasswordResetTTL:     getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		EmailVerificationTTL: getEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+
+		LoginMaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures: getEnvInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginFailureWindow: getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LoginLockout:       getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		LoginBaseDelay:     getEnvDuration("LOGIN_BASE_DELAY", time.Second),
+		LoginMaxDelay:      getEnvDuration("LOGIN_MAX_DELAY", 30*time.Second),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES", nil),
+
+		OIDCIssuer:       strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCScopes:       getEnvList("OIDC_SCOPES", []string{"openid", "email", "profile"}),
+		OIDCGroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCRoleMapping:  getEnvMap("OIDC_ROLE_MAPPING"),
+	}
+
+	if cfg.OIDCRedirectURL == "" {
+		cfg.OIDCRedirectURL = cfg.BaseURL + "/login/oidc/callback"
+	}
+	return cfg
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList reads a comma-separated list, skipping blank entries.
+func getEnvList(key string, fallback []string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
+
+// getEnvMap reads a comma-separated list of key=value pairs, skipping
+// entries without a key or value.
+func getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range getEnvList(key, nil) {
+		k, v, _ := strings.Cut(pair, "=")
+		if k, v = strings.TrimSpace(k), strings.TrimSpace(v); k != "" && v != "" {
+			values[k] = v
+		}
+	}
+	return values
+}
@@ -0,0 +1,221 @@
+// Package dbtest provides a scripted database/sql driver for testing
+// handlers without a PostgreSQL server. Statements are answered by the first
+// registered response whose fragment they contain and are recorded so tests
+// can check what was run.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Result is the answer to a statement: rows for queries, a count of affected
+// rows for other statements, or an error.
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+}
+
+// Statement is a statement that was run. Commits and rollbacks are recorded
+// as "COMMIT" and "ROLLBACK".
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+type response struct {
+	fragment string
+	respond  func(args []driver.Value) Result
+}
+
+// DB scripts and records the statements of the *sql.DB returned by New.
+// Statements without a response return no rows and affect none.
+type DB struct {
+	mu         sync.Mutex
+	responses  []response
+	statements []Statement
+}
+
+// New returns a database answering from the returned script.
+func New() (*sql.DB, *DB) {
+	script := &DB{}
+	return sql.OpenDB(connector{script}), script
+}
+
+// Returns answers statements containing fragment with result.
+func (d *DB) Returns(fragment string, result Result) {
+	d.On(fragment, func([]driver.Value) Result { return result })
+}
+
+// On answers statements containing fragment with the result of respond,
+// which gets the statement's arguments.
+func (d *DB) On(fragment string, respond func(args []driver.Value) Result) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.responses = append(d.responses, response{fragment: fragment, respond: respond})
+}
+
+// Statements returns the statements run so far.
+func (d *DB) Statements() []Statement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Statement(nil), d.statements...)
+}
+
+// Ran returns the statements run so far that contain fragment.
+func (d *DB) Ran(fragment string) []Statement {
+	var matched []Statement
+	for _, statement := range d.Statements() {
+		if strings.Contains(statement.Query, fragment) {
+			matched = append(matched, statement)
+		}
+	}
+	return matched
+}
+
+func (d *DB) run(query string, args []driver.NamedValue) Result {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	d.mu.Lock()
+	d.statements = append(d.statements, Statement{Query: query, Args: values})
+	var respond func([]driver.Value) Result
+	for _, r := range d.responses {
+		if strings.Contains(query, r.fragment) {
+			respond = r.respond
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	if respond == nil {
+		return Result{}
+	}
+	return respond(values)
+}
+
+type connector struct {
+	db *DB
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("dbtest: use New")
+}
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return tx{c.db}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result := c.db.run(query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.run(query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return &rows{columns: result.Columns, values: result.Rows}, nil
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
+
+type tx struct {
+	db *DB
+}
+
+func (t tx) Commit() error {
+	return t.db.run("COMMIT", nil).Err
+}
+
+func (t tx) Rollback() error {
+	return t.db.run("ROLLBACK", nil).Err
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
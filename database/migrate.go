@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrate applies every migration from the migrations directory that has not
+// been recorded in schema_migrations yet, in file name order. The site's
+// languages, the default first, are available to migrations as the
+// comma-separated setting app.languages.
+func Migrate(db *sql.DB, languages []string) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		name       TEXT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return fmt.Errorf("failed to read migrations: %v", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var applied bool
+		if err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE name=$1)`, name).Scan(&applied); err != nil {
+			return fmt.Errorf("failed to check migration %s: %v", name, err)
+		}
+		if applied {
+			continue
+		}
+
+		script, err := migrations.ReadFile("migrations/" + name)
+		if err != nil {
+			return fmt.Errorf("failed to read migration %s: %v", name, err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %s: %v", name, err)
+		}
+		if _, err = tx.Exec(`SELECT set_config('app.languages', $1, true)`, strings.Join(languages, ",")); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to prepare migration %s: %v", name, err)
+		}
+		if _, err = tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %s: %v", name, err)
+		}
+		if _, err = tx.Exec(`INSERT INTO schema_migrations (name) VALUES ($1)`, name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %v", name, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %v", name, err)
+		}
+
+		log.Printf("Applied migration %s", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,9 @@
+-- Articles become visible to readers (feeds, sitemaps, listings) once
+-- published_at is set and no longer in the future.
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS published_at TIMESTAMP;
+
+-- Articles from before publishing existed were all public; keep them so.
+UPDATE articles SET published_at = COALESCE(created_at, NOW()) WHERE published_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_articles_published_at ON articles (published_at DESC) WHERE published_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_articles_author_id ON articles (author_id);
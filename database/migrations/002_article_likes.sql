@@ -0,0 +1,8 @@
+ALTER TABLE article_likes ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW();
+CREATE UNIQUE INDEX IF NOT EXISTS idx_article_likes_article_user ON article_likes (article_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_article_likes_user ON article_likes (user_id, created_at DESC);
+
+-- likes_count is maintained by the like/unlike handlers in the same
+-- transaction as the article_likes row so reads never have to count.
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS likes_count INTEGER NOT NULL DEFAULT 0;
+UPDATE articles a SET likes_count = (SELECT COUNT(*) FROM article_likes l WHERE l.article_id = a.id);
@@ -0,0 +1,15 @@
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS views_count BIGINT NOT NULL DEFAULT 0;
+
+-- Per-day activity used for trending and popular rankings. Views are added
+-- by the view tracker on flush, likes and comments are recomputed from their
+-- own tables by the periodic rollup.
+CREATE TABLE IF NOT EXISTS article_daily_stats (
+    article_id INTEGER NOT NULL REFERENCES articles (id) ON DELETE CASCADE,
+    day        DATE    NOT NULL,
+    views      BIGINT  NOT NULL DEFAULT 0,
+    likes      INTEGER NOT NULL DEFAULT 0,
+    comments   INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (article_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_article_daily_stats_day ON article_daily_stats (day);
@@ -0,0 +1,6 @@
+-- Trigram similarity of titles is one of the signals for related articles.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_articles_title_trgm ON articles USING gin (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_article_tags_tag ON article_tags (tag_id);
+CREATE INDEX IF NOT EXISTS idx_article_categories_category ON article_categories (category_id);
@@ -0,0 +1,12 @@
+-- A follower can follow authors (users), categories and tags. target_id
+-- refers to users, categories or tags depending on target_type.
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    target_type TEXT      NOT NULL CHECK (target_type IN ('author', 'category', 'tag')),
+    target_id   INTEGER   NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, target_type, target_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_follows_target ON follows (target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_articles_published_id ON articles (published_at DESC, id DESC) WHERE published_at IS NOT NULL;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS bookmarks (
+    user_id    INTEGER   NOT NULL REFERENCES users (id),
+    article_id INTEGER   NOT NULL REFERENCES articles (id),
+    is_read    BOOLEAN   NOT NULL DEFAULT FALSE,
+    read_at    TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, article_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_article ON bookmarks (article_id);
+
+CREATE TABLE IF NOT EXISTS bookmark_collections (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER   NOT NULL REFERENCES users (id),
+    name        TEXT      NOT NULL,
+    description TEXT      NOT NULL DEFAULT '',
+    is_public   BOOLEAN   NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, name)
+);
+
+-- position is 1-based and kept contiguous within a collection.
+CREATE TABLE IF NOT EXISTS bookmark_collection_items (
+    collection_id INTEGER   NOT NULL REFERENCES bookmark_collections (id) ON DELETE CASCADE,
+    article_id    INTEGER   NOT NULL REFERENCES articles (id),
+    position      INTEGER   NOT NULL,
+    added_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (collection_id, article_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bookmark_collection_items_article ON bookmark_collection_items (article_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS series (
+    id          SERIAL PRIMARY KEY,
+    author_id   INTEGER   NOT NULL REFERENCES users (id),
+    title       TEXT      NOT NULL,
+    description TEXT      NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_series_author ON series (author_id);
+
+-- An article belongs to at most one series; position is 1-based and kept
+-- contiguous within the series.
+CREATE TABLE IF NOT EXISTS series_articles (
+    series_id  INTEGER NOT NULL REFERENCES series (id) ON DELETE CASCADE,
+    article_id INTEGER NOT NULL UNIQUE REFERENCES articles (id),
+    position   INTEGER NOT NULL,
+    PRIMARY KEY (series_id, article_id)
+);
@@ -0,0 +1,18 @@
+-- Everyone who worked on an article. The primary author (articles.author_id)
+-- is listed with role 'author'; others are invited and must accept.
+CREATE TABLE IF NOT EXISTS article_contributors (
+    article_id   INTEGER   NOT NULL REFERENCES articles (id),
+    user_id      INTEGER   NOT NULL REFERENCES users (id),
+    role         TEXT      NOT NULL CHECK (role IN ('author', 'co-author', 'editor', 'translator')),
+    status       TEXT      NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined')),
+    invited_by   INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+    responded_at TIMESTAMP,
+    PRIMARY KEY (article_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_article_contributors_user ON article_contributors (user_id, status);
+
+INSERT INTO article_contributors (article_id, user_id, role, status, responded_at)
+SELECT id, author_id, 'author', 'accepted', created_at FROM articles
+ON CONFLICT (article_id, user_id) DO NOTHING;
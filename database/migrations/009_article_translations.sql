@@ -0,0 +1,33 @@
+-- Every article is written in one language. Translations of the same text
+-- share a translation_group, which is the ID of the article they were
+-- translated from; an article nobody was translated from yet has none and is
+-- its own group.
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT 'ru';
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS translation_group INTEGER;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS slug TEXT;
+
+-- Existing articles get a slug from their title; the ID suffix keeps them
+-- unique without having to resolve collisions here.
+UPDATE articles
+SET slug = COALESCE(NULLIF(TRIM(BOTH '-' FROM LOWER(REGEXP_REPLACE(title, '[^[:alnum:]]+', '-', 'g'))), ''), 'article') || '-' || id
+WHERE slug IS NULL;
+ALTER TABLE articles ALTER COLUMN slug SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_articles_translation_language ON articles ((COALESCE(translation_group, id)), language);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_articles_language_slug ON articles (language, slug);
+
+-- Localized names; the name stored on the category or tag itself is used for
+-- languages without a translation.
+CREATE TABLE IF NOT EXISTS category_translations (
+    category_id INTEGER NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    language    TEXT    NOT NULL,
+    name        TEXT    NOT NULL,
+    PRIMARY KEY (category_id, language)
+);
+
+CREATE TABLE IF NOT EXISTS tag_translations (
+    tag_id   INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    language TEXT    NOT NULL,
+    name     TEXT    NOT NULL,
+    PRIMARY KEY (tag_id, language)
+);
@@ -0,0 +1,20 @@
+-- Deleted articles, comments and users stay in the trash until they are
+-- restored or purged by the retention job.
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_articles_deleted_at ON articles (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_deleted_at ON comments (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Holders of trash.manage see and restore everything in the trash, not only
+-- their own items; users.manage allows deleting other users' accounts.
+INSERT INTO permissions (name)
+SELECT name FROM (VALUES ('trash.manage'), ('users.manage')) AS p (name)
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permissions.name = p.name);
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name IN ('trash.manage', 'users.manage')
+  AND NOT EXISTS (SELECT 1 FROM role_permissions rp WHERE rp.role_id = r.id AND rp.permission_id = p.id);
@@ -0,0 +1,20 @@
+-- Personal data exports. Rows are kept after the archive expires and serve as
+-- the audit trail of who requested and downloaded whose data.
+CREATE TABLE IF NOT EXISTS user_exports (
+    id                 SERIAL PRIMARY KEY,
+    user_id            INTEGER   NOT NULL,
+    requested_by       INTEGER   NOT NULL,
+    status             TEXT      NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'ready', 'failed', 'expired')),
+    token_hash         TEXT      NOT NULL UNIQUE,
+    file_path          TEXT,
+    size_bytes         BIGINT,
+    error              TEXT,
+    created_at         TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at       TIMESTAMP,
+    expires_at         TIMESTAMP,
+    download_count     INTEGER   NOT NULL DEFAULT 0,
+    last_downloaded_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_exports_user ON user_exports (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_user_exports_status ON user_exports (status);
@@ -0,0 +1,10 @@
+-- Public profile fields. avatar_path is relative to the media directory.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT   NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio          TEXT   NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_path  TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS links        TEXT[] NOT NULL DEFAULT '{}';
+
+-- Usernames and emails are compared case-insensitively when checking that
+-- they are free.
+CREATE INDEX IF NOT EXISTS idx_users_username_lower ON users (LOWER(username));
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email));
@@ -0,0 +1,30 @@
+-- TOTP two-factor authentication. totp_secret is set on enrollment and only
+-- takes effect once totp_enabled_at is set by confirming a code;
+-- totp_last_step is the last accepted time step, so codes cannot be replayed.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret     TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step  BIGINT NOT NULL DEFAULT 0;
+
+-- One-time recovery codes, stored hashed.
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash  TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    used_at    TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user ON user_recovery_codes (user_id) WHERE used_at IS NULL;
+
+-- Members of a role requiring 2FA get its permissions only once they have
+-- enabled it.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS require_2fa BOOLEAN NOT NULL DEFAULT FALSE;
+
+INSERT INTO permissions (name)
+SELECT 'roles.manage'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE name = 'roles.manage');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'roles.manage'
+  AND NOT EXISTS (SELECT 1 FROM role_permissions rp WHERE rp.role_id = r.id AND rp.permission_id = p.id);
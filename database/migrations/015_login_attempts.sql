@@ -0,0 +1,11 @@
+-- Failed logins per account ("user:<id>") and per client address
+-- ("ip:<address>"). Kept in the database so that every instance sees the
+-- same counters.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key             TEXT PRIMARY KEY,
+    failures        INTEGER   NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_until    TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_last_failure ON login_attempts (last_failure_at);
@@ -0,0 +1,24 @@
+-- Accounts at OpenID Connect providers linked to users, identified by the
+-- provider's issuer and the subject it assigns.
+CREATE TABLE IF NOT EXISTS user_identities (
+    id            SERIAL PRIMARY KEY,
+    user_id       INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    issuer        TEXT      NOT NULL,
+    subject       TEXT      NOT NULL,
+    email         TEXT      NOT NULL DEFAULT '',
+    created_at    TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities (user_id);
+
+-- Pending single sign-on logins between the redirect to the provider and the
+-- callback. Kept in the database so that any instance can handle the
+-- callback.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state_hash    TEXT PRIMARY KEY,
+    nonce         TEXT      NOT NULL,
+    code_verifier TEXT      NOT NULL,
+    expires_at    TIMESTAMP NOT NULL
+);
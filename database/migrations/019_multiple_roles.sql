@@ -0,0 +1,13 @@
+-- Users hold any set of roles; each role at most once.
+DELETE FROM user_roles a USING user_roles b
+WHERE a.ctid < b.ctid AND a.user_id = b.user_id AND a.role_id = b.role_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_roles_unique ON user_roles (user_id, role_id);
+
+-- The role new accounts get, chosen by name via PUT /roles/default. Role 3
+-- used to be hard-coded.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_roles_default ON roles (is_default) WHERE is_default;
+
+UPDATE roles SET is_default = TRUE
+WHERE id = 3 AND NOT EXISTS (SELECT 1 FROM roles WHERE is_default);
@@ -0,0 +1,27 @@
+-- Roles and permissions are managed through the API. System roles and
+-- permissions are built in: the code refers to them by name, so they cannot
+-- be renamed or deleted.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS description TEXT    NOT NULL DEFAULT '';
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS is_system   BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS description TEXT    NOT NULL DEFAULT '';
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS is_system   BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A role inherits all permissions of its parent and the parent's ancestors,
+-- e.g. editor -> author -> reader.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES roles (id) ON DELETE SET NULL;
+
+UPDATE roles SET is_system = TRUE WHERE name = 'admin' OR is_default;
+
+UPDATE permissions SET is_system = TRUE,
+    description = CASE name
+        WHEN 'trash.manage' THEN 'See, restore and purge everybody''s deleted content'
+        WHEN 'users.manage' THEN 'Edit, delete and unlock any account'
+        WHEN 'roles.manage' THEN 'Manage roles, permissions and role assignments'
+    END
+WHERE name IN ('trash.manage', 'users.manage', 'roles.manage') AND description = '';
+
+-- Linking a permission to a role twice changes nothing.
+DELETE FROM role_permissions a USING role_permissions b
+WHERE a.ctid < b.ctid AND a.role_id = b.role_id AND a.permission_id = b.permission_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_role_permissions_unique ON role_permissions (role_id, permission_id);
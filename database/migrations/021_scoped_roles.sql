@@ -0,0 +1,18 @@
+-- Categories can be nested; a role scoped to a category also covers the
+-- categories below it.
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES categories (id) ON DELETE SET NULL;
+
+-- Role assignments scoped to a category only grant their permissions for
+-- articles in that category or below it. Unscoped assignments apply
+-- everywhere.
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories (id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS idx_user_roles_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_roles_scope ON user_roles (user_id, role_id, COALESCE(category_id, 0));
+
+-- Permissions checked per article, so they can be granted for a section.
+INSERT INTO permissions (name, description, is_system)
+SELECT v.name, v.description, TRUE
+FROM (VALUES ('articles.edit', 'Edit other users'' articles'),
+             ('articles.publish', 'Publish and unpublish other users'' articles')) AS v (name, description)
+WHERE NOT EXISTS (SELECT 1 FROM permissions p WHERE p.name = v.name);
@@ -0,0 +1,47 @@
+-- Who changed what, written for every successful mutating request. Each
+-- entry holds the hash of the previous one, so edits and removals show up
+-- when the chain is verified. actor_id has no foreign key: entries outlive
+-- purged accounts. before and after are json, not jsonb, to keep the exact
+-- text that was hashed.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          BIGSERIAL PRIMARY KEY,
+    created_at  TIMESTAMP NOT NULL,
+    actor_id    INTEGER,
+    action      TEXT      NOT NULL,
+    target_type TEXT      NOT NULL DEFAULT '',
+    target_id   TEXT      NOT NULL DEFAULT '',
+    before      JSON,
+    after       JSON,
+    ip          TEXT      NOT NULL DEFAULT '',
+    request_id  TEXT      NOT NULL DEFAULT '',
+    prev_hash   TEXT      NOT NULL,
+    hash        TEXT      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (target_type, target_id, id);
+
+-- The log is append-only.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_change ON audit_log;
+CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+INSERT INTO permissions (name, description, is_system)
+SELECT 'audit.read', 'Read and export the audit log', TRUE
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE name = 'audit.read');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'audit.read'
+ON CONFLICT (role_id, permission_id) DO NOTHING;
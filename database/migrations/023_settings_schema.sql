@@ -0,0 +1,23 @@
+-- Every setting declares the type of its values, which decides how values
+-- are validated and returned: bool, int (between min_value and max_value if
+-- set), enum (one of allowed_values), string (matching pattern in full if
+-- set) or json.
+ALTER TABLE settings ADD COLUMN IF NOT EXISTS value_type     TEXT   NOT NULL DEFAULT 'string'
+    CHECK (value_type IN ('bool', 'int', 'enum', 'string', 'json'));
+ALTER TABLE settings ADD COLUMN IF NOT EXISTS description    TEXT   NOT NULL DEFAULT '';
+ALTER TABLE settings ADD COLUMN IF NOT EXISTS allowed_values TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE settings ADD COLUMN IF NOT EXISTS pattern        TEXT   NOT NULL DEFAULT '';
+ALTER TABLE settings ADD COLUMN IF NOT EXISTS min_value      BIGINT;
+ALTER TABLE settings ADD COLUMN IF NOT EXISTS max_value      BIGINT;
+
+-- Existing settings were untyped. Those whose default and stored values all
+-- look like booleans or integers get that type; the rest stay strings.
+UPDATE settings s SET value_type = 'bool'
+WHERE s.value_type = 'string' AND s.default_value IN ('true', 'false')
+  AND NOT EXISTS (SELECT 1 FROM user_settings us
+                  WHERE us.setting_key = s.setting_key AND us.setting_value NOT IN ('true', 'false'));
+
+UPDATE settings s SET value_type = 'int'
+WHERE s.value_type = 'string' AND s.default_value ~ '^-?[0-9]{1,18}$'
+  AND NOT EXISTS (SELECT 1 FROM user_settings us
+                  WHERE us.setting_key = s.setting_key AND us.setting_value !~ '^-?[0-9]{1,18}$');
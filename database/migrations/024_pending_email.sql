@@ -0,0 +1,3 @@
+-- A new email address only replaces the current one once it is confirmed
+-- through the link sent to it; until then it waits here.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email TEXT;
@@ -0,0 +1,9 @@
+-- 009 labeled every existing article 'ru' through the column default,
+-- whatever the site's languages were. Articles in a language the site does not
+-- offer get its default language instead, and the default goes away: articles
+-- are always created with an explicit language.
+UPDATE articles
+SET language = split_part(current_setting('app.languages'), ',', 1)
+WHERE current_setting('app.languages') <> ''
+  AND language <> ALL (string_to_array(current_setting('app.languages'), ','));
+ALTER TABLE articles ALTER COLUMN language DROP DEFAULT;
@@ -0,0 +1,11 @@
+-- The hash of the newest audit_log entry. Appends lock this one row rather
+-- than the whole log, which lets handlers write their entry in the
+-- transaction that makes the change.
+CREATE TABLE IF NOT EXISTS audit_chain_head (
+    id   BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    hash TEXT    NOT NULL
+);
+
+INSERT INTO audit_chain_head (hash)
+SELECT COALESCE((SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1), '')
+ON CONFLICT (id) DO NOTHING;
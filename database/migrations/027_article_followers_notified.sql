@@ -0,0 +1,10 @@
+-- When the followers of an article's author were told about it. Articles
+-- scheduled for later are announced by a periodic check once they go live;
+-- the column keeps them from being announced twice.
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS followers_notified_at TIMESTAMP;
+
+UPDATE articles SET followers_notified_at = published_at
+WHERE followers_notified_at IS NULL AND published_at <= NOW();
+
+CREATE INDEX IF NOT EXISTS idx_articles_unannounced ON articles (published_at)
+    WHERE followers_notified_at IS NULL AND published_at IS NOT NULL;
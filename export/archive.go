@@ -0,0 +1,339 @@
+package export
+
+import (
+	"GoArticles/models"
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// articleExport is an article with the metadata that goes into the front
+// matter of its Markdown file.
+type articleExport struct {
+	models.Article
+	DeletedAt  *time.Time
+	Tags       []string
+	Categories []string
+}
+
+// writeArchive writes a ZIP archive with everything stored about the user:
+//
+//	profile.json, avatar.<ext>, settings.json, roles.json
+//	articles/<id>-<slug>.md    articles with YAML front matter
+//	comments.json, likes.json, notifications.json
+//	media.json, media/<article id>/<file>
+func (e *Exporter) writeArchive(ctx context.Context, w io.Writer, userID int) error {
+	zw := zip.NewWriter(w)
+
+	sections := []func(context.Context, *zip.Writer, int) error{
+		e.writeProfile,
+		e.writeSettings,
+		e.writeRoles,
+		e.writeArticles,
+		e.writeComments,
+		e.writeLikes,
+		e.writeNotifications,
+		e.writeMedia,
+	}
+	for _, write := range sections {
+		if err := write(ctx, zw, userID); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (e *Exporter) writeProfile(ctx context.Context, zw *zip.Writer, userID int) error {
+	var (
+		user       models.User
+		avatarPath sql.NullString
+	)
+	query := `SELECT id, username, email, created_at, updated_at, display_name, bio, avatar_path, links
+	          FROM users WHERE id=$1`
+	err := e.DB.QueryRowContext(ctx, query, userID).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt,
+		&user.DisplayName, &user.Bio, &avatarPath, pq.Array(&user.Links))
+	if err != nil {
+		return fmt.Errorf("profile: %w", err)
+	}
+	if err = writeJSON(zw, "profile.json", user); err != nil {
+		return err
+	}
+
+	if avatarPath.Valid {
+		err = e.copyFile(zw, "avatar"+path.Ext(avatarPath.String), avatarPath.String)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("avatar: %w", err)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) writeSettings(ctx context.Context, zw *zip.Writer, userID int) error {
+	settings := []models.UserSettings{}
+	query := `SELECT user_id, setting_key, setting_value FROM user_settings WHERE user_id=$1 ORDER BY setting_key`
+	err := e.queryAll(ctx, query, userID, func(rows *sql.Rows) error {
+		var (
+			setting models.UserSettings
+			value   string
+		)
+		if err := rows.Scan(&setting.UserID, &setting.SettingKey, &value); err != nil {
+			return err
+		}
+		setting.SettingValue = value
+		settings = append(settings, setting)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("settings: %w", err)
+	}
+	return writeJSON(zw, "settings.json", settings)
+}
+
+func (e *Exporter) writeRoles(ctx context.Context, zw *zip.Writer, userID int) error {
+	roles := []models.Role{}
+	query := `SELECT r.id, r.name FROM roles r JOIN user_roles ur ON ur.role_id = r.id
+	          WHERE ur.user_id=$1 ORDER BY r.id`
+	err := e.queryAll(ctx, query, userID, func(rows *sql.Rows) error {
+		var role models.Role
+		if err := rows.Scan(&role.ID, &role.Name); err != nil {
+			return err
+		}
+		roles = append(roles, role)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("roles: %w", err)
+	}
+	return writeJSON(zw, "roles.json", roles)
+}
+
+// writeArticles exports every article the user authored, including drafts and
+// articles in the trash.
+func (e *Exporter) writeArticles(ctx context.Context, zw *zip.Writer, userID int) error {
+	var articles []articleExport
+	query := `SELECT a.id, a.title, a.content, a.created_at, a.updated_at, a.published_at, a.deleted_at,
+	                 a.language, a.slug,
+	                 ARRAY(SELECT t.name FROM article_tags x JOIN tags t ON t.id = x.tag_id
+	                       WHERE x.article_id = a.id ORDER BY t.name),
+	                 ARRAY(SELECT c.name FROM article_categories x JOIN categories c ON c.id = x.category_id
+	                       WHERE x.article_id = a.id ORDER BY c.name)
+	          FROM articles a WHERE a.author_id=$1 ORDER BY a.id`
+	err := e.queryAll(ctx, query, userID, func(rows *sql.Rows) error {
+		var article articleExport
+		err := rows.Scan(&article.ID, &article.Title, &article.Content, &article.CreatedAt, &article.UpdatedAt,
+			&article.PublishedAt, &article.DeletedAt, &article.Language, &article.Slug,
+			pq.Array(&article.Tags), pq.Array(&article.Categories))
+		if err != nil {
+			return err
+		}
+		articles = append(articles, article)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("articles: %w", err)
+	}
+
+	for _, article := range articles {
+		f, err := zw.Create(fmt.Sprintf("articles/%d-%s.md", article.ID, article.Slug))
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(f, markdown(article)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) writeComments(ctx context.Context, zw *zip.Writer, userID int) error {
+	comments := []models.Comment{}
+	query := `SELECT id, article_id, user_id, content, created_at, updated_at FROM comments WHERE user_id=$1 ORDER BY id`
+	err := e.queryAll(ctx, query, userID, func(rows *sql.Rows) error {
+		var comment models.Comment
+		if err := rows.Scan(&comment.ID, &comment.ArticleID, &comment.UserID, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt); err != nil {
+			return err
+		}
+		comments = append(comments, comment)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("comments: %w", err)
+	}
+	return writeJSON(zw, "comments.json", comments)
+}
+
+func (e *Exporter) writeLikes(ctx context.Context, zw *zip.Writer, userID int) error {
+	likes := struct {
+		Articles []models.ArticleLike `json:"articles"`
+		Comments []models.CommentLike `json:"comments"`
+	}{[]models.ArticleLike{}, []models.CommentLike{}}
+
+	query := `SELECT article_id, user_id, created_at FROM article_likes WHERE user_id=$1 ORDER BY created_at`
+	err := e.queryAll(ctx, query, userID, func(rows *sql.Rows) error {
+		var like models.ArticleLike
+		if err := rows.Scan(&like.ArticleID, &like.UserID, &like.CreatedAt); err != nil {
+			return err
+		}
+		likes.Articles = append(likes.Articles, like)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("article likes: %w", err)
+	}
+
+	query = `SELECT comment_id, user_id FROM comment_likes WHERE user_id=$1 ORDER BY comment_id`
+	err = e.queryAll(ctx, query, userID, func(rows *sql.Rows) error {
+		var like models.CommentLike
+		if err := rows.Scan(&like.CommentID, &like.UserID); err != nil {
+			return err
+		}
+		likes.Comments = append(likes.Comments, like)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("comment likes: %w", err)
+	}
+
+	return writeJSON(zw, "likes.json", likes)
+}
+
+func (e *Exporter) writeNotifications(ctx context.Context, zw *zip.Writer, userID int) error {
+	notifications := []models.Notification{}
+	query := `SELECT id, user_id, message, is_read, created_at FROM notifications WHERE user_id=$1 ORDER BY id`
+	err := e.queryAll(ctx, query, userID, func(rows *sql.Rows) error {
+		var notification models.Notification
+		if err := rows.Scan(&notification.ID, &notification.UserID, &notification.Message, &notification.IsRead, &notification.CreatedAt); err != nil {
+			return err
+		}
+		notifications = append(notifications, notification)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("notifications: %w", err)
+	}
+	return writeJSON(zw, "notifications.json", notifications)
+}
+
+// writeMedia lists the media of the user's articles and copies the uploaded
+// files. Files missing on disk are only listed.
+func (e *Exporter) writeMedia(ctx context.Context, zw *zip.Writer, userID int) error {
+	media := []models.Media{}
+	query := `SELECT m.id, m.article_id, m.file_path, m.file_type, m.created_at
+	          FROM media m JOIN articles a ON a.id = m.article_id
+	          WHERE a.author_id=$1 ORDER BY m.id`
+	err := e.queryAll(ctx, query, userID, func(rows *sql.Rows) error {
+		var m models.Media
+		if err := rows.Scan(&m.ID, &m.ArticleID, &m.FilePath, &m.FileType, &m.CreatedAt); err != nil {
+			return err
+		}
+		media = append(media, m)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("media: %w", err)
+	}
+
+	if err = writeJSON(zw, "media.json", media); err != nil {
+		return err
+	}
+
+	for _, m := range media {
+		name := fmt.Sprintf("media/%d/%d-%s", m.ArticleID, m.ID, filepath.Base(m.FilePath))
+		if err = e.copyFile(zw, name, m.FilePath); err != nil {
+			if os.IsNotExist(err) {
+				e.Logger.Warnf("Media file %q of media ID %d is missing, skipping it", m.FilePath, m.ID)
+				continue
+			}
+			return fmt.Errorf("media ID %d: %w", m.ID, err)
+		}
+	}
+	return nil
+}
+
+// copyFile adds an uploaded file to the archive. The path is resolved inside
+// the media directory, whatever it contains.
+func (e *Exporter) copyFile(zw *zip.Writer, name, path string) error {
+	src, err := os.Open(filepath.Join(e.mediaDir, filepath.Clean("/"+path)))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func (e *Exporter) queryAll(ctx context.Context, query string, userID int, scan func(*sql.Rows) error) error {
+	rows, err := e.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// markdown renders the article as Markdown with YAML front matter. Strings are
+// double-quoted, which YAML reads the same way as Go escapes them.
+func markdown(article articleExport) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %d\n", article.ID)
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(article.Title))
+	fmt.Fprintf(&b, "slug: %s\n", strconv.Quote(article.Slug))
+	fmt.Fprintf(&b, "language: %s\n", strconv.Quote(article.Language))
+	fmt.Fprintf(&b, "created_at: %s\n", article.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "updated_at: %s\n", article.UpdatedAt.Format(time.RFC3339))
+	if article.PublishedAt != nil {
+		fmt.Fprintf(&b, "published_at: %s\n", article.PublishedAt.Format(time.RFC3339))
+	}
+	if article.DeletedAt != nil {
+		fmt.Fprintf(&b, "deleted_at: %s\n", article.DeletedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "tags: %s\n", quoteList(article.Tags))
+	fmt.Fprintf(&b, "categories: %s\n", quoteList(article.Categories))
+	b.WriteString("---\n\n")
+	b.WriteString(article.Content)
+	if !strings.HasSuffix(article.Content, "\n") {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
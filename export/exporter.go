@@ -0,0 +1,196 @@
+package export
+
+import (
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sweepInterval controls how often expired archives are removed and exports
+// missed by the queue are picked up.
+const sweepInterval = 10 * time.Minute
+
+// Exporter builds personal data archives in the background. Requests are
+// queued in memory; exports still pending after a restart or a full queue
+// are found by the periodic sweep.
+type Exporter struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+
+	dir      string
+	mediaDir string
+	ttl      time.Duration
+
+	queue chan int
+}
+
+func NewExporter(db *sql.DB, logger *logrus.Logger, dir, mediaDir string, ttl time.Duration) *Exporter {
+	return &Exporter{
+		DB:       db,
+		Logger:   logger,
+		dir:      dir,
+		mediaDir: mediaDir,
+		ttl:      ttl,
+		queue:    make(chan int, 64),
+	}
+}
+
+// Enqueue schedules the export for building without blocking.
+func (e *Exporter) Enqueue(exportID int) {
+	select {
+	case e.queue <- exportID:
+	default:
+		e.Logger.Warnf("Export queue is full, export ID %d waits for the next sweep", exportID)
+	}
+}
+
+// Run builds queued exports until ctx is cancelled.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	e.sweep(ctx)
+
+	for {
+		select {
+		case id := <-e.queue:
+			e.build(ctx, id)
+		case <-ticker.C:
+			e.sweep(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep deletes archives past their expiry and builds pending exports.
+func (e *Exporter) sweep(ctx context.Context) {
+	rows, err := e.DB.QueryContext(ctx, `SELECT id, file_path FROM user_exports WHERE status = $1 AND expires_at < NOW()`, models.ExportStatusReady)
+	if err != nil {
+		e.Logger.WithError(err).Error("Failed to find expired exports")
+	} else {
+		expired := map[int]string{}
+		for rows.Next() {
+			var (
+				id   int
+				path string
+			)
+			if err = rows.Scan(&id, &path); err != nil {
+				e.Logger.WithError(err).Error("Failed to scan expired export")
+				break
+			}
+			expired[id] = path
+		}
+		rows.Close()
+
+		for id, path := range expired {
+			if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+				e.Logger.WithError(err).Errorf("Failed to remove archive of export ID %d", id)
+				continue
+			}
+			query := `UPDATE user_exports SET status = $1, file_path = NULL WHERE id=$2`
+			if _, err = e.DB.ExecContext(ctx, query, models.ExportStatusExpired, id); err != nil {
+				e.Logger.WithError(err).Errorf("Failed to mark export ID %d as expired", id)
+			}
+		}
+	}
+
+	rows, err = e.DB.QueryContext(ctx, `SELECT id FROM user_exports WHERE status = $1 ORDER BY id`, models.ExportStatusPending)
+	if err != nil {
+		e.Logger.WithError(err).Error("Failed to find pending exports")
+		return
+	}
+	var pending []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			e.Logger.WithError(err).Error("Failed to scan pending export")
+			break
+		}
+		pending = append(pending, id)
+	}
+	rows.Close()
+
+	for _, id := range pending {
+		e.build(ctx, id)
+	}
+}
+
+// build writes the archive of a pending export. Exports that were built in
+// the meantime are skipped, so an ID may safely be both queued and swept.
+func (e *Exporter) build(ctx context.Context, exportID int) {
+	var userID int
+	query := `SELECT user_id FROM user_exports WHERE id=$1 AND status = $2`
+	err := e.DB.QueryRowContext(ctx, query, exportID, models.ExportStatusPending).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		e.Logger.WithError(err).Errorf("Failed to load export ID %d", exportID)
+		return
+	}
+
+	e.Logger.Infof("Building export ID %d for user ID %d", exportID, userID)
+
+	path := filepath.Join(e.dir, fmt.Sprintf("user-%d-export-%d.zip", userID, exportID))
+	size, err := e.writeFile(ctx, path, userID)
+	if err != nil {
+		e.Logger.WithError(err).Errorf("Failed to build export ID %d", exportID)
+		query = `UPDATE user_exports SET status = $1, error = $2, completed_at = NOW() WHERE id=$3`
+		if _, err = e.DB.ExecContext(ctx, query, models.ExportStatusFailed, err.Error(), exportID); err != nil {
+			e.Logger.WithError(err).Errorf("Failed to mark export ID %d as failed", exportID)
+		}
+		return
+	}
+
+	query = `UPDATE user_exports SET status = $1, file_path = $2, size_bytes = $3, completed_at = NOW(), expires_at = $4
+	         WHERE id=$5`
+	result, err := e.DB.ExecContext(ctx, query, models.ExportStatusReady, path, size, time.Now().Add(e.ttl), exportID)
+	if err != nil {
+		e.Logger.WithError(err).Errorf("Failed to mark export ID %d as ready", exportID)
+		os.Remove(path)
+		return
+	}
+	// The user was purged while the archive was being built.
+	if n, _ := result.RowsAffected(); n == 0 {
+		e.Logger.Infof("Export ID %d was deleted while being built", exportID)
+		os.Remove(path)
+		return
+	}
+
+	e.Logger.Infof("Export ID %d is ready (%d bytes)", exportID, size)
+}
+
+// writeFile writes the archive next to path and renames it into place once
+// complete, so a half-written archive is never served.
+func (e *Exporter) writeFile(ctx context.Context, path string, userID int) (int64, error) {
+	if err := os.MkdirAll(e.dir, 0o700); err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(e.dir, "export-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = e.writeArchive(ctx, tmp, userID); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err = tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	return info.Size(), os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,80 @@
+package feeds
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	FormatRSS  = "rss"
+	FormatAtom = "atom"
+	FormatJSON = "json"
+)
+
+// Feed is a format-independent description of a syndication feed. All links
+// are expected to be absolute. Language is left empty for feeds mixing
+// several languages.
+type Feed struct {
+	Title       string
+	Description string
+	Link        string
+	FeedURL     string
+	Language    string
+	Updated     time.Time
+	Items       []Item
+}
+
+type Item struct {
+	ID        string
+	Title     string
+	Link      string
+	Author    string
+	Content   string
+	Summary   string
+	Published time.Time
+	Updated   time.Time
+}
+
+// Render encodes the feed in the requested format and returns the body along
+// with the matching content type.
+func Render(f Feed, format string) ([]byte, string, error) {
+	switch format {
+	case FormatRSS:
+		body, err := RSS(f)
+		return body, "application/rss+xml; charset=utf-8", err
+	case FormatAtom:
+		body, err := Atom(f)
+		return body, "application/atom+xml; charset=utf-8", err
+	case FormatJSON:
+		body, err := JSON(f)
+		return body, "application/feed+json; charset=utf-8", err
+	default:
+		return nil, "", fmt.Errorf("unsupported feed format %q", format)
+	}
+}
+
+func IsFormat(format string) bool {
+	return format == FormatRSS || format == FormatAtom || format == FormatJSON
+}
+
+// Excerpt shortens text to at most limit runes, cutting at the last word
+// boundary and appending an ellipsis when something was removed.
+func Excerpt(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+
+	cut := limit
+	for cut > 0 && !unicode.IsSpace(runes[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		cut = limit
+	}
+
+	return strings.TrimRightFunc(string(runes[:cut]), unicode.IsPunct) + "…"
+}
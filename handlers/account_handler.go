@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/mailer"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const minPasswordLength = 8
+
+// AccountHandler manages credentials: password changes and resets and the
+// verification of email addresses.
+type AccountHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+	Config *config.Config
+	Mailer mailer.Mailer
+}
+
+func NewAccountHandler(db *sql.DB, logger *logrus.Logger, cfg *config.Config, mail mailer.Mailer) *AccountHandler {
+	return &AccountHandler{
+		DB:     db,
+		Logger: logger,
+		Config: cfg,
+		Mailer: mail,
+	}
+}
+
+// ChangePassword sets a new password for the current user, who has to
+// confirm the current one. Pending reset links stop working.
+func (h *AccountHandler) ChangePassword(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	if currentUserID, _ := auth.UserID(c); currentUserID != id {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Users can only change their own password"})
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err = c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+	if len(input.NewPassword) < minPasswordLength {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+	}
+
+	ctx := c.Request().Context()
+	var passwordHash string
+	query := `SELECT password_hash FROM users WHERE id=$1 AND deleted_at IS NULL`
+	if err = h.DB.QueryRowContext(ctx, query, id).Scan(&passwordHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to fetch password of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to change password"})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(input.CurrentPassword)) != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Current password is incorrect"})
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+	currentSessionID, _ := auth.SessionID(c)
+	if err = setPassword(ctx, tx, id, input.NewPassword, currentSessionID); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to change password of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to change password"})
+	}
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithField("user_id", id).Info("Password changed")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ForgotPassword emails a password reset link to the address if it is the
+// verified address of an account. The response is the same either way so that it does not
+// reveal which addresses are registered.
+func (h *AccountHandler) ForgotPassword(c echo.Context) error {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := c.Bind(&input); err != nil || input.Email == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Email is required"})
+	}
+
+	if err := h.sendPasswordReset(c.Request().Context(), input.Email); err != nil {
+		h.Logger.WithError(err).Error("Failed to send password reset")
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "If the address is registered, a reset link has been sent"})
+}
+
+func (h *AccountHandler) sendPasswordReset(ctx context.Context, email string) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var (
+		userID   int
+		address  string
+		username string
+	)
+	query := `SELECT id, email, username FROM users
+	          WHERE LOWER(email) = LOWER($1) AND email_verified_at IS NOT NULL AND deleted_at IS NULL`
+	if err = tx.QueryRowContext(ctx, query, email).Scan(&userID, &address, &username); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := issueUserToken(ctx, tx, userID, tokenPasswordReset, "", h.Config.PasswordResetTTL)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	h.Logger.WithField("user_id", userID).Info("Password reset requested")
+	return h.Mailer.Send(ctx, mailer.Message{
+		To:      address,
+		Subject: h.Config.SiteTitle + ": reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nsomebody asked to reset the password of your account. "+
+			"Open this link within %s to choose a new one:\n\n%s\n\n"+
+			"If it was not you, ignore this email; your password stays the same.\n",
+			username, h.Config.PasswordResetTTL, tokenLink(h.Config, "/password/reset", token)),
+	})
+}
+
+// ResetPassword sets a new password with a token from a reset email.
+func (h *AccountHandler) ResetPassword(c echo.Context) error {
+	var input struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := c.Bind(&input); err != nil || input.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Token is required"})
+	}
+	if len(input.NewPassword) < minPasswordLength {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	userID, _, err := consumeUserToken(ctx, tx, tokenPasswordReset, input.Token)
+	if err == nil {
+		err = setPassword(ctx, tx, userID, input.NewPassword, 0)
+	}
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, errInvalidUserToken) || errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Reset link is invalid or has expired"})
+		}
+		h.Logger.WithError(err).Error("Failed to reset password")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reset password"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithField("user_id", userID).Info("Password reset")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SendVerification emails a new verification link for the current user's
+// pending address or, if there is none, the current one.
+func (h *AccountHandler) SendVerification(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	if currentUserID, _ := auth.UserID(c); currentUserID != id {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Users can only verify their own email"})
+	}
+
+	var (
+		email    string
+		verified bool
+	)
+	query := `SELECT COALESCE(pending_email, email), pending_email IS NULL AND email_verified_at IS NOT NULL
+	          FROM users WHERE id=$1 AND deleted_at IS NULL`
+	if err = h.DB.QueryRowContext(c.Request().Context(), query, id).Scan(&email, &verified); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to fetch user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send verification email"})
+	}
+	if verified {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Email is already verified"})
+	}
+
+	if err = sendEmailVerification(c.Request().Context(), h.DB, h.Config, h.Mailer, id, email); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to send verification email to user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send verification email"})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// VerifyEmail confirms an address with a token from a verification email.
+// Confirming the pending address makes it the user's email. Tokens for an
+// address the user has since changed are rejected.
+func (h *AccountHandler) VerifyEmail(c echo.Context) error {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := c.Bind(&input); err != nil || input.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Token is required"})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	userID, email, err := consumeUserToken(ctx, tx, tokenEmailVerification, input.Token)
+	if err == nil {
+		query := `UPDATE users SET email = CASE WHEN pending_email = $2 THEN pending_email ELSE email END,
+		                 pending_email = CASE WHEN pending_email = $2 THEN NULL ELSE pending_email END,
+		                 email_verified_at = NOW(), updated_at = NOW()
+		          WHERE id=$1 AND (email=$2 OR pending_email=$2) AND deleted_at IS NULL`
+		var result sql.Result
+		if result, err = tx.ExecContext(ctx, query, userID, email); err == nil {
+			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+				err = errInvalidUserToken
+			}
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, errInvalidUserToken) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Verification link is invalid or has expired"})
+		}
+		if isUniqueViolation(err) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Email is already in use"})
+		}
+		h.Logger.WithError(err).Error("Failed to verify email")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify email"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithField("user_id", userID).Info("Email verified")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// setPassword stores a new password and revokes pending reset tokens and
+// all sessions but keepSession, so that whoever knew the old password is
+// logged out.
+func setPassword(ctx context.Context, tx *sql.Tx, userID int, password string, keepSession int) error {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET password_hash=$1, updated_at=NOW() WHERE id=$2 AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, string(passwordHash), userID)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err = revokeSessions(ctx, tx, userID, keepSession); err != nil {
+		return err
+	}
+	return revokeUserTokens(ctx, tx, userID, tokenPasswordReset)
+}
+
+// sendEmailVerification emails the user a link confirming email, replacing
+// any earlier link.
+func sendEmailVerification(ctx context.Context, db *sql.DB, cfg *config.Config, mail mailer.Mailer, userID int, email string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	token, err := issueUserToken(ctx, tx, userID, tokenEmailVerification, email, cfg.EmailVerificationTTL)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return mail.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: cfg.SiteTitle + ": confirm your email address",
+		Body: fmt.Sprintf("Hello,\n\nplease confirm that %s is your email address by opening this link within %s:\n\n%s\n\n"+
+			"The address is only used for your account once it is confirmed.\n",
+			email, cfg.EmailVerificationTTL, tokenLink(cfg, "/email/verify", token)),
+	})
+}
+
+// tokenLink is the page on the site that submits the token to the API.
+func tokenLink(cfg *config.Config, path, token string) string {
+	return cfg.BaseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// denyUnverifiedPublish writes a 403 response and reports true when the
+// current user tries to publish without a verified email address. It reports
+// false when the request can go ahead, including when publishedAt is nil.
+func denyUnverifiedPublish(c echo.Context, db queryRower, logger *logrus.Logger, publishedAt *time.Time) bool {
+	if publishedAt == nil {
+		return false
+	}
+
+	userID, _ := auth.UserID(c)
+	var verified bool
+	query := `SELECT email_verified_at IS NOT NULL FROM users WHERE id=$1`
+	if err := db.QueryRowContext(c.Request().Context(), query, userID).Scan(&verified); err != nil {
+		logger.WithError(err).Errorf("Failed to check email verification of user ID %d", userID)
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check email verification"})
+		return true
+	}
+	if !verified {
+		c.JSON(http.StatusForbidden, map[string]string{"error": "Verify your email address before publishing"})
+		return true
+	}
+	return false
+}
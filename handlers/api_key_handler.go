@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyColumns = `id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at`
+	// apiKeyPrefixLength is how much of a key is stored in clear to identify
+	// it in listings.
+	apiKeyPrefixLength = len(auth.APIKeyPrefix) + 8
+	maxAPIKeyName      = 100
+)
+
+type APIKeyHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+}
+
+func NewAPIKeyHandler(db *sql.DB, logger *logrus.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+type apiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKey creates a key for the current user. Scopes are permission
+// names, which have to be permissions the user holds, or the owner scopes
+// users.write and articles.write for the user's own account and content. The
+// response holds the only copy of the key.
+func (h *APIKeyHandler) CreateAPIKey(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	if currentUserID, _ := auth.UserID(c); currentUserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Users can only create their own API keys"})
+	}
+
+	var req apiKeyRequest
+	if err = c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPIKeyName {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Name is required and must be at most 100 characters"})
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Expiry must be in the future"})
+	}
+
+	ctx := c.Request().Context()
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		if auth.IsOwnerScope(scope) {
+			scopes = append(scopes, scope)
+			continue
+		}
+
+		granted, err := hasPermission(ctx, h.DB, userID, scope)
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to check permissions")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create API key"})
+		}
+		if !granted {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Scope " + scope + " is not a permission you hold"})
+		}
+		scopes = append(scopes, scope)
+	}
+
+	secret, err := newSecretToken()
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to generate API key")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create API key"})
+	}
+	key := auth.APIKeyPrefix + secret
+
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+	          RETURNING ` + apiKeyColumns
+	apiKey, err := scanAPIKey(h.DB.QueryRowContext(ctx, query,
+		userID, req.Name, key[:apiKeyPrefixLength], hashToken(key), pq.Array(scopes), req.ExpiresAt))
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to create API key for user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create API key"})
+	}
+	apiKey.Key = key
+
+	h.Logger.WithFields(logrus.Fields{"api_key_id": apiKey.ID, "user_id": userID}).Info("API key created")
+	return c.JSON(http.StatusCreated, apiKey)
+}
+
+// GetAPIKeys lists the keys of a user, including revoked and expired ones,
+// to the user and holders of users.manage.
+func (h *APIKeyHandler) GetAPIKeys(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, userID); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API keys"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to see other users' API keys"})
+	}
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id=$1 ORDER BY created_at DESC, id DESC`
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch API keys of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API keys"})
+	}
+	defer rows.Close()
+
+	keys := make([]*models.APIKey, 0)
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to scan API key")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API keys"})
+		}
+		keys = append(keys, apiKey)
+	}
+	if err = rows.Err(); err != nil {
+		h.Logger.WithError(err).Error("Failed to iterate API keys")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API keys"})
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey stops a key from working. Users revoke their own keys, holders
+// of users.manage anybody's.
+func (h *APIKeyHandler) RevokeAPIKey(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	keyID, err := strconv.Atoi(c.Param("key_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid API key ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, userID); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke API key"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to revoke other users' API keys"})
+	}
+
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`
+	res, err := h.DB.ExecContext(c.Request().Context(), query, keyID, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to revoke API key ID %d", keyID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke API key"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+	}
+
+	currentUserID, _ := auth.UserID(c)
+	h.Logger.WithFields(logrus.Fields{"api_key_id": keyID, "user_id": userID, "revoked_by": currentUserID}).Info("API key revoked")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Authenticate resolves an API key for auth.Middleware and records when it
+// was last used. Keys of deleted users stop working.
+func (h *APIKeyHandler) Authenticate(ctx context.Context, key string) (int, []string, error) {
+	var (
+		userID int
+		scopes []string
+	)
+	query := `UPDATE api_keys k SET last_used_at = NOW()
+	          FROM users u
+	          WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW())
+	            AND u.id = k.user_id AND u.deleted_at IS NULL
+	          RETURNING k.user_id, k.scopes`
+	err := h.DB.QueryRowContext(ctx, query, hashToken(key)).Scan(&userID, pq.Array(&scopes))
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, auth.ErrInvalidToken
+	}
+	return userID, scopes, err
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	err := row.Scan(&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, pq.Array(&apiKey.Scopes),
+		&apiKey.CreatedAt, &apiKey.ExpiresAt, &apiKey.LastUsedAt, &apiKey.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
@@ -1,26 +1,63 @@
 package handlers
 
 import (
+	"GoArticles/analytics"
+	"GoArticles/audit"
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/i18n"
+	"GoArticles/publishing"
+	"GoArticles/recommend"
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"net/http"
 
 	"GoArticles/models"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+// articleColumns is the column list read by scanArticle, for queries that
+// alias the articles table as "a".
+const articleColumns = `a.id, a.author_id, a.title, a.content, a.created_at, a.updated_at, a.published_at, a.likes_count, a.views_count,
+	a.language, a.slug, COALESCE(a.translation_group, a.id)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// articleDest returns the scan destinations matching articleColumns.
+func articleDest(article *models.Article) []interface{} {
+	return []interface{}{&article.ID, &article.AuthorID, &article.Title, &article.Content, &article.CreatedAt,
+		&article.UpdatedAt, &article.PublishedAt, &article.LikesCount, &article.ViewsCount,
+		&article.Language, &article.Slug, &article.TranslationGroup}
+}
+
+// scanArticle reads articleColumns into article, followed by any extra
+// columns the query selects after them.
+func scanArticle(row rowScanner, article *models.Article, extra ...interface{}) error {
+	return row.Scan(append(articleDest(article), extra...)...)
+}
+
 type ArticleHandler struct {
-	DB     *sql.DB
-	Logger *logrus.Logger
+	DB      *sql.DB
+	Logger  *logrus.Logger
+	Views   *analytics.ViewTracker
+	Related *recommend.Cache
+	Config  *config.Config
 }
 
-func NewArticleHandler(db *sql.DB, logger *logrus.Logger) *ArticleHandler {
+func NewArticleHandler(db *sql.DB, logger *logrus.Logger, views *analytics.ViewTracker, related *recommend.Cache, cfg *config.Config) *ArticleHandler {
 	return &ArticleHandler{
-		DB:     db,
-		Logger: logger,
+		DB:      db,
+		Logger:  logger,
+		Views:   views,
+		Related: related,
+		Config:  cfg,
 	}
 }
 
@@ -30,28 +67,59 @@ func (h *ArticleHandler) GetArticleByID(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
 	}
 
-	article, err := h.getArticleByID(c.Request().Context(), id)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Article not found"})
+	// Readers asking for another language get the matching translation, so
+	// caches must key on the header even for requests without it.
+	c.Response().Header().Set("Vary", "Accept-Language")
+	if acceptLanguage := c.Request().Header.Get("Accept-Language"); acceptLanguage != "" {
+		if id, err = h.negotiateTranslation(c.Request().Context(), id, acceptLanguage); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch article"})
+		}
 	}
 
-	return c.JSON(http.StatusOK, article)
+	return h.serveArticle(c, id)
 }
 
 func (h *ArticleHandler) CreateArticle(c echo.Context) error {
-	var article models.Article
-	if err := c.Bind(&article); err != nil {
+	var input struct {
+		models.Article
+		// TranslationOf is the ID of the article this one translates.
+		TranslationOf int `json:"translation_of"`
+	}
+	if err := c.Bind(&input); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
 	}
 
-	id, err := h.createArticle(c.Request().Context(), article)
+	if !auth.ScopeAllows(c.Request().Context(), auth.ScopeArticlesWrite) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "API key lacks the articles.write scope"})
+	}
+
+	article := input.Article
+	article.AuthorID, _ = auth.UserID(c)
+	article.Language = i18n.Normalize(article.Language)
+	if article.Language == "" {
+		article.Language = h.Config.DefaultLanguage()
+	}
+	if !i18n.Contains(h.Config.Languages, article.Language) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported language"})
+	}
+
+	if denyUnverifiedPublish(c, h.DB, h.Logger, article.PublishedAt) {
+		return nil
+	}
+
+	id, err := h.createArticle(c, article, input.TranslationOf)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create article"})
+		return articleWriteError(c, err, "Failed to create article")
+	}
+	if isPublished(article.PublishedAt) {
+		h.Related.Flush()
 	}
 
 	return c.JSON(http.StatusCreated, map[string]int{"id": id})
 }
 
+// UpdateArticle changes the title, content and slug of an article. Its
+// publication is left as it is; see PublishArticle.
 func (h *ArticleHandler) UpdateArticle(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -63,9 +131,15 @@ func (h *ArticleHandler) UpdateArticle(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
 	}
 
-	if err = h.updateArticle(c.Request().Context(), id, article); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update article"})
+	if denyArticleEdit(c, h.DB, h.Logger, id) {
+		return nil
+	}
+
+	if err = h.updateArticle(c, id, article); err != nil {
+		return articleWriteError(c, err, "Failed to update article")
 	}
+	// A new title can make the article similar to any other.
+	h.Related.Flush()
 
 	return c.NoContent(http.StatusNoContent)
 }
@@ -76,10 +150,117 @@ func (h *ArticleHandler) DeleteArticle(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
 	}
 
-	if err = h.deleteArticle(c.Request().Context(), id); err != nil {
+	userID, _ := auth.UserID(c)
+	isAuthor, err := isArticleAuthor(c.Request().Context(), h.DB, id, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to check authorship of article ID %d", id)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete article"})
 	}
+	if !isAuthor {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the author can delete this article"})
+	}
+
+	if err = h.deleteArticle(c, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete article"})
+	}
+	h.Related.Invalidate(id)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PublishArticle makes the article visible to readers now. Besides those who
+// may edit the article, holders of articles.publish can, also through a role
+// scoped to one of the article's categories.
+func (h *ArticleHandler) PublishArticle(c echo.Context) error {
+	return h.setPublished(c, true)
+}
+
+// UnpublishArticle turns the article back into a draft. The same users as
+// for PublishArticle may do this.
+func (h *ArticleHandler) UnpublishArticle(c echo.Context) error {
+	return h.setPublished(c, false)
+}
+
+func (h *ArticleHandler) setPublished(c echo.Context, publish bool) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	ctx := c.Request().Context()
+	userID, _ := auth.UserID(c)
+	allowed, err := canEditArticle(ctx, h.DB, id, userID)
+	if err == nil && !allowed {
+		allowed, err = hasArticlePermission(ctx, h.DB, userID, id, "articles.publish")
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to check publishing rights on article ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check publishing rights"})
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "You are not allowed to publish this article"})
+	}
+	if publish {
+		now := time.Now()
+		if denyUnverifiedPublish(c, h.DB, h.Logger, &now) {
+			return nil
+		}
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	var publishedAt, previouslyPublished *time.Time
+	err = tx.QueryRowContext(ctx, `SELECT published_at FROM articles WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&publishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found"})
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to fetch article with ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update article"})
+	}
+
+	previouslyPublished = publishedAt
+	switch {
+	case publish && !isPublished(publishedAt):
+		err = tx.QueryRowContext(ctx, `UPDATE articles SET published_at = NOW() WHERE id=$1 RETURNING published_at`, id).Scan(&publishedAt)
+		if err == nil {
+			err = publishing.NotifyFollowers(ctx, tx, id)
+		}
+	case !publish && publishedAt != nil:
+		publishedAt = nil
+		_, err = tx.ExecContext(ctx, `UPDATE articles SET published_at = NULL WHERE id=$1`, id)
+	}
+	if err == nil {
+		audit.Before(c, map[string]*time.Time{"published_at": previouslyPublished})
+		audit.After(c, map[string]*time.Time{"published_at": publishedAt})
+		err = audit.Record(c, tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to change publication of article ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update article"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+	if publish {
+		h.Related.Flush()
+	} else {
+		h.Related.Invalidate(id)
+	}
 
+	h.Logger.WithFields(logrus.Fields{"article_id": id, "user_id": userID, "published": publish}).Info("Article publication changed")
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -94,13 +275,68 @@ func (h *ArticleHandler) AddArticleTag(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid tag ID"})
 	}
 
+	if denyArticleEdit(c, h.DB, h.Logger, articleID) {
+		return nil
+	}
+
 	if err = h.addArticleTag(c.Request().Context(), articleID, tagID); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add tag to article"})
 	}
+	h.Related.Flush()
 
 	return c.NoContent(http.StatusNoContent)
 }
 
+// GetTagArticles lists the published articles with a tag, newest first. It is
+// the page the tag's feed and sitemap entries link to.
+func (h *ArticleHandler) GetTagArticles(c echo.Context) error {
+	tagID, err := strconv.Atoi(c.Param("tag_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid tag ID"})
+	}
+
+	ctx := c.Request().Context()
+	var exists bool
+	if err = h.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tags WHERE id=$1)`, tagID).Scan(&exists); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch tag ID %d", tagID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch articles"})
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Tag not found"})
+	}
+
+	limit, offset := parsePagination(c)
+	query := `SELECT ` + articleColumns + `
+	          FROM article_tags tg
+	          JOIN articles a ON a.id = tg.article_id
+	          WHERE tg.tag_id = $1
+	            AND a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+	          ORDER BY a.published_at DESC, a.id DESC
+	          LIMIT $2 OFFSET $3`
+	rows, err := h.DB.QueryContext(ctx, query, tagID, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch articles of tag ID %d", tagID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch articles"})
+	}
+	defer rows.Close()
+
+	articles := []models.Article{}
+	for rows.Next() {
+		var article models.Article
+		if err = scanArticle(rows, &article); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan article of tag ID %d", tagID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch articles"})
+		}
+		articles = append(articles, article)
+	}
+	if err = rows.Err(); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to iterate articles of tag ID %d", tagID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch articles"})
+	}
+
+	return c.JSON(http.StatusOK, articles)
+}
+
 func (h *ArticleHandler) LikeArticle(c echo.Context) error {
 	articleID, err := strconv.Atoi(c.Param("article_id"))
 	if err != nil {
@@ -111,71 +347,281 @@ func (h *ArticleHandler) LikeArticle(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
 	}
+	if currentUserID, _ := auth.UserID(c); currentUserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Users can only like articles themselves"})
+	}
 
 	h.Logger.Infof("User ID %d likes article ID %d", userID, articleID)
 	if err = h.likeArticle(c.Request().Context(), articleID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found"})
+		}
+		if isForeignKeyViolation(err) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Article or user not found"})
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to like article"})
 	}
 
 	return c.NoContent(http.StatusNoContent)
 }
 
+func (h *ArticleHandler) UnlikeArticle(c echo.Context) error {
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	if currentUserID, _ := auth.UserID(c); currentUserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Users can only remove their own likes"})
+	}
+
+	if err = h.unlikeArticle(c.Request().Context(), articleID, userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unlike article"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *ArticleHandler) GetArticleLikes(c echo.Context) error {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	limit, offset := parsePagination(c)
+	likers, err := h.getArticleLikes(c.Request().Context(), articleID, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch article likes"})
+	}
+
+	return c.JSON(http.StatusOK, likers)
+}
+
+func (h *ArticleHandler) GetLikedArticles(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	limit, offset := parsePagination(c)
+	articles, err := h.getLikedArticles(c.Request().Context(), userID, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch liked articles"})
+	}
+
+	return c.JSON(http.StatusOK, articles)
+}
+
 func (h *ArticleHandler) getArticleByID(ctx context.Context, id int) (*models.Article, error) {
 	h.Logger.Infof("Fetching article with ID %d", id)
 
-	query := `SELECT id, author_id, title, content, created_at, updated_at FROM articles WHERE id=$1`
+	query := `SELECT ` + articleColumns + ` FROM articles a WHERE a.id=$1 AND a.deleted_at IS NULL`
 	row := h.DB.QueryRowContext(ctx, query, id)
 
 	var article models.Article
-	if err := row.Scan(&article.ID, &article.AuthorID, &article.Title, &article.Content, &article.CreatedAt, &article.UpdatedAt); err != nil {
+	if err := scanArticle(row, &article); err != nil {
 		h.Logger.WithError(err).Errorf("Failed to fetch article with ID %d", id)
 		return nil, err
 	}
 
+	series, err := getSeriesNavigation(ctx, h.DB, id)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch series navigation for article ID %d", id)
+		return nil, err
+	}
+	article.Series = series
+
+	if article.Contributors, err = getArticleContributors(ctx, h.DB, id, false); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch contributors for article ID %d", id)
+		return nil, err
+	}
+
 	h.Logger.Infof("Successfully fetched article with ID %d", id)
 	return &article, nil
 }
 
-func (h *ArticleHandler) createArticle(ctx context.Context, article models.Article) (int, error) {
+// createArticle stores a new article and its audit entry. With translationOf
+// set it joins that article's translation group.
+func (h *ArticleHandler) createArticle(c echo.Context, article models.Article, translationOf int) (int, error) {
 	h.Logger.Infof("Creating a new article with title %s", article.Title)
 
-	query := `INSERT INTO articles (author_id, title, content, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return 0, err
+	}
+
+	var translationGroup *int
+	if translationOf != 0 {
+		if translationGroup, err = h.lockTranslationGroup(ctx, tx, translationOf, article.Language); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to add translation of article ID %d", translationOf)
+			return 0, err
+		}
+	}
+
+	slug, err := availableSlug(ctx, tx, article.Language, article.Slug, article.Title, 0)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to choose slug for new article")
+		return 0, err
+	}
+
+	query := `INSERT INTO articles (author_id, title, content, created_at, updated_at, published_at, language, slug, translation_group)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
 	var id int
-	err := h.DB.QueryRowContext(ctx, query, article.AuthorID, article.Title, article.Content, article.CreatedAt, article.UpdatedAt).Scan(&id)
+	err = tx.QueryRowContext(ctx, query, article.AuthorID, article.Title, article.Content, article.CreatedAt, article.UpdatedAt, article.PublishedAt,
+		article.Language, slug, translationGroup).Scan(&id)
 	if err != nil {
+		tx.Rollback()
 		h.Logger.WithError(err).Error("Failed to create article")
 		return 0, err
 	}
 
+	query = `INSERT INTO article_contributors (article_id, user_id, role, status, responded_at) VALUES ($1, $2, 'author', 'accepted', NOW())`
+	if _, err = tx.ExecContext(ctx, query, id, article.AuthorID); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to record author of article ID %d", id)
+		return 0, err
+	}
+
+	if isPublished(article.PublishedAt) {
+		if err = publishing.NotifyFollowers(ctx, tx, id); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to notify followers about article ID %d", id)
+			return 0, err
+		}
+	}
+
+	audit.Target(c, id)
+	audit.After(c, storedArticle(ctx, tx, id))
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to audit creation of article ID %d", id)
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return 0, err
+	}
+
 	h.Logger.Infof("Successfully created article with ID %d", id)
 	return id, nil
 }
 
-func (h *ArticleHandler) updateArticle(ctx context.Context, id int, article models.Article) error {
+func (h *ArticleHandler) updateArticle(c echo.Context, id int, article models.Article) error {
 	h.Logger.Infof("Updating article with ID %d", id)
 
-	query := `UPDATE articles SET title=$1, content=$2, updated_at=$3 WHERE id=$4`
-	_, err := h.DB.ExecContext(ctx, query, article.Title, article.Content, article.UpdatedAt, id)
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return err
+	}
+
+	var language string
+	query := `SELECT language FROM articles WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`
+	if err = tx.QueryRowContext(ctx, query, id).Scan(&language); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to fetch article with ID %d", id)
+		return err
+	}
+	audit.Before(c, storedArticle(ctx, tx, id))
+
+	// The slug only changes when a new one is given; the language is fixed.
+	var slug string
+	if article.Slug != "" {
+		if slug, err = availableSlug(ctx, tx, language, article.Slug, article.Title, id); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to change slug of article ID %d", id)
+			return err
+		}
+	}
+
+	// Publication only changes through PublishArticle and UnpublishArticle.
+	query = `UPDATE articles SET title=$1, content=$2, updated_at=$3, slug=COALESCE(NULLIF($4, ''), slug) WHERE id=$5`
+	if _, err = tx.ExecContext(ctx, query, article.Title, article.Content, article.UpdatedAt, slug, id); err != nil {
+		tx.Rollback()
 		h.Logger.WithError(err).Errorf("Failed to update article with ID %d", id)
 		return err
 	}
 
+	audit.After(c, storedArticle(ctx, tx, id))
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to audit update of article ID %d", id)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return err
+	}
+
 	h.Logger.Infof("Successfully updated article with ID %d", id)
 	return nil
 }
 
-func (h *ArticleHandler) deleteArticle(ctx context.Context, id int) error {
-	h.Logger.Infof("Deleting article with ID %d", id)
+// storedArticle reads the article's own columns for audit snapshots, or
+// returns nil if it cannot be read.
+func storedArticle(ctx context.Context, db queryRower, id int) *models.Article {
+	var article models.Article
+	query := `SELECT ` + articleColumns + ` FROM articles a WHERE a.id=$1`
+	if err := scanArticle(db.QueryRowContext(ctx, query, id), &article); err != nil {
+		return nil
+	}
+	return &article
+}
 
-	query := `DELETE FROM articles WHERE id=$1`
-	_, err := h.DB.ExecContext(ctx, query, id)
+// isPublished reports whether an article with the given publication time is
+// visible to readers right now.
+func isPublished(publishedAt *time.Time) bool {
+	return publishedAt != nil && !publishedAt.After(time.Now())
+}
+
+// deleteArticle moves the article to the trash. Everything attached to it is
+// kept so that it can be restored; trash.PurgeArticle removes it for good.
+func (h *ArticleHandler) deleteArticle(c echo.Context, id int) error {
+	h.Logger.Infof("Moving article with ID %d to the trash", id)
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return err
+	}
+
+	before := storedArticle(ctx, tx, id)
+	query := `UPDATE articles SET deleted_at = NOW() WHERE id=$1 AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		tx.Rollback()
 		h.Logger.WithError(err).Errorf("Failed to delete article with ID %d", id)
 		return err
 	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		tx.Rollback()
+		return sql.ErrNoRows
+	}
+
+	audit.Before(c, before)
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to audit deletion of article ID %d", id)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return err
+	}
 
-	h.Logger.Infof("Successfully deleted article with ID %d", id)
+	h.Logger.Infof("Successfully moved article with ID %d to the trash", id)
 	return nil
 }
 
@@ -196,13 +642,145 @@ func (h *ArticleHandler) addArticleTag(ctx context.Context, articleID, tagID int
 func (h *ArticleHandler) likeArticle(ctx context.Context, articleID, userID int) error {
 	h.Logger.Infof("User ID %d likes article ID %d", userID, articleID)
 
-	query := `INSERT INTO article_likes (article_id, user_id) VALUES ($1, $2)`
-	_, err := h.DB.ExecContext(ctx, query, articleID, userID)
+	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return err
+	}
+
+	// Only published articles can be liked. The row stays locked until the
+	// counter is updated.
+	var published bool
+	query := `SELECT published_at IS NOT NULL AND published_at <= NOW() FROM articles WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`
+	if err = tx.QueryRowContext(ctx, query, articleID).Scan(&published); err == nil && !published {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		tx.Rollback()
+		if !errors.Is(err, sql.ErrNoRows) {
+			h.Logger.WithError(err).Errorf("Failed to fetch article ID %d", articleID)
+		}
+		return err
+	}
+
+	// A repeated like is a no-op, so the counter only moves when a row is
+	// actually inserted.
+	query = `INSERT INTO article_likes (article_id, user_id) VALUES ($1, $2)
+	          ON CONFLICT (article_id, user_id) DO NOTHING`
+	result, err := tx.ExecContext(ctx, query, articleID, userID)
+	if err != nil {
+		tx.Rollback()
 		h.Logger.WithError(err).Errorf("Failed to like article ID %d by user ID %d", articleID, userID)
 		return err
 	}
 
+	if inserted, _ := result.RowsAffected(); inserted > 0 {
+		query = `UPDATE articles SET likes_count = likes_count + 1 WHERE id=$1`
+		if _, err = tx.ExecContext(ctx, query, articleID); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to increment likes count for article ID %d", articleID)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return err
+	}
+
 	h.Logger.Infof("User ID %d successfully liked article ID %d", userID, articleID)
 	return nil
 }
+
+func (h *ArticleHandler) unlikeArticle(ctx context.Context, articleID, userID int) error {
+	h.Logger.Infof("User ID %d unlikes article ID %d", userID, articleID)
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return err
+	}
+
+	query := `DELETE FROM article_likes WHERE article_id=$1 AND user_id=$2`
+	result, err := tx.ExecContext(ctx, query, articleID, userID)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to unlike article ID %d by user ID %d", articleID, userID)
+		return err
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		query = `UPDATE articles SET likes_count = GREATEST(likes_count - 1, 0) WHERE id=$1`
+		if _, err = tx.ExecContext(ctx, query, articleID); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to decrement likes count for article ID %d", articleID)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return err
+	}
+
+	h.Logger.Infof("User ID %d successfully unliked article ID %d", userID, articleID)
+	return nil
+}
+
+func (h *ArticleHandler) getArticleLikes(ctx context.Context, articleID, limit, offset int) ([]models.ArticleLiker, error) {
+	h.Logger.Infof("Fetching likes for article ID %d", articleID)
+
+	query := `SELECT u.id, u.username, l.created_at
+	          FROM article_likes l
+	          JOIN users u ON u.id = l.user_id
+	          WHERE l.article_id = $1
+	          ORDER BY l.created_at DESC, u.id
+	          LIMIT $2 OFFSET $3`
+	rows, err := h.DB.QueryContext(ctx, query, articleID, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch likes for article ID %d", articleID)
+		return nil, err
+	}
+	defer rows.Close()
+
+	likers := []models.ArticleLiker{}
+	for rows.Next() {
+		var liker models.ArticleLiker
+		if err = rows.Scan(&liker.UserID, &liker.Username, &liker.LikedAt); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan like for article ID %d", articleID)
+			return nil, err
+		}
+		likers = append(likers, liker)
+	}
+
+	return likers, rows.Err()
+}
+
+func (h *ArticleHandler) getLikedArticles(ctx context.Context, userID, limit, offset int) ([]models.LikedArticle, error) {
+	h.Logger.Infof("Fetching articles liked by user ID %d", userID)
+
+	query := `SELECT ` + articleColumns + `, l.created_at
+	          FROM article_likes l
+	          JOIN articles a ON a.id = l.article_id
+	          WHERE l.user_id = $1 AND a.deleted_at IS NULL
+	          ORDER BY l.created_at DESC, a.id
+	          LIMIT $2 OFFSET $3`
+	rows, err := h.DB.QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch articles liked by user ID %d", userID)
+		return nil, err
+	}
+	defer rows.Close()
+
+	articles := []models.LikedArticle{}
+	for rows.Next() {
+		var article models.LikedArticle
+		if err = scanArticle(rows, &article.Article, &article.LikedAt); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan article liked by user ID %d", userID)
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+
+	return articles, rows.Err()
+}
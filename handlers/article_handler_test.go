@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"GoArticles/analytics"
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/database/dbtest"
+	"GoArticles/recommend"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testArticleID = 7
+	testAuthorID  = 3
+)
+
+// Fragments of the queries the article handlers run, for scripting answers.
+const (
+	editRightsQuery        = "role IN ('author', 'co-author', 'editor')"
+	articlePermissionQuery = "article_scopes"
+	emailVerifiedQuery     = "email_verified_at IS NOT NULL"
+	publishedAtQuery       = "SELECT published_at FROM articles WHERE id=$1"
+	publishQuery           = "UPDATE articles SET published_at = NOW()"
+	announceQuery          = "WITH announced AS"
+	articleLanguageQuery   = "SELECT language FROM articles"
+	updateQuery            = "UPDATE articles SET title"
+	articleQuery           = "a.id=$1 AND a.deleted_at IS NULL"
+	viewsQuery             = "SET views_count"
+)
+
+type articleServer struct {
+	echo   *echo.Echo
+	db     *dbtest.DB
+	tokens *auth.TokenManager
+	views  *analytics.ViewTracker
+}
+
+// newArticleServer serves the article routes the way InitRoutes does, with
+// every session considered active.
+func newArticleServer(t *testing.T) *articleServer {
+	t.Helper()
+	db, script := dbtest.New()
+	t.Cleanup(func() { db.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tokens := auth.NewTokenManager("secret", time.Hour)
+	views := analytics.NewViewTracker(db, logger, time.Minute, time.Hour)
+	h := NewArticleHandler(db, logger, views, recommend.NewCache(time.Hour), &config.Config{Languages: []string{"en"}})
+
+	e := echo.New()
+	activeSession := func(context.Context, int, int, string, string) error { return nil }
+	e.Use(tokens.Middleware(nil, activeSession))
+	e.GET("/articles/:id", h.GetArticleByID)
+	e.PUT("/articles/:id", h.UpdateArticle, auth.RequireUser)
+	e.PUT("/articles/:id/publish", h.PublishArticle, auth.RequireUser)
+
+	return &articleServer{echo: e, db: script, tokens: tokens, views: views}
+}
+
+// do sends the request as userID, or anonymously for 0.
+func (s *articleServer) do(method, target, body string, userID int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if userID != 0 {
+		token, _ := s.tokens.Issue(userID, 1)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *articleServer) answer(fragment string, values ...driver.Value) {
+	s.db.Returns(fragment, dbtest.Result{Columns: make([]string, len(values)), Rows: [][]driver.Value{values}})
+}
+
+// flushViews writes the buffered views to the database.
+func (s *articleServer) flushViews() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.views.Run(ctx)
+}
+
+func TestUpdateArticle(t *testing.T) {
+	body := fmt.Sprintf(`{"title":"New title","content":"Text","published_at":%q}`, time.Now().Format(time.RFC3339))
+	target := fmt.Sprintf("/articles/%d", testArticleID)
+
+	t.Run("not an editor", func(t *testing.T) {
+		s := newArticleServer(t)
+		s.answer(editRightsQuery, false)
+		s.answer(articlePermissionQuery, false)
+
+		if rec := s.do(http.MethodPut, target, body, 99); rec.Code != http.StatusForbidden {
+			t.Errorf("got status %d, want %d", rec.Code, http.StatusForbidden)
+		}
+		if ran := s.db.Ran(updateQuery); len(ran) != 0 {
+			t.Errorf("article updated by someone who may not edit it: %v", ran)
+		}
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		s := newArticleServer(t)
+		if rec := s.do(http.MethodPut, target, body, 0); rec.Code != http.StatusUnauthorized {
+			t.Errorf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		if len(s.db.Statements()) != 0 {
+			t.Errorf("anonymous update reached the database: %v", s.db.Statements())
+		}
+	})
+
+	t.Run("editor", func(t *testing.T) {
+		s := newArticleServer(t)
+		s.answer(editRightsQuery, true)
+		s.answer(articleLanguageQuery, "en")
+
+		if rec := s.do(http.MethodPut, target, body, testAuthorID); rec.Code != http.StatusNoContent {
+			t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+		}
+		updates := s.db.Ran(updateQuery)
+		if len(updates) != 1 {
+			t.Fatalf("ran %d updates, want 1", len(updates))
+		}
+		if strings.Contains(updates[0].Query, "published_at") {
+			t.Errorf("update changes the publication: %s", updates[0].Query)
+		}
+		if ran := s.db.Ran(announceQuery); len(ran) != 0 {
+			t.Errorf("followers notified by an update: %v", ran)
+		}
+		if ran := s.db.Ran(emailVerifiedQuery); len(ran) != 0 {
+			t.Errorf("update checked for publishing rights: %v", ran)
+		}
+	})
+}
+
+func TestPublishArticle(t *testing.T) {
+	target := fmt.Sprintf("/articles/%d/publish", testArticleID)
+	tests := []struct {
+		name       string
+		editor     bool
+		publisher  bool
+		verified   bool
+		wantStatus int
+	}{
+		{name: "editor", editor: true, verified: true, wantStatus: http.StatusNoContent},
+		{name: "articles.publish", publisher: true, verified: true, wantStatus: http.StatusNoContent},
+		{name: "neither", verified: true, wantStatus: http.StatusForbidden},
+		{name: "unverified email", editor: true, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newArticleServer(t)
+			s.answer(editRightsQuery, tt.editor)
+			s.answer(articlePermissionQuery, tt.publisher)
+			s.answer(emailVerifiedQuery, tt.verified)
+			s.answer(publishedAtQuery, nil)
+			s.answer(publishQuery, time.Now())
+
+			rec := s.do(http.MethodPut, target, "", testAuthorID)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			published := len(s.db.Ran(publishQuery)) == 1
+			announced := len(s.db.Ran(announceQuery)) == 1
+			committed := len(s.db.Ran("COMMIT")) == 1
+			if want := tt.wantStatus == http.StatusNoContent; published != want || announced != want || committed != want {
+				t.Errorf("published %v, followers notified %v, committed %v; want %v", published, announced, committed, want)
+			}
+		})
+	}
+}
+
+func TestGetArticleByIDDraft(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		publishedAt driver.Value
+		userID      int
+		editor      bool
+		wantStatus  int
+		wantView    bool
+	}{
+		{name: "published", publishedAt: now.Add(-time.Hour), wantStatus: http.StatusOK, wantView: true},
+		{name: "draft for anonymous", wantStatus: http.StatusNotFound},
+		{name: "scheduled for anonymous", publishedAt: now.Add(time.Hour), wantStatus: http.StatusNotFound},
+		{name: "draft for other user", userID: 99, wantStatus: http.StatusNotFound},
+		{name: "draft for editor", userID: testAuthorID, editor: true, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newArticleServer(t)
+			s.answer(articleQuery, int64(testArticleID), int64(testAuthorID), "Title", "Text", now, now, tt.publishedAt,
+				int64(0), int64(0), "en", "title", int64(testArticleID))
+			s.answer(editRightsQuery, tt.editor)
+			s.answer(articlePermissionQuery, false)
+
+			rec := s.do(http.MethodGet, fmt.Sprintf("/articles/%d", testArticleID), "", tt.userID)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if vary := rec.Header().Get("Vary"); vary != "Accept-Language" {
+				t.Errorf("Vary is %q, want Accept-Language", vary)
+			}
+
+			s.flushViews()
+			if viewed := len(s.db.Ran(viewsQuery)) > 0; viewed != tt.wantView {
+				t.Errorf("view counted: %v, want %v", viewed, tt.wantView)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"GoArticles/models"
+	"context"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// Weights of a single view, like and comment in ranking scores.
+const (
+	viewWeight    = 1.0
+	likeWeight    = 5.0
+	commentWeight = 10.0
+)
+
+// rankingWindow selects which days of activity count towards a ranking and
+// how fast their weight decays: activity halfLife days old counts half.
+type rankingWindow struct {
+	days     int
+	halfLife float64
+}
+
+var trendingWindow = rankingWindow{days: 3, halfLife: 1}
+
+var popularWindows = map[string]rankingWindow{
+	"day":   {days: 1, halfLife: 1},
+	"week":  {days: 7, halfLife: 3.5},
+	"month": {days: 30, halfLife: 15},
+	"year":  {days: 365, halfLife: 180},
+}
+
+func (h *ArticleHandler) GetTrendingArticles(c echo.Context) error {
+	limit, offset := parsePagination(c)
+
+	articles, err := h.getRankedArticles(c.Request().Context(), trendingWindow, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch trending articles"})
+	}
+
+	return c.JSON(http.StatusOK, articles)
+}
+
+func (h *ArticleHandler) GetPopularArticles(c echo.Context) error {
+	period := c.QueryParam("period")
+	if period == "" {
+		period = "week"
+	}
+
+	window, ok := popularWindows[period]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid period, expected day, week, month or year"})
+	}
+
+	limit, offset := parsePagination(c)
+	articles, err := h.getRankedArticles(c.Request().Context(), window, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch popular articles"})
+	}
+
+	return c.JSON(http.StatusOK, articles)
+}
+
+func (h *ArticleHandler) getRankedArticles(ctx context.Context, window rankingWindow, limit, offset int) ([]models.RankedArticle, error) {
+	h.Logger.Infof("Ranking articles over the last %d days", window.days)
+
+	query := `SELECT ` + articleColumns + `, r.score
+	          FROM (
+	              SELECT s.article_id,
+	                     SUM((s.views * $1 + s.likes * $2 + s.comments * $3) * POWER(0.5, (CURRENT_DATE - s.day) / $4::float)) AS score
+	              FROM article_daily_stats s
+	              WHERE s.day > CURRENT_DATE - $5::int
+	              GROUP BY s.article_id
+	          ) r
+	          JOIN articles a ON a.id = r.article_id
+	          WHERE a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+	          ORDER BY r.score DESC, a.id DESC
+	          LIMIT $6 OFFSET $7`
+	rows, err := h.DB.QueryContext(ctx, query, viewWeight, likeWeight, commentWeight, window.halfLife, window.days, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to rank articles")
+		return nil, err
+	}
+	defer rows.Close()
+
+	articles := []models.RankedArticle{}
+	for rows.Next() {
+		var article models.RankedArticle
+		if err = scanArticle(rows, &article.Article, &article.Score); err != nil {
+			h.Logger.WithError(err).Error("Failed to scan ranked article")
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+
+	return articles, rows.Err()
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"GoArticles/models"
+	"context"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+const relatedArticlesLimit = 5
+
+// Weights of a shared tag, a shared category and full title similarity when
+// scoring related articles.
+const (
+	sharedTagWeight      = 3.0
+	sharedCategoryWeight = 2.0
+	titleSimilarWeight   = 4.0
+)
+
+func (h *ArticleHandler) GetRelatedArticles(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	excludeAuthor, _ := strconv.ParseBool(c.QueryParam("exclude_author"))
+
+	if articles, ok := h.Related.Get(id, excludeAuthor); ok {
+		return c.JSON(http.StatusOK, articles)
+	}
+
+	articles, err := h.getRelatedArticles(c.Request().Context(), id, excludeAuthor)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch related articles"})
+	}
+	h.Related.Set(id, excludeAuthor, articles)
+
+	return c.JSON(http.StatusOK, articles)
+}
+
+func (h *ArticleHandler) getRelatedArticles(ctx context.Context, id int, excludeAuthor bool) ([]models.RankedArticle, error) {
+	h.Logger.Infof("Computing related articles for article ID %d", id)
+
+	query := `WITH src AS (
+	              SELECT id, author_id, title, language, COALESCE(translation_group, id) AS translation_group
+	              FROM articles WHERE id = $1
+	          ),
+	          tag_overlap AS (
+	              SELECT t2.article_id, COUNT(*) AS shared
+	              FROM article_tags t1
+	              JOIN article_tags t2 ON t2.tag_id = t1.tag_id AND t2.article_id <> t1.article_id
+	              WHERE t1.article_id = $1
+	              GROUP BY t2.article_id
+	          ),
+	          category_overlap AS (
+	              SELECT c2.article_id, COUNT(*) AS shared
+	              FROM article_categories c1
+	              JOIN article_categories c2 ON c2.category_id = c1.category_id AND c2.article_id <> c1.article_id
+	              WHERE c1.article_id = $1
+	              GROUP BY c2.article_id
+	          )
+	          SELECT ` + articleColumns + `,
+	                 COALESCE(t.shared, 0) * $2 + COALESCE(c.shared, 0) * $3 + similarity(a.title, src.title) * $4 AS score
+	          FROM articles a
+	          CROSS JOIN src
+	          LEFT JOIN tag_overlap t ON t.article_id = a.id
+	          LEFT JOIN category_overlap c ON c.article_id = a.id
+	          WHERE a.language = src.language AND COALESCE(a.translation_group, a.id) <> src.translation_group
+	            AND a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+	            AND (t.shared IS NOT NULL OR c.shared IS NOT NULL OR a.title % src.title)
+	            AND (NOT $5 OR a.author_id <> src.author_id)
+	          ORDER BY score DESC, a.published_at DESC
+	          LIMIT $6`
+	rows, err := h.DB.QueryContext(ctx, query, id, sharedTagWeight, sharedCategoryWeight, titleSimilarWeight, excludeAuthor, relatedArticlesLimit)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to compute related articles for article ID %d", id)
+		return nil, err
+	}
+	defer rows.Close()
+
+	articles := []models.RankedArticle{}
+	for rows.Next() {
+		var article models.RankedArticle
+		if err = scanArticle(rows, &article.Article, &article.Score); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan related article for article ID %d", id)
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+
+	return articles, rows.Err()
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/i18n"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const maxSlugLength = 80
+
+var (
+	errTranslationSourceNotFound = errors.New("article to translate not found")
+	errTranslationExists         = errors.New("article already has a translation in this language")
+	errSlugTaken                 = errors.New("slug is already used in this language")
+	errInvalidSlug               = errors.New("slug has no letters or digits")
+)
+
+// GetArticleTranslations lists the published versions of the article in
+// other languages.
+func (h *ArticleHandler) GetArticleTranslations(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	translations, err := h.getTranslations(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch translations"})
+	}
+
+	return c.JSON(http.StatusOK, translations)
+}
+
+// GetArticleBySlug looks an article up by its language and slug, e.g.
+// /en/articles/getting-started.
+func (h *ArticleHandler) GetArticleBySlug(c echo.Context) error {
+	lang := c.Param("lang")
+	if !i18n.Contains(h.Config.Languages, lang) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown language"})
+	}
+
+	var id int
+	query := `SELECT id FROM articles WHERE language=$1 AND slug=$2 AND deleted_at IS NULL`
+	err := h.DB.QueryRowContext(c.Request().Context(), query, lang, c.Param("slug")).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to look up article %s/%s", lang, c.Param("slug"))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch article"})
+	}
+
+	return h.serveArticle(c, id)
+}
+
+// serveArticle writes the article as the response and counts the view.
+// Drafts and scheduled articles are only shown to those who may edit them,
+// without counting a view; everyone else gets a 404.
+func (h *ArticleHandler) serveArticle(c echo.Context, id int) error {
+	ctx := c.Request().Context()
+	article, err := h.getArticleByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch article"})
+	}
+
+	published := isPublished(article.PublishedAt)
+	if !published {
+		userID, _ := auth.UserID(c)
+		allowed := false
+		if userID != 0 {
+			if allowed, err = canEditArticle(ctx, h.DB, id, userID); err != nil {
+				h.Logger.WithError(err).Errorf("Failed to check edit rights on article ID %d", id)
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch article"})
+			}
+		}
+		if !allowed {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found"})
+		}
+	}
+
+	c.Response().Header().Set("Content-Language", article.Language)
+	if published {
+		h.Views.Record(article.ID, c.RealIP(), c.Request().UserAgent())
+	}
+
+	return c.JSON(http.StatusOK, article)
+}
+
+func (h *ArticleHandler) getTranslations(ctx context.Context, id int) ([]models.Translation, error) {
+	h.Logger.Infof("Fetching translations of article ID %d", id)
+
+	query := `SELECT t.id, t.language, t.title, t.slug, t.published_at
+	          FROM articles src
+	          JOIN articles t ON COALESCE(t.translation_group, t.id) = COALESCE(src.translation_group, src.id)
+	          WHERE src.id = $1 AND t.id <> src.id
+	            AND t.published_at IS NOT NULL AND t.published_at <= NOW() AND t.deleted_at IS NULL
+	          ORDER BY t.language`
+	rows, err := h.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch translations of article ID %d", id)
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []models.Translation{}
+	for rows.Next() {
+		var translation models.Translation
+		if err = rows.Scan(&translation.ArticleID, &translation.Language, &translation.Title,
+			&translation.Slug, &translation.PublishedAt); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan translation of article ID %d", id)
+			return nil, err
+		}
+		translations = append(translations, translation)
+	}
+
+	return translations, rows.Err()
+}
+
+// negotiateTranslation returns the ID of the version of the article that best
+// matches the Accept-Language header: the article itself or one of its
+// published translations. The article itself wins unless the client prefers
+// another language.
+func (h *ArticleHandler) negotiateTranslation(ctx context.Context, id int, acceptLanguage string) (int, error) {
+	query := `SELECT t.id, t.language
+	          FROM articles src
+	          JOIN articles t ON COALESCE(t.translation_group, t.id) = COALESCE(src.translation_group, src.id)
+	          WHERE src.id = $1
+	            AND (t.id = src.id OR (t.published_at IS NOT NULL AND t.published_at <= NOW() AND t.deleted_at IS NULL))
+	          ORDER BY t.id <> src.id, t.language`
+	rows, err := h.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch translations of article ID %d", id)
+		return 0, err
+	}
+	defer rows.Close()
+
+	versions := map[string]int{}
+	var languages []string
+	for rows.Next() {
+		var (
+			versionID int
+			language  string
+		)
+		if err = rows.Scan(&versionID, &language); err != nil {
+			return 0, err
+		}
+		versions[language] = versionID
+		languages = append(languages, language)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if lang, ok := i18n.Negotiate(acceptLanguage, languages); ok {
+		return versions[lang], nil
+	}
+	return id, nil
+}
+
+// lockTranslationGroup returns the translation group a new translation of
+// the source article joins, after checking the group has no version in the
+// language yet.
+func (h *ArticleHandler) lockTranslationGroup(ctx context.Context, tx *sql.Tx, sourceID int, language string) (*int, error) {
+	var group int
+	query := `SELECT COALESCE(translation_group, id) FROM articles WHERE id=$1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, sourceID).Scan(&group); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errTranslationSourceNotFound
+		}
+		return nil, err
+	}
+
+	var exists bool
+	query = `SELECT EXISTS (SELECT 1 FROM articles WHERE COALESCE(translation_group, id)=$1 AND language=$2)`
+	if err := tx.QueryRowContext(ctx, query, group, language).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errTranslationExists
+	}
+
+	return &group, nil
+}
+
+// requestLanguage returns the supported language the client asked for with
+// ?lang= or, failing that, Accept-Language. It is empty when the client
+// stated no usable preference.
+func requestLanguage(c echo.Context, supported []string) string {
+	if lang := i18n.Normalize(c.QueryParam("lang")); i18n.Contains(supported, lang) {
+		return lang
+	}
+	lang, _ := i18n.Negotiate(c.Request().Header.Get("Accept-Language"), supported)
+	return lang
+}
+
+// slugify turns text into a lowercase, hyphen-separated URL segment. Letters
+// of any script are kept so that Russian titles get readable slugs.
+func slugify(text string) string {
+	var b strings.Builder
+	pendingHyphen := false
+	for _, r := range strings.ToLower(text) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			pendingHyphen = b.Len() > 0
+			continue
+		}
+		if pendingHyphen {
+			b.WriteByte('-')
+			pendingHyphen = false
+		}
+		b.WriteRune(r)
+	}
+
+	slug := []rune(b.String())
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+	}
+	return strings.TrimRight(string(slug), "-")
+}
+
+// availableSlug picks the slug for an article. A requested slug must be free
+// in the language; one derived from the title gets a numeric suffix until it
+// is.
+func availableSlug(ctx context.Context, db queryRower, language, requested, title string, articleID int) (string, error) {
+	taken := func(slug string) (bool, error) {
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM articles WHERE language=$1 AND slug=$2 AND id<>$3)`
+		err := db.QueryRowContext(ctx, query, language, slug, articleID).Scan(&exists)
+		return exists, err
+	}
+
+	if requested != "" {
+		slug := slugify(requested)
+		if slug == "" {
+			return "", errInvalidSlug
+		}
+		exists, err := taken(slug)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return "", errSlugTaken
+		}
+		return slug, nil
+	}
+
+	base := slugify(title)
+	if base == "" {
+		base = "article"
+	}
+	for n := 1; ; n++ {
+		slug := base
+		if n > 1 {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		exists, err := taken(slug)
+		if err != nil || !exists {
+			return slug, err
+		}
+	}
+}
+
+// articleWriteError maps errors of createArticle and updateArticle to
+// responses.
+func articleWriteError(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, errTranslationSourceNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Article to translate not found"})
+	case errors.Is(err, errTranslationExists):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The article already has a translation in this language"})
+	case errors.Is(err, errSlugTaken), isUniqueViolation(err):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Slug is already used in this language"})
+	case errors.Is(err, errInvalidSlug):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Slug must contain letters or digits"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"GoArticles/audit"
+	"GoArticles/auth"
+	"GoArticles/models"
+	"database/sql"
+	"encoding/csv"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AuditHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+	Audit  *audit.Log
+}
+
+func NewAuditHandler(db *sql.DB, logger *logrus.Logger, log *audit.Log) *AuditHandler {
+	return &AuditHandler{
+		DB:     db,
+		Logger: logger,
+		Audit:  log,
+	}
+}
+
+// GetAuditLog lists audit log entries, newest first. ?actor_id=, ?action=,
+// ?target_type= and ?target_id= match exactly; ?from= and ?to= take RFC 3339
+// times. Paginated with ?limit= and ?offset=. Requires audit.read.
+func (h *AuditHandler) GetAuditLog(c echo.Context) error {
+	filter, msg := parseAuditFilter(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+	if !h.requireAuditRead(c) {
+		return nil
+	}
+
+	limit, offset := parsePagination(c)
+	entries := make([]*models.AuditEntry, 0, limit)
+	err := h.Audit.Entries(c.Request().Context(), filter, limit, offset, func(entry *models.AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to fetch audit log")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch audit log"})
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+// ExportAuditLog streams every entry matching the same filters as
+// GetAuditLog as CSV. Requires audit.read.
+func (h *AuditHandler) ExportAuditLog(c echo.Context) error {
+	filter, msg := parseAuditFilter(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+	if !h.requireAuditRead(c) {
+		return nil
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=UTF-8")
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit-log.csv"`)
+	res.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(res)
+	w.Write([]string{"id", "created_at", "actor_id", "action", "target_type", "target_id",
+		"before", "after", "ip", "request_id", "prev_hash", "hash"})
+	count := 0
+	err := h.Audit.Entries(c.Request().Context(), filter, 0, 0, func(entry *models.AuditEntry) error {
+		actor := ""
+		if entry.ActorID != nil {
+			actor = strconv.Itoa(*entry.ActorID)
+		}
+		count++
+		return w.Write([]string{
+			strconv.FormatInt(entry.ID, 10),
+			entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+			actor,
+			entry.Action,
+			entry.TargetType,
+			entry.TargetID,
+			string(entry.Before),
+			string(entry.After),
+			entry.IP,
+			entry.RequestID,
+			entry.PrevHash,
+			entry.Hash,
+		})
+	})
+	// The status is already sent, so an error can only cut the file short.
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to export audit log")
+	}
+	w.Flush()
+
+	h.Logger.Infof("Exported %d audit log entries", count)
+	return w.Error()
+}
+
+// VerifyAuditLog checks the hash chain of the whole audit log and reports
+// the first broken entry, if any. Requires audit.read.
+func (h *AuditHandler) VerifyAuditLog(c echo.Context) error {
+	if !h.requireAuditRead(c) {
+		return nil
+	}
+
+	result, err := h.Audit.Verify(c.Request().Context())
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to verify audit log")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify audit log"})
+	}
+	if !result.Valid {
+		h.Logger.WithField("entry_id", result.BrokenAt).Error("Audit log hash chain is broken")
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// requireAuditRead reports whether the current user holds audit.read and
+// otherwise writes the error response.
+func (h *AuditHandler) requireAuditRead(c echo.Context) bool {
+	userID, _ := auth.UserID(c)
+	allowed, err := hasPermission(c.Request().Context(), h.DB, userID, "audit.read")
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check permissions"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to read the audit log"})
+		return false
+	}
+	return true
+}
+
+func parseAuditFilter(c echo.Context) (audit.Filter, string) {
+	filter := audit.Filter{
+		Action:     c.QueryParam("action"),
+		TargetType: c.QueryParam("target_type"),
+		TargetID:   c.QueryParam("target_id"),
+	}
+
+	if raw := c.QueryParam("actor_id"); raw != "" {
+		actorID, err := strconv.Atoi(raw)
+		if err != nil {
+			return filter, "Invalid actor ID"
+		}
+		filter.ActorID = &actorID
+	}
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		raw := c.QueryParam(param.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, "Invalid " + param.name + " time, expected RFC 3339"
+		}
+		*param.dest = &t
+	}
+
+	return filter, ""
+}
+
+// execAudited runs a change made by a single statement in a transaction
+// together with the request's audit entry, so set the entry's snapshots
+// first. A statement that changes no rows is rolled back and leaves the entry
+// to the middleware.
+func execAudited(c echo.Context, db *sql.DB, query string, args ...interface{}) (sql.Result, error) {
+	ctx := c.Request().Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return result, tx.Rollback()
+	}
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return result, tx.Commit()
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/mailer"
+	"GoArticles/oidc"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dummyPasswordHash stands in for the password hash of unknown logins.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+type AuthHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+	Tokens *auth.TokenManager
+	Config *config.Config
+	Mailer mailer.Mailer
+	// SSO is the OpenID Connect provider for single sign-on, nil when it is
+	// not configured.
+	SSO *oidc.Provider
+}
+
+func NewAuthHandler(db *sql.DB, logger *logrus.Logger, tokens *auth.TokenManager, cfg *config.Config, mail mailer.Mailer, sso *oidc.Provider) *AuthHandler {
+	return &AuthHandler{
+		DB:     db,
+		Logger: logger,
+		Tokens: tokens,
+		Config: cfg,
+		Mailer: mail,
+		SSO:    sso,
+	}
+}
+
+type loginRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type loginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// TwoFactorSetupRequired tells members of roles requiring 2FA that the
+	// role's permissions stay inactive until they enable it.
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+}
+
+// challengeResponse asks for the second factor of a login; the challenge is
+// sent back to LoginTwoFactor with the code.
+type challengeResponse struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	Challenge         string    `json:"challenge"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// Login accepts a username or email with the password and returns a bearer
+// token for the Authorization header. Failed attempts slow down further
+// attempts for the account and the client address and eventually lock them
+// out; see loginWait.
+func (h *AuthHandler) Login(c echo.Context) error {
+	var req loginRequest
+	if err := c.Bind(&req); err != nil || req.Login == "" || req.Password == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Login and password are required"})
+	}
+
+	ctx := c.Request().Context()
+	userID, passwordHash, err := h.lookupLogin(ctx, req.Login)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	attempt := h.throttle(c, userID)
+	if attempt == nil {
+		return nil
+	}
+	defer attempt.Rollback()
+
+	// Unknown logins are checked against a dummy hash so that they take as
+	// long as wrong passwords and do not reveal which accounts exist.
+	hash := []byte(passwordHash)
+	if userID == 0 {
+		hash = dummyPasswordHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || userID == 0 {
+		h.Logger.WithField("login", req.Login).Warn("Failed login attempt")
+		h.loginFailed(c, attempt, userID)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid login or password"})
+	}
+
+	// Nothing to record for a correct password; release the counters before
+	// the login clears them.
+	attempt.Rollback()
+	return h.completeLogin(c, userID)
+}
+
+// completeLogin answers a login whose first factor succeeded with either an
+// access token or, for users with 2FA, a challenge for LoginTwoFactor.
+func (h *AuthHandler) completeLogin(c echo.Context, userID int) error {
+	enabled, required, err := h.twoFactorState(c.Request().Context(), userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to check 2FA of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+	if enabled {
+		challenge, expires := h.Tokens.IssueChallenge(userID)
+		return c.JSON(http.StatusOK, challengeResponse{TwoFactorRequired: true, Challenge: challenge, ExpiresAt: expires})
+	}
+
+	h.loginSucceeded(c, userID)
+	token, expires, err := h.issueToken(c, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to start session of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	h.Logger.WithField("user_id", userID).Info("User logged in")
+	return c.JSON(http.StatusOK, loginResponse{Token: token, ExpiresAt: expires, TwoFactorSetupRequired: required})
+}
+
+// LoginTwoFactor completes a login with the challenge from Login and a TOTP
+// or recovery code.
+func (h *AuthHandler) LoginTwoFactor(c echo.Context) error {
+	var input struct {
+		Challenge string `json:"challenge"`
+		secondFactor
+	}
+	if err := c.Bind(&input); err != nil || input.Challenge == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Challenge and code are required"})
+	}
+
+	userID, err := h.Tokens.ParseChallenge(input.Challenge)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired challenge, log in again"})
+	}
+
+	attempt := h.throttle(c, userID)
+	if attempt == nil {
+		return nil
+	}
+	defer attempt.Rollback()
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	ok, err := verifySecondFactor(ctx, tx, userID, input.secondFactor)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to verify second factor of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+	if !ok {
+		tx.Rollback()
+		h.Logger.WithField("user_id", userID).Warn("Failed second factor")
+		h.loginFailed(c, attempt, userID)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid code"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	attempt.Rollback()
+	h.loginSucceeded(c, userID)
+	token, expires, err := h.issueToken(c, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to start session of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	h.Logger.WithField("user_id", userID).Info("User logged in with two factors")
+	return c.JSON(http.StatusOK, loginResponse{Token: token, ExpiresAt: expires})
+}
+
+// issueToken starts a session for the user and returns its access token.
+func (h *AuthHandler) issueToken(c echo.Context, userID int) (string, time.Time, error) {
+	sessionID, err := startSession(c.Request().Context(), h.DB, userID, c.RealIP(), c.Request().UserAgent(), h.Tokens.TTL())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token, expires := h.Tokens.Issue(userID, sessionID)
+	return token, expires, nil
+}
+
+// twoFactorState reports whether the user has 2FA enabled and, if not,
+// whether one of their roles, or a role they inherit, requires it.
+func (h *AuthHandler) twoFactorState(ctx context.Context, userID int) (enabled, required bool, err error) {
+	query := effectiveRolesCTE + `
+	          SELECT u.totp_enabled_at IS NOT NULL, EXISTS (SELECT 1 FROM user_role_tree WHERE require_2fa)
+	          FROM users u WHERE u.id=$1`
+	err = h.DB.QueryRowContext(ctx, query, userID).Scan(&enabled, &required)
+	return enabled, required && !enabled, err
+}
+
+// UnlockUser lifts a lockout of the account and forgets its failed logins.
+// Requires users.manage.
+func (h *AuthHandler) UnlockUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	ctx := c.Request().Context()
+	currentUserID, _ := auth.UserID(c)
+	allowed, err := hasPermission(ctx, h.DB, currentUserID, "users.manage")
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unlock user"})
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to unlock users"})
+	}
+
+	if _, err = h.DB.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, accountThrottleKey(id)); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to unlock user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unlock user"})
+	}
+
+	h.Logger.WithFields(logrus.Fields{"user_id": id, "unlocked_by": currentUserID}).Info("User unlocked")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// lookupLogin finds the account for a username or, when the login contains
+// "@", which usernames cannot, for an email address. Emails compare
+// case-insensitively, preferring an exact match should accounts from before
+// that rule differ only in case. Unknown logins yield a zero user ID rather
+// than an error.
+func (h *AuthHandler) lookupLogin(ctx context.Context, login string) (int, string, error) {
+	var (
+		userID       int
+		passwordHash string
+	)
+	query := `SELECT id, password_hash FROM users WHERE username = $1 AND deleted_at IS NULL`
+	if strings.Contains(login, "@") {
+		query = `SELECT id, password_hash FROM users WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL
+		         ORDER BY email = $1 DESC, id LIMIT 1`
+	}
+	err := h.DB.QueryRowContext(ctx, query, login).Scan(&userID, &passwordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", nil
+	}
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to look up user for login")
+		return 0, "", err
+	}
+	return userID, passwordHash, nil
+}
+
+// throttle starts a login attempt, locking the counters of the client
+// address and the account, if known, until the attempt is rolled back or
+// recorded as failed with loginFailed. While the client has to wait, or if
+// the counters cannot be checked, it writes the error response and returns
+// nil instead.
+func (h *AuthHandler) throttle(c echo.Context, userID int) *sql.Tx {
+	keys := []string{ipThrottleKey(c.RealIP())}
+	if userID != 0 {
+		keys = append(keys, accountThrottleKey(userID))
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+		return nil
+	}
+
+	var wait time.Duration
+	err = lockLoginAttempts(ctx, tx, keys...)
+	if err == nil {
+		wait, err = loginWait(ctx, tx, h.Config, keys...)
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to check login attempts")
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+		return nil
+	}
+	if wait <= 0 {
+		return tx
+	}
+
+	tx.Rollback()
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Too many failed login attempts, try again later"})
+	return nil
+}
+
+// loginFailed counts the failure for the client address and the account in
+// the attempt from throttle, ends it and tells the owner when their account
+// gets locked.
+func (h *AuthHandler) loginFailed(c echo.Context, attempt *sql.Tx, userID int) {
+	ctx := c.Request().Context()
+	ip := c.RealIP()
+
+	ipLocked, err := recordLoginFailure(ctx, attempt, h.Config, ipThrottleKey(ip), h.Config.LoginIPMaxFailures)
+	var accountLocked bool
+	if err == nil && userID != 0 {
+		accountLocked, err = recordLoginFailure(ctx, attempt, h.Config, accountThrottleKey(userID), h.Config.LoginMaxFailures)
+	}
+	if err == nil {
+		err = attempt.Commit()
+	}
+	if err != nil {
+		attempt.Rollback()
+		h.Logger.WithError(err).Error("Failed to record failed login")
+		return
+	}
+
+	if ipLocked {
+		h.Logger.WithField("ip", ip).Warn("Logins from address locked after repeated failures")
+	}
+	if accountLocked {
+		h.Logger.WithFields(logrus.Fields{"user_id": userID, "ip": ip}).Warn("Account locked after repeated failed logins")
+		if err = h.notifyLockout(ctx, userID, ip); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to notify user ID %d about lockout", userID)
+		}
+	}
+}
+
+func (h *AuthHandler) loginSucceeded(c echo.Context, userID int) {
+	if err := clearLoginFailures(c.Request().Context(), h.DB, h.Config, accountThrottleKey(userID)); err != nil {
+		h.Logger.WithError(err).Error("Failed to clear failed logins")
+	}
+}
+
+func (h *AuthHandler) notifyLockout(ctx context.Context, userID int, ip string) error {
+	message := fmt.Sprintf("Your account was locked for %s after %d failed login attempts from %s",
+		h.Config.LoginLockout, h.Config.LoginMaxFailures, ip)
+	if err := createNotification(ctx, h.DB, userID, message); err != nil {
+		return err
+	}
+
+	var email string
+	if err := h.DB.QueryRowContext(ctx, `SELECT email FROM users WHERE id=$1`, userID).Scan(&email); err != nil {
+		return err
+	}
+	return h.Mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: h.Config.SiteTitle + ": your account was locked",
+		Body: message + ".\n\nIf this was not you, somebody may be guessing your password; " +
+			"consider changing it and enabling two-factor authentication.\n",
+	})
+}
@@ -0,0 +1,605 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errCollectionNotFound = errors.New("collection not found")
+
+type BookmarkHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+}
+
+func NewBookmarkHandler(db *sql.DB, logger *logrus.Logger) *BookmarkHandler {
+	return &BookmarkHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+func (h *BookmarkHandler) GetBookmarks(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	limit, offset := parsePagination(c)
+	unreadOnly, _ := strconv.ParseBool(c.QueryParam("unread"))
+
+	query := `SELECT b.user_id, b.article_id, b.is_read, b.read_at, b.created_at, ` + articleColumns + `
+	          FROM bookmarks b
+	          JOIN articles a ON a.id = b.article_id
+	          WHERE b.user_id = $1 AND (NOT $2 OR NOT b.is_read) AND a.deleted_at IS NULL
+	          ORDER BY b.created_at DESC, b.article_id DESC
+	          LIMIT $3 OFFSET $4`
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, userID, unreadOnly, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch bookmarks for user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch bookmarks"})
+	}
+	defer rows.Close()
+
+	bookmarks := []models.Bookmark{}
+	for rows.Next() {
+		bookmark := models.Bookmark{Article: &models.Article{}}
+		dest := []interface{}{&bookmark.UserID, &bookmark.ArticleID, &bookmark.IsRead, &bookmark.ReadAt, &bookmark.CreatedAt}
+		if err = rows.Scan(append(dest, articleDest(bookmark.Article)...)...); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan bookmark for user ID %d", userID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch bookmarks"})
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+
+	return c.JSON(http.StatusOK, bookmarks)
+}
+
+func (h *BookmarkHandler) AddBookmark(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	h.Logger.Infof("User ID %d bookmarks article ID %d", userID, articleID)
+
+	query := `INSERT INTO bookmarks (user_id, article_id) VALUES ($1, $2)
+	          ON CONFLICT (user_id, article_id) DO NOTHING`
+	if _, err = h.DB.ExecContext(c.Request().Context(), query, userID, articleID); err != nil {
+		if isForeignKeyViolation(err) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to bookmark article ID %d for user ID %d", articleID, userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add bookmark"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type bookmarkUpdate struct {
+	IsRead *bool `json:"is_read"`
+}
+
+// UpdateBookmark marks a bookmarked article as read or unread.
+func (h *BookmarkHandler) UpdateBookmark(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	var update bookmarkUpdate
+	if err = c.Bind(&update); err != nil || update.IsRead == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "is_read is required"})
+	}
+
+	query := `UPDATE bookmarks
+	          SET is_read = $1, read_at = CASE WHEN $1 THEN COALESCE(read_at, NOW()) END
+	          WHERE user_id = $2 AND article_id = $3`
+	result, err := h.DB.ExecContext(c.Request().Context(), query, *update.IsRead, userID, articleID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to update bookmark of article ID %d for user ID %d", articleID, userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update bookmark"})
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Bookmark not found"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RemoveBookmark deletes the bookmark and takes the article out of all of the
+// user's collections.
+func (h *BookmarkHandler) RemoveBookmark(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove bookmark"})
+	}
+
+	query := `SELECT id FROM bookmark_collections WHERE user_id = $1 ORDER BY id FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to lock collections of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove bookmark"})
+	}
+	var collectionIDs []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove bookmark"})
+		}
+		collectionIDs = append(collectionIDs, id)
+	}
+	rows.Close()
+
+	for _, collectionID := range collectionIDs {
+		if err = removeCollectionItem(ctx, tx, collectionID, articleID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to remove article ID %d from collection ID %d", articleID, collectionID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove bookmark"})
+		}
+	}
+
+	query = `DELETE FROM bookmarks WHERE user_id = $1 AND article_id = $2`
+	if _, err = tx.ExecContext(ctx, query, userID, articleID); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to remove bookmark of article ID %d for user ID %d", articleID, userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove bookmark"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove bookmark"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *BookmarkHandler) GetMyCollections(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	return h.listCollections(c, userID, false)
+}
+
+func (h *BookmarkHandler) GetUserCollections(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	currentUserID, _ := auth.UserID(c)
+	return h.listCollections(c, userID, currentUserID != userID)
+}
+
+func (h *BookmarkHandler) listCollections(c echo.Context, userID int, publicOnly bool) error {
+	query := `SELECT id, user_id, name, description, is_public, created_at, updated_at
+	          FROM bookmark_collections
+	          WHERE user_id = $1 AND (NOT $2 OR is_public)
+	          ORDER BY name`
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, userID, publicOnly)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch collections of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch collections"})
+	}
+	defer rows.Close()
+
+	collections := []models.BookmarkCollection{}
+	for rows.Next() {
+		var collection models.BookmarkCollection
+		if err = rows.Scan(&collection.ID, &collection.UserID, &collection.Name, &collection.Description,
+			&collection.IsPublic, &collection.CreatedAt, &collection.UpdatedAt); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan collection of user ID %d", userID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch collections"})
+		}
+		collections = append(collections, collection)
+	}
+
+	return c.JSON(http.StatusOK, collections)
+}
+
+type collectionInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	IsPublic    *bool   `json:"is_public"`
+}
+
+func (h *BookmarkHandler) CreateCollection(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+
+	var input collectionInput
+	if err := c.Bind(&input); err != nil || input.Name == nil || strings.TrimSpace(*input.Name) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Collection name is required"})
+	}
+
+	collection := models.BookmarkCollection{UserID: userID, Name: strings.TrimSpace(*input.Name)}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+	if input.IsPublic != nil {
+		collection.IsPublic = *input.IsPublic
+	}
+
+	query := `INSERT INTO bookmark_collections (user_id, name, description, is_public)
+	          VALUES ($1, $2, $3, $4)
+	          ON CONFLICT (user_id, name) DO NOTHING
+	          RETURNING id, created_at, updated_at`
+	err := h.DB.QueryRowContext(c.Request().Context(), query, userID, collection.Name, collection.Description, collection.IsPublic).
+		Scan(&collection.ID, &collection.CreatedAt, &collection.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A collection with this name already exists"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to create collection for user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create collection"})
+	}
+
+	h.Logger.Infof("Created collection ID %d for user ID %d", collection.ID, userID)
+	return c.JSON(http.StatusCreated, collection)
+}
+
+func (h *BookmarkHandler) UpdateCollection(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid collection ID"})
+	}
+
+	var input collectionInput
+	if err = c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+	if input.Name != nil && strings.TrimSpace(*input.Name) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Collection name cannot be empty"})
+	}
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		input.Name = &name
+	}
+
+	query := `UPDATE bookmark_collections
+	          SET name = COALESCE($1, name), description = COALESCE($2, description),
+	              is_public = COALESCE($3, is_public), updated_at = NOW()
+	          WHERE id = $4 AND user_id = $5`
+	result, err := h.DB.ExecContext(c.Request().Context(), query, input.Name, input.Description, input.IsPublic, collectionID, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "A collection with this name already exists"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to update collection ID %d", collectionID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update collection"})
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Collection not found"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *BookmarkHandler) DeleteCollection(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid collection ID"})
+	}
+
+	// Items go with the collection via ON DELETE CASCADE; bookmarks stay.
+	query := `DELETE FROM bookmark_collections WHERE id = $1 AND user_id = $2`
+	result, err := h.DB.ExecContext(c.Request().Context(), query, collectionID, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to delete collection ID %d", collectionID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete collection"})
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Collection not found"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetCollection returns a collection with its articles in order. Private
+// collections are only visible to their owner.
+func (h *BookmarkHandler) GetCollection(c echo.Context) error {
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid collection ID"})
+	}
+
+	ctx := c.Request().Context()
+	collection, err := h.getCollection(ctx, collectionID)
+	if err != nil {
+		if errors.Is(err, errCollectionNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Collection not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch collection"})
+	}
+
+	if currentUserID, _ := auth.UserID(c); !collection.IsPublic && collection.UserID != currentUserID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Collection not found"})
+	}
+
+	query := `SELECT i.position, i.added_at, ` + articleColumns + `
+	          FROM bookmark_collection_items i
+	          JOIN articles a ON a.id = i.article_id
+	          WHERE i.collection_id = $1 AND a.deleted_at IS NULL
+	          ORDER BY i.position`
+	rows, err := h.DB.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch items of collection ID %d", collectionID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch collection"})
+	}
+	defer rows.Close()
+
+	collection.Items = []models.CollectionItem{}
+	for rows.Next() {
+		var item models.CollectionItem
+		dest := []interface{}{&item.Position, &item.AddedAt}
+		if err = rows.Scan(append(dest, articleDest(&item.Article)...)...); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan item of collection ID %d", collectionID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch collection"})
+		}
+		collection.Items = append(collection.Items, item)
+	}
+
+	return c.JSON(http.StatusOK, collection)
+}
+
+// AddToCollection appends an article to the end of a collection, bookmarking
+// it first if needed.
+func (h *BookmarkHandler) AddToCollection(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid collection ID"})
+	}
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add article to collection"})
+	}
+
+	if err = lockOwnCollection(ctx, tx, collectionID, userID); err != nil {
+		tx.Rollback()
+		if errors.Is(err, errCollectionNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Collection not found"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to lock collection ID %d", collectionID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add article to collection"})
+	}
+
+	queries := []string{
+		`INSERT INTO bookmarks (user_id, article_id) VALUES ($1, $3)
+		 ON CONFLICT (user_id, article_id) DO NOTHING`,
+		`INSERT INTO bookmark_collection_items (collection_id, article_id, position)
+		 SELECT $2, $3, COALESCE(MAX(position), 0) + 1 FROM bookmark_collection_items WHERE collection_id = $2
+		 ON CONFLICT (collection_id, article_id) DO NOTHING`,
+	}
+	for _, query := range queries {
+		if _, err = tx.ExecContext(ctx, query, userID, collectionID, articleID); err != nil {
+			tx.Rollback()
+			if isForeignKeyViolation(err) {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found"})
+			}
+			h.Logger.WithError(err).Errorf("Failed to add article ID %d to collection ID %d", articleID, collectionID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add article to collection"})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add article to collection"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *BookmarkHandler) RemoveFromCollection(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid collection ID"})
+	}
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove article from collection"})
+	}
+
+	if err = lockOwnCollection(ctx, tx, collectionID, userID); err == nil {
+		err = removeCollectionItem(ctx, tx, collectionID, articleID)
+	}
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, errCollectionNotFound) || errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found in collection"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to remove article ID %d from collection ID %d", articleID, collectionID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove article from collection"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove article from collection"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type collectionOrder struct {
+	ArticleIDs []int `json:"article_ids"`
+}
+
+// ReorderCollection sets the order of a collection from a complete list of
+// its article IDs.
+func (h *BookmarkHandler) ReorderCollection(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	collectionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid collection ID"})
+	}
+
+	var order collectionOrder
+	if err = c.Bind(&order); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reorder collection"})
+	}
+
+	if err = lockOwnCollection(ctx, tx, collectionID, userID); err != nil {
+		tx.Rollback()
+		if errors.Is(err, errCollectionNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Collection not found"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to lock collection ID %d", collectionID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reorder collection"})
+	}
+
+	current, err := collectionArticleIDs(ctx, tx, collectionID)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to fetch items of collection ID %d", collectionID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reorder collection"})
+	}
+	if !isPermutation(current, order.ArticleIDs) {
+		tx.Rollback()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "article_ids must list every article of the collection exactly once"})
+	}
+
+	for i, articleID := range order.ArticleIDs {
+		query := `UPDATE bookmark_collection_items SET position = $1 WHERE collection_id = $2 AND article_id = $3`
+		if _, err = tx.ExecContext(ctx, query, i+1, collectionID, articleID); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to move article ID %d in collection ID %d", articleID, collectionID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reorder collection"})
+		}
+	}
+
+	query := `UPDATE bookmark_collections SET updated_at = NOW() WHERE id = $1`
+	if _, err = tx.ExecContext(ctx, query, collectionID); err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reorder collection"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reorder collection"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *BookmarkHandler) getCollection(ctx context.Context, id int) (*models.BookmarkCollection, error) {
+	query := `SELECT id, user_id, name, description, is_public, created_at, updated_at
+	          FROM bookmark_collections WHERE id = $1`
+
+	var collection models.BookmarkCollection
+	err := h.DB.QueryRowContext(ctx, query, id).Scan(&collection.ID, &collection.UserID, &collection.Name,
+		&collection.Description, &collection.IsPublic, &collection.CreatedAt, &collection.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errCollectionNotFound
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch collection ID %d", id)
+		return nil, err
+	}
+
+	return &collection, nil
+}
+
+// lockOwnCollection locks the collection row for the rest of the transaction
+// so concurrent edits of its items are serialized.
+func lockOwnCollection(ctx context.Context, tx *sql.Tx, collectionID, userID int) error {
+	var id int
+	query := `SELECT id FROM bookmark_collections WHERE id = $1 AND user_id = $2 FOR UPDATE`
+	err := tx.QueryRowContext(ctx, query, collectionID, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errCollectionNotFound
+	}
+	return err
+}
+
+// removeCollectionItem deletes an item and closes the gap it leaves so
+// positions stay contiguous. It returns sql.ErrNoRows if the article was not
+// in the collection.
+func removeCollectionItem(ctx context.Context, tx *sql.Tx, collectionID, articleID int) error {
+	var position int
+	query := `DELETE FROM bookmark_collection_items WHERE collection_id = $1 AND article_id = $2 RETURNING position`
+	if err := tx.QueryRowContext(ctx, query, collectionID, articleID).Scan(&position); err != nil {
+		return err
+	}
+
+	query = `UPDATE bookmark_collection_items SET position = position - 1 WHERE collection_id = $1 AND position > $2`
+	_, err := tx.ExecContext(ctx, query, collectionID, position)
+	return err
+}
+
+func collectionArticleIDs(ctx context.Context, tx *sql.Tx, collectionID int) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT article_id FROM bookmark_collection_items WHERE collection_id = $1`, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// isPermutation reports whether requested lists exactly the IDs in current.
+func isPermutation(current, requested []int) bool {
+	if len(current) != len(requested) {
+		return false
+	}
+
+	remaining := make(map[int]bool, len(current))
+	for _, id := range current {
+		remaining[id] = true
+	}
+	for _, id := range requested {
+		if !remaining[id] {
+			return false
+		}
+		delete(remaining, id)
+	}
+	return true
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"GoArticles/audit"
+	"GoArticles/config"
 	"GoArticles/models"
-	"context"
+	"GoArticles/recommend"
 	"database/sql"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
@@ -11,14 +13,18 @@ import (
 )
 
 type CategoryHandler struct {
-	DB     *sql.DB
-	Logger *logrus.Logger
+	DB      *sql.DB
+	Logger  *logrus.Logger
+	Related *recommend.Cache
+	Config  *config.Config
 }
 
-func NewCategoryHandler(DB *sql.DB, Logger *logrus.Logger) *CategoryHandler {
+func NewCategoryHandler(DB *sql.DB, Logger *logrus.Logger, Related *recommend.Cache, Config *config.Config) *CategoryHandler {
 	return &CategoryHandler{
 		DB,
 		Logger,
+		Related,
+		Config,
 	}
 }
 
@@ -35,18 +41,24 @@ func (h *CategoryHandler) AddArticleCategory(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid category ID"})
 	}
 
-	if err = h.addArticleCategory(c.Request().Context(), articleID, categoryID); err != nil {
+	if denyArticleEdit(c, h.DB, h.Logger, articleID) {
+		return nil
+	}
+
+	audit.After(c, models.ArticleCategory{ArticleID: articleID, Category: categoryID})
+	if err = h.addArticleCategory(c, articleID, categoryID); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add category to article"})
 	}
-	
+	h.Related.Flush()
+
 	return c.NoContent(http.StatusNoContent)
 }
 
-func (h *CategoryHandler) addArticleCategory(ctx context.Context, articleID, categoryID int) error {
+func (h *CategoryHandler) addArticleCategory(c echo.Context, articleID, categoryID int) error {
 	h.Logger.Infof("Adding category ID %d to article ID %d", categoryID, articleID)
 
 	query := `INSERT INTO article_categories (article_id, category_id) VALUES ($1, $2)`
-	_, err := h.DB.ExecContext(ctx, query, articleID, categoryID)
+	_, err := execAudited(c, h.DB, query, articleID, categoryID)
 	if err != nil {
 		h.Logger.WithError(err).Errorf("Failed to add category ID %d to article ID %d", categoryID, articleID)
 		return err
@@ -57,11 +69,19 @@ func (h *CategoryHandler) addArticleCategory(ctx context.Context, articleID, cat
 }
 
 func (h *CategoryHandler) RemoveCategoryFromArticle(c echo.Context) error {
-	articleID := c.Param("article_id")
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
 	categoryID := c.Param("category_id")
 
+	if denyArticleEdit(c, h.DB, h.Logger, articleID) {
+		return nil
+	}
+
+	audit.Before(c, map[string]string{"category_id": categoryID})
 	query := `DELETE FROM article_categories WHERE article_id = $1 AND category_id = $2`
-	result, err := h.DB.Exec(query, articleID, categoryID)
+	result, err := execAudited(c, h.DB, query, articleID, categoryID)
 	if err != nil {
 		h.Logger.WithError(err).Error("Failed to remove category from article")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove category from article"})
@@ -72,6 +92,8 @@ func (h *CategoryHandler) RemoveCategoryFromArticle(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Category not found for the article"})
 	}
 
+	h.Related.Invalidate(articleID)
+
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -79,13 +101,15 @@ func (h *CategoryHandler) GetCategoriesForArticle(c echo.Context) error {
 	articleID := c.Param("article_id")
 	var categories []models.Category
 
-	query := `SELECT c.id, c.name
+	lang := requestLanguage(c, h.Config.Languages)
+	query := `SELECT c.id, COALESCE(ct.name, c.name), c.parent_id
               FROM categories c
               JOIN article_categories ac ON c.id = ac.category_id
+              LEFT JOIN category_translations ct ON ct.category_id = c.id AND ct.language = $2
               WHERE ac.article_id = $1`
 	h.Logger.Infof("Executing query to retrieve articles for category ID: %s", articleID)
 
-	rows, err := h.DB.Query(query, articleID)
+	rows, err := h.DB.Query(query, articleID, lang)
 	if err != nil {
 		h.Logger.WithError(err).Error("Failed to retrieve categories for article")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve categories for article"})
@@ -94,7 +118,7 @@ func (h *CategoryHandler) GetCategoriesForArticle(c echo.Context) error {
 
 	for rows.Next() {
 		var category models.Category
-		if err = rows.Scan(&category.ID, &category.Name); err != nil {
+		if err = rows.Scan(&category.ID, &category.Name, &category.ParentID); err != nil {
 			h.Logger.WithError(err).Error("Failed to scan category")
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to process categories"})
 		}
@@ -110,10 +134,10 @@ func (h *CategoryHandler) GetArticlesForCategory(c echo.Context) error {
 
 	var articles []models.Article
 
-	query := `SELECT a.id, a.title, a.author_id, a.content, a.created_at, a.updated_at
+	query := `SELECT ` + articleColumns + `
           FROM articles a
           JOIN article_categories ac ON a.id = ac.article_id
-          WHERE ac.category_id = $1`
+          WHERE ac.category_id = $1 AND a.deleted_at IS NULL`
 	h.Logger.Infof("Executing query to retrieve articles for category ID: %s", categoryID)
 
 	rows, err := h.DB.Query(query, categoryID)
@@ -130,7 +154,7 @@ func (h *CategoryHandler) GetArticlesForCategory(c echo.Context) error {
 	for rows.Next() {
 		var article models.Article
 		h.Logger.Infof("Processing article for category ID: %s", categoryID)
-		if err = rows.Scan(&article.ID, &article.Title, &article.AuthorID, &article.Content, &article.CreatedAt, &article.UpdatedAt); err != nil {
+		if err = scanArticle(rows, &article); err != nil {
 			h.Logger.WithError(err).Error("Failed to scan article")
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to process articles"})
 		}
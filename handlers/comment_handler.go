@@ -1 +1,72 @@
 package handlers
+
+import (
+	"GoArticles/auth"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+type CommentHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+}
+
+func NewCommentHandler(db *sql.DB, logger *logrus.Logger) *CommentHandler {
+	return &CommentHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+// DeleteComment moves a comment to the trash. Its author can delete it, as
+// can holders of trash.manage.
+func (h *CommentHandler) DeleteComment(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid comment ID"})
+	}
+
+	ctx := c.Request().Context()
+	authorID, err := h.commentAuthor(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Comment not found"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch comment ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete comment"})
+	}
+
+	if authorID != userID {
+		allowed, err := hasPermission(ctx, h.DB, userID, "trash.manage")
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to check permissions")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete comment"})
+		}
+		if !allowed {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the author can delete this comment"})
+		}
+	}
+
+	h.Logger.Infof("Moving comment ID %d to the trash", id)
+
+	query := `UPDATE comments SET deleted_at = NOW() WHERE id=$1 AND deleted_at IS NULL`
+	if _, err = h.DB.ExecContext(ctx, query, id); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to delete comment ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete comment"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *CommentHandler) commentAuthor(ctx context.Context, id int) (int, error) {
+	var authorID int
+	query := `SELECT user_id FROM comments WHERE id=$1 AND deleted_at IS NULL`
+	err := h.DB.QueryRowContext(ctx, query, id).Scan(&authorID)
+	return authorID, err
+}
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// invitableRoles are the roles an author can offer to other users; the
+// author role itself belongs to articles.author_id only.
+var invitableRoles = map[string]bool{
+	models.ContributorRoleCoAuthor:   true,
+	models.ContributorRoleEditor:     true,
+	models.ContributorRoleTranslator: true,
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// canEditArticle reports whether the user may change the article: its author
+// and every accepted co-author or editor can, as can holders of
+// articles.edit for the article's categories. API keys need the
+// articles.write scope to edit as author or contributor.
+func canEditArticle(ctx context.Context, db queryRower, articleID, userID int) (bool, error) {
+	if !auth.ScopeAllows(ctx, auth.ScopeArticlesWrite) {
+		return hasArticlePermission(ctx, db, userID, articleID, "articles.edit")
+	}
+
+	var allowed bool
+	query := `SELECT EXISTS (
+	              SELECT 1 FROM articles WHERE id = $1 AND author_id = $2 AND deleted_at IS NULL
+	              UNION ALL
+	              SELECT 1 FROM article_contributors
+	              WHERE article_id = $1 AND user_id = $2 AND status = 'accepted' AND role IN ('author', 'co-author', 'editor')
+	                AND article_id IN (SELECT id FROM articles WHERE deleted_at IS NULL)
+	          )`
+	err := db.QueryRowContext(ctx, query, articleID, userID).Scan(&allowed)
+	if err != nil || allowed {
+		return allowed, err
+	}
+	return hasArticlePermission(ctx, db, userID, articleID, "articles.edit")
+}
+
+// denyArticleEdit writes an error response and reports true when the current
+// user may not edit the article; the handler then has nothing left to do.
+func denyArticleEdit(c echo.Context, db *sql.DB, logger *logrus.Logger, articleID int) bool {
+	userID, _ := auth.UserID(c)
+	allowed, err := canEditArticle(c.Request().Context(), db, articleID, userID)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to check edit rights on article ID %d", articleID)
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check edit rights"})
+		return true
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, map[string]string{"error": "You are not allowed to edit this article"})
+		return true
+	}
+	return false
+}
+
+// isArticleAuthor reports whether the user is the article's primary author
+// and, for API keys, whether the key has the articles.write scope.
+func isArticleAuthor(ctx context.Context, db queryRower, articleID, userID int) (bool, error) {
+	if !auth.ScopeAllows(ctx, auth.ScopeArticlesWrite) {
+		return false, nil
+	}
+
+	var allowed bool
+	query := `SELECT EXISTS (SELECT 1 FROM articles WHERE id = $1 AND author_id = $2)`
+	err := db.QueryRowContext(ctx, query, articleID, userID).Scan(&allowed)
+	return allowed, err
+}
+
+// getArticleContributors lists accepted contributors, the author first.
+func getArticleContributors(ctx context.Context, db *sql.DB, articleID int, includePending bool) ([]models.ArticleContributor, error) {
+	query := `SELECT ac.article_id, ac.user_id, u.username, ac.role, ac.status, ac.created_at
+	          FROM article_contributors ac
+	          JOIN users u ON u.id = ac.user_id
+	          WHERE ac.article_id = $1 AND (ac.status = 'accepted' OR ($2 AND ac.status = 'pending'))
+	          ORDER BY ac.role = 'author' DESC, ac.created_at, ac.user_id`
+	rows, err := db.QueryContext(ctx, query, articleID, includePending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contributors := []models.ArticleContributor{}
+	for rows.Next() {
+		var contributor models.ArticleContributor
+		if err = rows.Scan(&contributor.ArticleID, &contributor.UserID, &contributor.Username,
+			&contributor.Role, &contributor.Status, &contributor.CreatedAt); err != nil {
+			return nil, err
+		}
+		contributors = append(contributors, contributor)
+	}
+	return contributors, rows.Err()
+}
+
+type ContributorHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+}
+
+func NewContributorHandler(db *sql.DB, logger *logrus.Logger) *ContributorHandler {
+	return &ContributorHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+// GetContributors lists the article's contributors; the author also sees
+// pending invitations.
+func (h *ContributorHandler) GetContributors(c echo.Context) error {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	ctx := c.Request().Context()
+	includePending := false
+	if userID, ok := auth.UserID(c); ok {
+		if includePending, err = isArticleAuthor(ctx, h.DB, articleID, userID); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to check authorship of article ID %d", articleID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch contributors"})
+		}
+	}
+
+	contributors, err := getArticleContributors(ctx, h.DB, articleID, includePending)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch contributors of article ID %d", articleID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch contributors"})
+	}
+
+	return c.JSON(http.StatusOK, contributors)
+}
+
+type contributorInvitation struct {
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// InviteContributor lets the author invite another user with a role. The
+// invitee is notified and has to accept before gaining any rights.
+func (h *ContributorHandler) InviteContributor(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	var invitation contributorInvitation
+	if err = c.Bind(&invitation); err != nil || invitation.UserID == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "user_id is required"})
+	}
+	if !invitableRoles[invitation.Role] {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Role must be co-author, editor or translator"})
+	}
+	if invitation.UserID == userID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Authors cannot invite themselves"})
+	}
+
+	ctx := c.Request().Context()
+	if isAuthor, err := isArticleAuthor(ctx, h.DB, articleID, userID); err != nil || !isAuthor {
+		if err != nil {
+			h.Logger.WithError(err).Errorf("Failed to check authorship of article ID %d", articleID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to invite contributor"})
+		}
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the author can invite contributors"})
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to invite contributor"})
+	}
+
+	// Re-inviting someone who declined or changing a pending role is allowed;
+	// accepted contributors keep their status.
+	query := `INSERT INTO article_contributors (article_id, user_id, role, status, invited_by)
+	          VALUES ($1, $2, $3, 'pending', $4)
+	          ON CONFLICT (article_id, user_id) DO UPDATE
+	          SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by,
+	              status = CASE WHEN article_contributors.status = 'accepted' THEN 'accepted' ELSE 'pending' END
+	          WHERE article_contributors.role <> 'author'`
+	result, err := tx.ExecContext(ctx, query, articleID, invitation.UserID, invitation.Role, userID)
+	if err != nil {
+		tx.Rollback()
+		if isForeignKeyViolation(err) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to invite user ID %d to article ID %d", invitation.UserID, articleID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to invite contributor"})
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, map[string]string{"error": "User is already the author"})
+	}
+
+	message, err := h.describeInvitation(ctx, tx, articleID, userID, invitation.Role)
+	if err == nil {
+		err = createNotification(ctx, tx, invitation.UserID, message)
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to notify user ID %d about invitation", invitation.UserID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to invite contributor"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to invite contributor"})
+	}
+
+	h.Logger.Infof("User ID %d invited user ID %d to article ID %d as %s", userID, invitation.UserID, articleID, invitation.Role)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *ContributorHandler) AcceptInvitation(c echo.Context) error {
+	return h.respondToInvitation(c, models.ContributorStatusAccepted)
+}
+
+func (h *ContributorHandler) DeclineInvitation(c echo.Context) error {
+	return h.respondToInvitation(c, models.ContributorStatusDeclined)
+}
+
+func (h *ContributorHandler) respondToInvitation(c echo.Context, status string) error {
+	userID, _ := auth.UserID(c)
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to respond to invitation"})
+	}
+
+	var (
+		role      string
+		invitedBy sql.NullInt64
+	)
+	query := `UPDATE article_contributors SET status = $1, responded_at = NOW()
+	          WHERE article_id = $2 AND user_id = $3 AND status = 'pending'
+	          RETURNING role, invited_by`
+	err = tx.QueryRowContext(ctx, query, status, articleID, userID).Scan(&role, &invitedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No pending invitation for this article"})
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to respond to invitation for article ID %d", articleID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to respond to invitation"})
+	}
+
+	if invitedBy.Valid {
+		var username, title string
+		query = `SELECT u.username, a.title FROM users u, articles a WHERE u.id = $1 AND a.id = $2`
+		err = tx.QueryRowContext(ctx, query, userID, articleID).Scan(&username, &title)
+		if err == nil {
+			message := fmt.Sprintf("%s %s your invitation to join \"%s\" as %s", username, status, title, role)
+			err = createNotification(ctx, tx, int(invitedBy.Int64), message)
+		}
+		if err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Error("Failed to notify inviter")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to respond to invitation"})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to respond to invitation"})
+	}
+
+	h.Logger.Infof("User ID %d %s invitation to article ID %d", userID, status, articleID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RemoveContributor lets the author remove a contributor, or a contributor
+// leave an article. The author entry itself cannot be removed.
+func (h *ContributorHandler) RemoveContributor(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+	contributorID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	ctx := c.Request().Context()
+	if contributorID != userID {
+		isAuthor, err := isArticleAuthor(ctx, h.DB, articleID, userID)
+		if err != nil {
+			h.Logger.WithError(err).Errorf("Failed to check authorship of article ID %d", articleID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove contributor"})
+		}
+		if !isAuthor {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the author can remove contributors"})
+		}
+	}
+
+	query := `DELETE FROM article_contributors WHERE article_id = $1 AND user_id = $2 AND role <> 'author'`
+	result, err := h.DB.ExecContext(ctx, query, articleID, contributorID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to remove user ID %d from article ID %d", contributorID, articleID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove contributor"})
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Contributor not found"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetUserArticles is the article list of an author page: published articles
+// the user wrote or contributed to, with their role.
+func (h *ContributorHandler) GetUserArticles(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	limit, offset := parsePagination(c)
+	query := `SELECT ` + articleColumns + `, ac.role
+	          FROM article_contributors ac
+	          JOIN articles a ON a.id = ac.article_id
+	          WHERE ac.user_id = $1 AND ac.status = 'accepted'
+	            AND a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+	          ORDER BY a.published_at DESC, a.id DESC
+	          LIMIT $2 OFFSET $3`
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, userID, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch articles of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch articles"})
+	}
+	defer rows.Close()
+
+	contributions := []models.Contribution{}
+	for rows.Next() {
+		var contribution models.Contribution
+		if err = scanArticle(rows, &contribution.Article, &contribution.Role); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan article of user ID %d", userID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch articles"})
+		}
+		contributions = append(contributions, contribution)
+	}
+
+	return c.JSON(http.StatusOK, contributions)
+}
+
+func (h *ContributorHandler) describeInvitation(ctx context.Context, tx *sql.Tx, articleID, inviterID int, role string) (string, error) {
+	var username, title string
+	query := `SELECT u.username, a.title FROM users u, articles a WHERE u.id = $1 AND a.id = $2`
+	if err := tx.QueryRowContext(ctx, query, inviterID, articleID).Scan(&username, &title); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s invited you to contribute to \"%s\" as %s", username, title, role), nil
+}
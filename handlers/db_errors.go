@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/lib/pq"
+)
+
+const (
+	pqForeignKeyViolation = "23503"
+	pqUniqueViolation     = "23505"
+)
+
+func isPQError(err error, code pq.ErrorCode) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code
+}
+
+func isForeignKeyViolation(err error) bool {
+	return isPQError(err, pqForeignKeyViolation)
+}
+
+func isUniqueViolation(err error) bool {
+	return isPQError(err, pqUniqueViolation)
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/export"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const exportColumns = `id, user_id, requested_by, status, size_bytes, error, created_at, completed_at,
+	expires_at, download_count, last_downloaded_at`
+
+type ExportHandler struct {
+	DB       *sql.DB
+	Logger   *logrus.Logger
+	Config   *config.Config
+	Exporter *export.Exporter
+}
+
+func NewExportHandler(db *sql.DB, logger *logrus.Logger, cfg *config.Config, exporter *export.Exporter) *ExportHandler {
+	return &ExportHandler{
+		DB:       db,
+		Logger:   logger,
+		Config:   cfg,
+		Exporter: exporter,
+	}
+}
+
+// RequestExport starts building an archive of the user's personal data. Users
+// can export their own data; exporting someone else's takes users.manage.
+// The response holds the only copy of the download link.
+func (h *ExportHandler) RequestExport(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	ctx := c.Request().Context()
+	currentUserID, _ := auth.UserID(c)
+	if currentUserID != userID {
+		allowed, err := hasPermission(ctx, h.DB, currentUserID, "users.manage")
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to check permissions")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to request export"})
+		}
+		if !allowed {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to export other users' data"})
+		}
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)`
+	if err = h.DB.QueryRowContext(ctx, query, userID).Scan(&exists); err != nil {
+		h.Logger.WithError(err).Error("Failed to look up user")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to request export"})
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to generate export token")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to request export"})
+	}
+
+	query = `INSERT INTO user_exports (user_id, requested_by, token_hash) VALUES ($1, $2, $3)
+	         RETURNING ` + exportColumns
+	exp, err := scanExport(h.DB.QueryRowContext(ctx, query, userID, currentUserID, hashToken(token)))
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to create export for user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to request export"})
+	}
+	exp.DownloadURL = fmt.Sprintf("%s/exports/download/%s", h.Config.BaseURL, token)
+
+	h.Exporter.Enqueue(exp.ID)
+
+	h.Logger.WithFields(logrus.Fields{
+		"export_id":    exp.ID,
+		"user_id":      userID,
+		"requested_by": currentUserID,
+	}).Info("Personal data export requested")
+	return c.JSON(http.StatusAccepted, exp)
+}
+
+// GetExport reports the progress of an export to its requester, the user
+// whose data it holds and holders of users.manage.
+func (h *ExportHandler) GetExport(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid export ID"})
+	}
+
+	ctx := c.Request().Context()
+	exp, err := scanExport(h.DB.QueryRowContext(ctx, `SELECT `+exportColumns+` FROM user_exports WHERE id=$1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Export not found"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch export ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch export"})
+	}
+
+	currentUserID, _ := auth.UserID(c)
+	if currentUserID != exp.UserID && currentUserID != exp.RequestedBy {
+		allowed, err := hasPermission(ctx, h.DB, currentUserID, "users.manage")
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to check permissions")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch export"})
+		}
+		if !allowed {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Export not found"})
+		}
+	}
+
+	return c.JSON(http.StatusOK, exp)
+}
+
+// GetExports is the audit trail of exports, newest first. Holders of
+// users.manage see every export and can narrow them with ?user_id=; everybody
+// else sees the exports of their own data and those they requested.
+func (h *ExportHandler) GetExports(c echo.Context) error {
+	ctx := c.Request().Context()
+	currentUserID, _ := auth.UserID(c)
+
+	all, err := hasPermission(ctx, h.DB, currentUserID, "users.manage")
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch exports"})
+	}
+
+	filterUserID := 0
+	if param := c.QueryParam("user_id"); param != "" {
+		if filterUserID, err = strconv.Atoi(param); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+		}
+	}
+
+	limit, offset := parsePagination(c)
+	exports, err := h.getExports(ctx, currentUserID, all, filterUserID, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch exports"})
+	}
+
+	return c.JSON(http.StatusOK, exports)
+}
+
+// DownloadExport serves a ready archive to whoever holds its token. Every
+// download is counted.
+func (h *ExportHandler) DownloadExport(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var (
+		id        int
+		userID    int
+		status    string
+		path      sql.NullString
+		expiresAt sql.NullTime
+	)
+	query := `SELECT id, user_id, status, file_path, expires_at FROM user_exports WHERE token_hash=$1`
+	err := h.DB.QueryRowContext(ctx, query, hashToken(c.Param("token"))).Scan(&id, &userID, &status, &path, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Export not found"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to look up export")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to download export"})
+	}
+
+	switch {
+	case status == models.ExportStatusPending:
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Export is not ready yet"})
+	case status == models.ExportStatusFailed:
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Export failed, please request a new one"})
+	case status == models.ExportStatusExpired || !path.Valid || (expiresAt.Valid && time.Now().After(expiresAt.Time)):
+		return c.JSON(http.StatusGone, map[string]string{"error": "Export has expired"})
+	}
+
+	query = `UPDATE user_exports SET download_count = download_count + 1, last_downloaded_at = NOW() WHERE id=$1`
+	if _, err = h.DB.ExecContext(ctx, query, id); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to record download of export ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to download export"})
+	}
+
+	h.Logger.WithFields(logrus.Fields{
+		"export_id": id,
+		"user_id":   userID,
+		"ip":        c.RealIP(),
+	}).Info("Personal data export downloaded")
+	return c.Attachment(path.String, fmt.Sprintf("user-%d-export.zip", userID))
+}
+
+func (h *ExportHandler) getExports(ctx context.Context, currentUserID int, all bool, filterUserID, limit, offset int) ([]models.UserExport, error) {
+	query := `SELECT ` + exportColumns + ` FROM user_exports
+	          WHERE ($1 OR user_id = $2 OR requested_by = $2) AND ($3 = 0 OR user_id = $3)
+	          ORDER BY created_at DESC, id DESC
+	          LIMIT $4 OFFSET $5`
+	rows, err := h.DB.QueryContext(ctx, query, all, currentUserID, filterUserID, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to fetch exports")
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []models.UserExport{}
+	for rows.Next() {
+		exp, err := scanExport(rows)
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to scan export")
+			return nil, err
+		}
+		exports = append(exports, *exp)
+	}
+	return exports, rows.Err()
+}
+
+func scanExport(row rowScanner) (*models.UserExport, error) {
+	var exp models.UserExport
+	err := row.Scan(&exp.ID, &exp.UserID, &exp.RequestedBy, &exp.Status, &exp.SizeBytes, &exp.Error, &exp.CreatedAt,
+		&exp.CompletedAt, &exp.ExpiresAt, &exp.DownloadCount, &exp.LastDownloadedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &exp, nil
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"GoArticles/config"
+	"GoArticles/feeds"
+	"GoArticles/i18n"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const feedExcerptLength = 300
+
+type FeedHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+	Config *config.Config
+}
+
+func NewFeedHandler(db *sql.DB, logger *logrus.Logger, cfg *config.Config) *FeedHandler {
+	return &FeedHandler{
+		DB:     db,
+		Logger: logger,
+		Config: cfg,
+	}
+}
+
+// feedSource describes which published articles go into a feed. A language
+// restricts it to articles written in that language.
+type feedSource struct {
+	title    string
+	link     string
+	path     string
+	filter   string
+	args     []interface{}
+	language string
+}
+
+func (h *FeedHandler) GetArticlesFeed(c echo.Context) error {
+	format := c.Param("format")
+	if !feeds.IsFormat(format) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown feed format"})
+	}
+
+	lang, ok := h.feedLanguage(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown language"})
+	}
+
+	source := feedSource{
+		title:    h.Config.SiteTitle,
+		link:     h.Config.BaseURL + "/articles",
+		path:     "/feeds/articles." + format,
+		language: lang,
+	}
+	return h.serveFeed(c, source, format)
+}
+
+func (h *FeedHandler) GetCategoryFeed(c echo.Context) error {
+	categoryID, format, err := parseFeedName(c.Param("feed"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown feed"})
+	}
+
+	lang, ok := h.feedLanguage(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown language"})
+	}
+
+	var name string
+	query := `SELECT COALESCE(ct.name, c.name) FROM categories c
+	          LEFT JOIN category_translations ct ON ct.category_id = c.id AND ct.language = $2
+	          WHERE c.id=$1`
+	if err = h.DB.QueryRowContext(c.Request().Context(), query, categoryID, lang).Scan(&name); err != nil {
+		return h.sourceLookupError(c, err, "Category not found")
+	}
+
+	source := feedSource{
+		title:    fmt.Sprintf("%s: %s", h.Config.SiteTitle, name),
+		link:     fmt.Sprintf("%s/categories/%d/articles", h.Config.BaseURL, categoryID),
+		path:     fmt.Sprintf("/feeds/categories/%d.%s", categoryID, format),
+		filter:   `AND a.id IN (SELECT article_id FROM article_categories WHERE category_id=$1)`,
+		args:     []interface{}{categoryID},
+		language: lang,
+	}
+	return h.serveFeed(c, source, format)
+}
+
+func (h *FeedHandler) GetTagFeed(c echo.Context) error {
+	tagID, format, err := parseFeedName(c.Param("feed"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown feed"})
+	}
+
+	lang, ok := h.feedLanguage(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown language"})
+	}
+
+	var name string
+	query := `SELECT COALESCE(tt.name, t.name) FROM tags t
+	          LEFT JOIN tag_translations tt ON tt.tag_id = t.id AND tt.language = $2
+	          WHERE t.id=$1`
+	if err = h.DB.QueryRowContext(c.Request().Context(), query, tagID, lang).Scan(&name); err != nil {
+		return h.sourceLookupError(c, err, "Tag not found")
+	}
+
+	source := feedSource{
+		title:    fmt.Sprintf("%s: #%s", h.Config.SiteTitle, name),
+		link:     fmt.Sprintf("%s/tags/%d/articles", h.Config.BaseURL, tagID),
+		path:     fmt.Sprintf("/feeds/tags/%d.%s", tagID, format),
+		filter:   `AND a.id IN (SELECT article_id FROM article_tags WHERE tag_id=$1)`,
+		args:     []interface{}{tagID},
+		language: lang,
+	}
+	return h.serveFeed(c, source, format)
+}
+
+func (h *FeedHandler) GetAuthorFeed(c echo.Context) error {
+	authorID, format, err := parseFeedName(c.Param("feed"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown feed"})
+	}
+
+	lang, ok := h.feedLanguage(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown language"})
+	}
+
+	var username string
+	query := `SELECT username FROM users WHERE id=$1`
+	if err = h.DB.QueryRowContext(c.Request().Context(), query, authorID).Scan(&username); err != nil {
+		return h.sourceLookupError(c, err, "Author not found")
+	}
+
+	source := feedSource{
+		title:    fmt.Sprintf("%s: %s", h.Config.SiteTitle, username),
+		link:     fmt.Sprintf("%s/users/%d", h.Config.BaseURL, authorID),
+		path:     fmt.Sprintf("/feeds/authors/%d.%s", authorID, format),
+		filter:   `AND a.author_id=$1`,
+		args:     []interface{}{authorID},
+		language: lang,
+	}
+	return h.serveFeed(c, source, format)
+}
+
+// feedLanguage reads the optional ?lang= filter; ok is false for languages
+// the site does not publish in.
+func (h *FeedHandler) feedLanguage(c echo.Context) (lang string, ok bool) {
+	lang = c.QueryParam("lang")
+	if lang == "" {
+		return "", true
+	}
+	lang = i18n.Normalize(lang)
+	return lang, i18n.Contains(h.Config.Languages, lang)
+}
+
+func (h *FeedHandler) sourceLookupError(c echo.Context, err error, notFound string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": notFound})
+	}
+	h.Logger.WithError(err).Error("Failed to look up feed source")
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build feed"})
+}
+
+func (h *FeedHandler) serveFeed(c echo.Context, source feedSource, format string) error {
+	fullContent := h.Config.FeedFullContent
+	switch c.QueryParam("content") {
+	case "full":
+		fullContent = true
+	case "excerpt":
+		fullContent = false
+	}
+
+	feed, etag, err := h.buildFeed(c.Request().Context(), source, fullContent)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build feed"})
+	}
+	etag = fmt.Sprintf(`W/"%s-%s"`, format, etag)
+
+	res := c.Response()
+	res.Header().Set("ETag", etag)
+	if !feed.Updated.IsZero() {
+		res.Header().Set("Last-Modified", feed.Updated.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c.Request(), etag, feed.Updated) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	body, contentType, err := feeds.Render(feed, format)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to render %s feed", format)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to render feed"})
+	}
+
+	return c.Blob(http.StatusOK, contentType, body)
+}
+
+// buildFeed loads the newest published articles for the source and returns
+// the feed along with a validator that changes whenever any item does.
+func (h *FeedHandler) buildFeed(ctx context.Context, source feedSource, fullContent bool) (feeds.Feed, string, error) {
+	h.Logger.Infof("Building feed %s", source.path)
+
+	filter, args, feedURL := source.filter, source.args, h.Config.BaseURL+source.path
+	if source.language != "" {
+		args = append(args[:len(args):len(args)], source.language)
+		filter += fmt.Sprintf(" AND a.language=$%d", len(args))
+		feedURL += "?lang=" + source.language
+	}
+
+	query := fmt.Sprintf(`SELECT a.id, a.title, a.content, a.published_at, a.updated_at, u.username
+		FROM articles a
+		JOIN users u ON u.id = a.author_id
+		WHERE a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL %s
+		ORDER BY a.published_at DESC
+		LIMIT %d`, filter, h.Config.FeedSize)
+
+	feed := feeds.Feed{
+		Title:       source.title,
+		Description: source.title,
+		Link:        source.link,
+		FeedURL:     feedURL,
+		Language:    source.language,
+	}
+
+	rows, err := h.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch articles for feed %s", source.path)
+		return feed, "", err
+	}
+	defer rows.Close()
+
+	validator := sha1.New()
+	fmt.Fprintf(validator, "%t;", fullContent)
+
+	for rows.Next() {
+		var (
+			id        int
+			title     string
+			content   string
+			published time.Time
+			updated   time.Time
+			author    string
+		)
+		if err = rows.Scan(&id, &title, &content, &published, &updated, &author); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan article for feed %s", source.path)
+			return feed, "", err
+		}
+
+		link := fmt.Sprintf("%s/articles/%d", h.Config.BaseURL, id)
+		item := feeds.Item{
+			ID:        link,
+			Title:     title,
+			Link:      link,
+			Author:    author,
+			Summary:   feeds.Excerpt(content, feedExcerptLength),
+			Published: published,
+			Updated:   updated,
+		}
+		if fullContent {
+			item.Content = content
+		}
+		feed.Items = append(feed.Items, item)
+
+		if updated.After(feed.Updated) {
+			feed.Updated = updated
+		}
+		if published.After(feed.Updated) {
+			feed.Updated = published
+		}
+		fmt.Fprintf(validator, "%d:%d;", id, updated.UnixNano())
+	}
+
+	if err = rows.Err(); err != nil {
+		h.Logger.WithError(err).Errorf("Error occurred during rows iteration for feed %s", source.path)
+		return feed, "", err
+	}
+
+	h.Logger.Infof("Built feed %s with %d items", source.path, len(feed.Items))
+	return feed, hex.EncodeToString(validator.Sum(nil)), nil
+}
+
+// parseFeedName splits a path segment such as "12.atom" into the numeric ID
+// and the feed format.
+func parseFeedName(name string) (int, string, error) {
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return 0, "", fmt.Errorf("missing feed format in %q", name)
+	}
+
+	format := name[dot+1:]
+	if !feeds.IsFormat(format) {
+		return 0, "", fmt.Errorf("unknown feed format %q", format)
+	}
+
+	id, err := strconv.Atoi(name[:dot])
+	if err != nil {
+		return 0, "", err
+	}
+	return id, format, nil
+}
+
+// notModified reports whether the client's cached copy is still current.
+// If-None-Match takes precedence over If-Modified-Since as per RFC 9110.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		if err == nil && !lastModified.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// followTargets maps the plural used in URLs to the stored target type and
+// the table holding the followed entity.
+var followTargets = map[string]struct {
+	targetType string
+	lookup     string
+}{
+	"authors":    {"author", `SELECT username FROM users WHERE id=$1 AND deleted_at IS NULL`},
+	"categories": {"category", `SELECT name FROM categories WHERE id=$1`},
+	"tags":       {"tag", `SELECT name FROM tags WHERE id=$1`},
+}
+
+type FollowHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+}
+
+func NewFollowHandler(db *sql.DB, logger *logrus.Logger) *FollowHandler {
+	return &FollowHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+func (h *FollowHandler) Follow(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+
+	target, ok := followTargets[c.Param("type")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown follow target type"})
+	}
+
+	targetID, err := strconv.Atoi(c.Param("target_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid target ID"})
+	}
+
+	if target.targetType == "author" && targetID == userID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Users cannot follow themselves"})
+	}
+
+	var name string
+	if err = h.DB.QueryRowContext(c.Request().Context(), target.lookup, targetID).Scan(&name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Follow target not found"})
+		}
+		h.Logger.WithError(err).Error("Failed to look up follow target")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to follow"})
+	}
+
+	h.Logger.Infof("User ID %d follows %s ID %d", userID, target.targetType, targetID)
+
+	query := `INSERT INTO follows (follower_id, target_type, target_id) VALUES ($1, $2, $3)
+	          ON CONFLICT (follower_id, target_type, target_id) DO NOTHING`
+	if _, err = h.DB.ExecContext(c.Request().Context(), query, userID, target.targetType, targetID); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to follow %s ID %d by user ID %d", target.targetType, targetID, userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to follow"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *FollowHandler) Unfollow(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+
+	target, ok := followTargets[c.Param("type")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown follow target type"})
+	}
+
+	targetID, err := strconv.Atoi(c.Param("target_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid target ID"})
+	}
+
+	h.Logger.Infof("User ID %d unfollows %s ID %d", userID, target.targetType, targetID)
+
+	query := `DELETE FROM follows WHERE follower_id=$1 AND target_type=$2 AND target_id=$3`
+	if _, err = h.DB.ExecContext(c.Request().Context(), query, userID, target.targetType, targetID); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to unfollow %s ID %d by user ID %d", target.targetType, targetID, userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unfollow"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *FollowHandler) GetFollowers(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	limit, offset := parsePagination(c)
+
+	query := `SELECT u.id, u.username, f.created_at
+	          FROM follows f
+	          JOIN users u ON u.id = f.follower_id
+	          WHERE f.target_type = 'author' AND f.target_id = $1
+	          ORDER BY f.created_at DESC, u.id
+	          LIMIT $2 OFFSET $3`
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, userID, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch followers of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch followers"})
+	}
+	defer rows.Close()
+
+	followers := []models.Follower{}
+	for rows.Next() {
+		var follower models.Follower
+		if err = rows.Scan(&follower.UserID, &follower.Username, &follower.FollowedAt); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan follower of user ID %d", userID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch followers"})
+		}
+		followers = append(followers, follower)
+	}
+
+	return c.JSON(http.StatusOK, followers)
+}
+
+func (h *FollowHandler) GetFollowing(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	limit, offset := parsePagination(c)
+
+	query := `SELECT f.target_type, f.target_id, COALESCE(u.username, c.name, t.name, ''), f.created_at
+	          FROM follows f
+	          LEFT JOIN users u ON f.target_type = 'author' AND u.id = f.target_id
+	          LEFT JOIN categories c ON f.target_type = 'category' AND c.id = f.target_id
+	          LEFT JOIN tags t ON f.target_type = 'tag' AND t.id = f.target_id
+	          WHERE f.follower_id = $1
+	          ORDER BY f.created_at DESC, f.target_type, f.target_id
+	          LIMIT $2 OFFSET $3`
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, userID, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch sources followed by user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch followed sources"})
+	}
+	defer rows.Close()
+
+	sources := []models.FollowedSource{}
+	for rows.Next() {
+		var source models.FollowedSource
+		if err = rows.Scan(&source.Type, &source.ID, &source.Name, &source.FollowedAt); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan source followed by user ID %d", userID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch followed sources"})
+		}
+		sources = append(sources, source)
+	}
+
+	return c.JSON(http.StatusOK, sources)
+}
+
+// GetFeed returns the newest published articles from everything the current
+// user follows. Pages are chained with the opaque next_cursor value.
+func (h *FollowHandler) GetFeed(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+
+	var (
+		before   *time.Time
+		beforeID int
+	)
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		t, id, err := decodeFeedCursor(cursor)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid cursor"})
+		}
+		before, beforeID = &t, id
+	}
+
+	limit, _ := parsePagination(c)
+	page, err := h.getFeed(c.Request().Context(), userID, before, beforeID, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch feed"})
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+func (h *FollowHandler) getFeed(ctx context.Context, userID int, before *time.Time, beforeID, limit int) (*models.FeedPage, error) {
+	h.Logger.Infof("Building personal feed for user ID %d", userID)
+
+	query := `SELECT ` + articleColumns + `
+	          FROM articles a
+	          WHERE a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+	            AND ($2::timestamp IS NULL OR (a.published_at, a.id) < ($2, $3))
+	            AND (
+	                a.author_id IN (SELECT target_id FROM follows WHERE follower_id = $1 AND target_type = 'author')
+	                OR a.id IN (SELECT ac.article_id FROM article_categories ac
+	                            JOIN follows f ON f.target_type = 'category' AND f.target_id = ac.category_id
+	                            WHERE f.follower_id = $1)
+	                OR a.id IN (SELECT atg.article_id FROM article_tags atg
+	                            JOIN follows f ON f.target_type = 'tag' AND f.target_id = atg.tag_id
+	                            WHERE f.follower_id = $1)
+	            )
+	          ORDER BY a.published_at DESC, a.id DESC
+	          LIMIT $4`
+	rows, err := h.DB.QueryContext(ctx, query, userID, before, beforeID, limit)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch feed for user ID %d", userID)
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.FeedPage{Articles: []models.Article{}}
+	for rows.Next() {
+		var article models.Article
+		if err = scanArticle(rows, &article); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan feed article for user ID %d", userID)
+			return nil, err
+		}
+		page.Articles = append(page.Articles, article)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Articles) == limit {
+		last := page.Articles[len(page.Articles)-1]
+		page.NextCursor = encodeFeedCursor(*last.PublishedAt, last.ID)
+	}
+
+	return page, nil
+}
+
+func encodeFeedCursor(publishedAt time.Time, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", publishedAt.UnixNano(), id)))
+}
+
+func decodeFeedCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor")
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	articleID, err := strconv.Atoi(id)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	return time.Unix(0, n).UTC(), articleID, nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"GoArticles/config"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"sort"
+	"time"
+)
+
+func accountThrottleKey(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// lockLoginAttempts locks the counters of the keys until tx ends, creating
+// them as needed, so that concurrent attempts for the same account or address
+// wait for each other's outcome instead of all passing loginWait before any
+// failure is recorded. Keys are locked in order to avoid deadlocks.
+func lockLoginAttempts(ctx context.Context, tx *sql.Tx, keys ...string) error {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	query := `INSERT INTO login_attempts (key) SELECT unnest($1::TEXT[]) ON CONFLICT (key) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(keys)); err != nil {
+		return err
+	}
+	query = `SELECT key FROM login_attempts WHERE key = ANY($1) ORDER BY key FOR UPDATE`
+	_, err := tx.ExecContext(ctx, query, pq.Array(keys))
+	return err
+}
+
+// loginWait returns how long the client has to wait before the next login
+// attempt for any of the keys is accepted, or 0. It covers both the
+// progressive delay after a failure and lockouts.
+func loginWait(ctx context.Context, tx *sql.Tx, cfg *config.Config, keys ...string) (time.Duration, error) {
+	query := `SELECT failures, last_failure_at, locked_until, NOW() FROM login_attempts
+	          WHERE key = ANY($1) AND (last_failure_at > NOW() - make_interval(secs => $2) OR locked_until > NOW())`
+	rows, err := tx.QueryContext(ctx, query, pq.Array(keys), cfg.LoginFailureWindow.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var wait time.Duration
+	for rows.Next() {
+		var (
+			failures    int
+			lastFailure time.Time
+			lockedUntil sql.NullTime
+			now         time.Time
+		)
+		if err = rows.Scan(&failures, &lastFailure, &lockedUntil, &now); err != nil {
+			return 0, err
+		}
+
+		until := lastFailure.Add(failureDelay(cfg, failures))
+		if lockedUntil.Valid && lockedUntil.Time.After(until) {
+			until = lockedUntil.Time
+		}
+		if d := until.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, rows.Err()
+}
+
+// failureDelay doubles with every failure, from LoginBaseDelay up to
+// LoginMaxDelay.
+func failureDelay(cfg *config.Config, failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := cfg.LoginBaseDelay
+	for i := 1; i < failures && delay < cfg.LoginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > cfg.LoginMaxDelay {
+		delay = cfg.LoginMaxDelay
+	}
+	return delay
+}
+
+// recordLoginFailure counts a failed attempt for the key. Failures older
+// than the window are forgotten. Once maxFailures is reached the key is
+// locked and the count starts over; the result reports whether this failure
+// caused a lockout.
+func recordLoginFailure(ctx context.Context, tx *sql.Tx, cfg *config.Config, key string, maxFailures int) (bool, error) {
+	var failures int
+	query := `INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, NOW())
+	          ON CONFLICT (key) DO UPDATE SET
+	              failures = CASE WHEN login_attempts.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+	                              ELSE login_attempts.failures + 1 END,
+	              last_failure_at = NOW()
+	          RETURNING failures`
+	if err := tx.QueryRowContext(ctx, query, key, cfg.LoginFailureWindow.Seconds()).Scan(&failures); err != nil {
+		return false, err
+	}
+
+	if maxFailures <= 0 || failures < maxFailures {
+		return false, nil
+	}
+
+	query = `UPDATE login_attempts SET failures = 0, locked_until = NOW() + make_interval(secs => $2) WHERE key = $1`
+	_, err := tx.ExecContext(ctx, query, key, cfg.LoginLockout.Seconds())
+	return err == nil, err
+}
+
+// clearLoginFailures forgets the failures of the key, e.g. after a successful
+// login, along with counters that have expired.
+func clearLoginFailures(ctx context.Context, db *sql.DB, cfg *config.Config, key string) error {
+	query := `DELETE FROM login_attempts
+	          WHERE key = $1
+	             OR (last_failure_at < NOW() - make_interval(secs => $2) AND (locked_until IS NULL OR locked_until < NOW()))`
+	_, err := db.ExecContext(ctx, query, key, cfg.LoginFailureWindow.Seconds())
+	return err
+}
@@ -1 +1,17 @@
 package handlers
+
+import (
+	"context"
+	"database/sql"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func createNotification(ctx context.Context, db execer, userID int, message string) error {
+	query := `INSERT INTO notifications (user_id, message, is_read, created_at) VALUES ($1, $2, FALSE, NOW())`
+	_, err := db.ExecContext(ctx, query, userID, message)
+	return err
+}
@@ -1,16 +1,31 @@
 package handlers
 
 import (
+	"GoArticles/audit"
+	"GoArticles/auth"
 	"GoArticles/models"
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	roleColumns          = `id, name, description, parent_id, is_system, require_2fa, is_default`
+	maxDescriptionLength = 500
+)
+
+var roleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{2,32}$`)
+
 type RoleHandler struct {
 	DB     *sql.DB
 	Logger *logrus.Logger
@@ -35,7 +50,7 @@ func (h *RoleHandler) GetRoles(c echo.Context) error {
 }
 
 func (h *RoleHandler) getRoles(ctx context.Context) ([]models.Role, error) {
-	query := `SELECT id, name FROM roles`
+	query := `SELECT ` + roleColumns + ` FROM roles ORDER BY id`
 	rows, err := h.DB.QueryContext(ctx, query)
 	if err != nil {
 		h.Logger.WithError(err).Error("Error executing query to fetch roles")
@@ -45,16 +60,295 @@ func (h *RoleHandler) getRoles(ctx context.Context) ([]models.Role, error) {
 
 	var roles []models.Role
 	for rows.Next() {
-		var role models.Role
-		if err = rows.Scan(&role.ID, &role.Name); err != nil {
+		role, err := scanRole(rows)
+		if err != nil {
 			h.Logger.WithError(err).Error("Error scanning role from database")
 			return nil, err
 		}
-		roles = append(roles, role)
+		roles = append(roles, *role)
 	}
 	return roles, nil
 }
 
+type roleInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	// ParentID sets the role to inherit from; 0 removes the parent.
+	ParentID *int `json:"parent_id"`
+}
+
+// CreateRole adds a role, optionally inheriting from a parent role. Requires
+// roles.manage.
+func (h *RoleHandler) CreateRole(c echo.Context) error {
+	var input roleInput
+	if err := c.Bind(&input); err != nil || input.Name == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Role name is required"})
+	}
+	if msg := validateRoleInput(&input); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+	if input.Description == nil {
+		input.Description = new(string)
+	}
+
+	if !requireRolesManage(c, h.DB, h.Logger) {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	if taken, err := roleNameTaken(ctx, tx, *input.Name, 0); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to check role name")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create role"})
+	} else if taken {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Role name is already taken"})
+	}
+
+	var parentID *int
+	if input.ParentID != nil && *input.ParentID != 0 {
+		parentID = input.ParentID
+	}
+	query := `INSERT INTO roles (name, description, parent_id) VALUES ($1, $2, $3) RETURNING ` + roleColumns
+	role, err := scanRole(tx.QueryRowContext(ctx, query, *input.Name, *input.Description, parentID))
+	if err != nil {
+		tx.Rollback()
+		if isForeignKeyViolation(err) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Parent role not found"})
+		}
+		h.Logger.WithError(err).Error("Failed to create role")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create role"})
+	}
+
+	audit.Target(c, role.ID)
+	audit.After(c, role)
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to audit role creation")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create role"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithFields(logrus.Fields{"role_id": role.ID, "name": role.Name}).Info("Role created")
+	return c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole changes the name, description or parent of a role. System roles
+// keep their name, and a role cannot inherit from itself or its
+// descendants. Requires roles.manage.
+func (h *RoleHandler) UpdateRole(c echo.Context) error {
+	roleID, err := strconv.Atoi(c.Param("role_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role ID"})
+	}
+
+	var input roleInput
+	if err = c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+	if msg := validateRoleInput(&input); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	if !requireRolesManage(c, h.DB, h.Logger) {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	role, err := scanRole(tx.QueryRowContext(ctx, `SELECT `+roleColumns+` FROM roles WHERE id=$1 FOR UPDATE`, roleID))
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to fetch role ID %d", roleID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update role"})
+	}
+	audit.Before(c, *role)
+
+	if input.Name != nil && *input.Name != role.Name {
+		if role.IsSystem {
+			tx.Rollback()
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Built-in roles cannot be renamed"})
+		}
+		if taken, err := roleNameTaken(ctx, tx, *input.Name, roleID); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Error("Failed to check role name")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update role"})
+		} else if taken {
+			tx.Rollback()
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Role name is already taken"})
+		}
+		role.Name = *input.Name
+	}
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+	if input.ParentID != nil {
+		role.ParentID = nil
+		if *input.ParentID != 0 {
+			cycle, err := inheritsFrom(ctx, tx, *input.ParentID, roleID)
+			if err != nil {
+				tx.Rollback()
+				h.Logger.WithError(err).Error("Failed to check role inheritance")
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update role"})
+			}
+			if cycle {
+				tx.Rollback()
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "A role cannot inherit from itself or its descendants"})
+			}
+			role.ParentID = input.ParentID
+		}
+	}
+
+	query := `UPDATE roles SET name=$1, description=$2, parent_id=$3 WHERE id=$4 RETURNING ` + roleColumns
+	role, err = scanRole(tx.QueryRowContext(ctx, query, role.Name, role.Description, role.ParentID, roleID))
+	if err != nil {
+		tx.Rollback()
+		if isForeignKeyViolation(err) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Parent role not found"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to update role ID %d", roleID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update role"})
+	}
+
+	audit.After(c, role)
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to audit role update")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update role"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithField("role_id", roleID).Info("Role updated")
+	return c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole removes a role along with its assignments and permission links.
+// Roles inheriting from it lose their parent. System roles and the default
+// role cannot be deleted. Requires roles.manage.
+func (h *RoleHandler) DeleteRole(c echo.Context) error {
+	roleID, err := strconv.Atoi(c.Param("role_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role ID"})
+	}
+
+	if !requireRolesManage(c, h.DB, h.Logger) {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	role, err := scanRole(tx.QueryRowContext(ctx, `SELECT `+roleColumns+` FROM roles WHERE id=$1 FOR UPDATE`, roleID))
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to fetch role ID %d", roleID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete role"})
+	}
+	if role.IsSystem {
+		tx.Rollback()
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Built-in roles cannot be deleted"})
+	}
+	if role.IsDefault {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Choose another default role before deleting this one"})
+	}
+
+	for _, query := range []string{
+		`DELETE FROM user_roles WHERE role_id=$1`,
+		`DELETE FROM role_permissions WHERE role_id=$1`,
+		`DELETE FROM roles WHERE id=$1`,
+	} {
+		if _, err = tx.ExecContext(ctx, query, roleID); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to delete role ID %d", roleID)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete role"})
+		}
+	}
+
+	audit.Before(c, role)
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to audit role deletion")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete role"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithFields(logrus.Fields{"role_id": roleID, "name": role.Name}).Info("Role deleted")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SetTwoFactorRequirement makes two-factor authentication mandatory for the
+// role's members, or optional again. Requires roles.manage.
+func (h *RoleHandler) SetTwoFactorRequirement(c echo.Context) error {
+	roleID, err := strconv.Atoi(c.Param("role_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role ID"})
+	}
+
+	var input struct {
+		Required bool `json:"required"`
+	}
+	if err = c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+
+	if !requireRolesManage(c, h.DB, h.Logger) {
+		return nil
+	}
+
+	audit.After(c, input)
+	result, err := execAudited(c, h.DB, `UPDATE roles SET require_2fa = $1 WHERE id=$2`, input.Required, roleID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to update 2FA requirement of role ID %d", roleID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update role"})
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+
+	h.Logger.Infof("Two-factor requirement of role %d set to %t", roleID, input.Required)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AssignRoleToUser adds a role to the user's roles. With ?category_id= the
+// role only applies to articles in that category and the categories below
+// it. Assigning a role the user already holds changes nothing. Requires
+// roles.manage.
 func (h *RoleHandler) AssignRoleToUser(c echo.Context) error {
 	userID, err := strconv.Atoi(c.Param("user_id"))
 	if err != nil {
@@ -68,29 +362,41 @@ func (h *RoleHandler) AssignRoleToUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role ID"})
 	}
 
-	if err = h.removeRoleFromUser(c.Request().Context(), userID, 3); err != nil {
-		h.Logger.WithError(err).Error("Failed to remove existing roles from user")
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove existing roles"})
+	categoryID, err := roleScope(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid category ID"})
+	}
+
+	if !requireRolesManage(c, h.DB, h.Logger) {
+		return nil
 	}
 
-	if err = h.assignRoleToUser(c.Request().Context(), userID, roleID); err != nil {
+	audit.After(c, models.UserRole{UserID: userID, RoleID: roleID, CategoryID: categoryID})
+	if err = h.assignRoleToUser(c, userID, roleID, categoryID); err != nil {
+		if isForeignKeyViolation(err) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User, role or category not found"})
+		}
 		h.Logger.WithError(err).Error("Failed to assign new role to user")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to assign new role to user"})
 	}
 
-	h.Logger.Infof("Role %d assigned to user %d successfully", roleID, userID)
+	h.Logger.WithFields(logrus.Fields{"user_id": userID, "role_id": roleID, "category_id": categoryID}).Info("Role assigned to user")
 	return c.NoContent(http.StatusNoContent)
 }
 
-func (h *RoleHandler) assignRoleToUser(ctx context.Context, userID, roleID int) error {
-	query := `INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2)`
-	_, err := h.DB.ExecContext(ctx, query, userID, roleID)
+func (h *RoleHandler) assignRoleToUser(c echo.Context, userID, roleID int, categoryID *int) error {
+	query := `INSERT INTO user_roles (user_id, role_id, category_id) VALUES ($1, $2, $3)
+	          ON CONFLICT (user_id, role_id, COALESCE(category_id, 0)) DO NOTHING`
+	_, err := execAudited(c, h.DB, query, userID, roleID, categoryID)
 	if err != nil {
 		h.Logger.WithError(err).Error("Error executing query to assign role to user")
 	}
 	return err
 }
 
+// RemoveRoleFromUser takes a role away from the user; removing a role the
+// user does not hold changes nothing. Without ?category_id= only the
+// unscoped assignment is removed. Requires roles.manage.
 func (h *RoleHandler) RemoveRoleFromUser(c echo.Context) error {
 	userID, err := strconv.Atoi(c.Param("user_id"))
 	if err != nil {
@@ -104,14 +410,19 @@ func (h *RoleHandler) RemoveRoleFromUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role ID"})
 	}
 
-	if roleID == 3 {
-		h.Logger.Warn("Attempted to remove role with ID 3, which is not allowed")
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Cannot remove role with ID 3"})
+	categoryID, err := roleScope(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid category ID"})
+	}
+
+	if !requireRolesManage(c, h.DB, h.Logger) {
+		return nil
 	}
 
 	h.Logger.Infof("Removing role %d from user %d", roleID, userID)
 
-	if err = h.removeRoleFromUser(c.Request().Context(), userID, roleID); err != nil {
+	audit.Before(c, models.UserRole{UserID: userID, RoleID: roleID, CategoryID: categoryID})
+	if err = h.removeRoleFromUser(c, userID, roleID, categoryID); err != nil {
 		h.Logger.WithError(err).Error("Failed to remove role from user")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove role from user"})
 	}
@@ -120,11 +431,243 @@ func (h *RoleHandler) RemoveRoleFromUser(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
-func (h *RoleHandler) removeRoleFromUser(ctx context.Context, userID, roleID int) error {
-	query := `DELETE FROM user_roles WHERE user_id=$1 AND role_id=$2`
-	_, err := h.DB.ExecContext(ctx, query, userID, roleID)
+func (h *RoleHandler) removeRoleFromUser(c echo.Context, userID, roleID int, categoryID *int) error {
+	query := `DELETE FROM user_roles WHERE user_id=$1 AND role_id=$2 AND category_id IS NOT DISTINCT FROM $3`
+	_, err := execAudited(c, h.DB, query, userID, roleID, categoryID)
 	if err != nil {
 		h.Logger.WithError(err).Error("Error executing query to remove role from user")
 	}
 	return err
 }
+
+// roleScope reads the optional category_id query parameter of a role
+// assignment; nil means the assignment applies everywhere.
+func roleScope(c echo.Context) (*int, error) {
+	raw := c.QueryParam("category_id")
+	if raw == "" {
+		return nil, nil
+	}
+	categoryID, err := strconv.Atoi(raw)
+	if err != nil || categoryID < 1 {
+		return nil, errors.New("invalid category ID")
+	}
+	return &categoryID, nil
+}
+
+// SetUserRoles replaces the user's roles with the role IDs in the JSON list
+// body, all at once. An empty list removes every role. Roles scoped to a
+// category are left alone. Requires roles.manage.
+func (h *RoleHandler) SetUserRoles(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	var roleIDs []int
+	if err = c.Bind(&roleIDs); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Body must be a list of role IDs"})
+	}
+	if roleIDs == nil {
+		roleIDs = []int{}
+	}
+
+	if !requireRolesManage(c, h.DB, h.Logger) {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	// Lock the user so that concurrent replacements apply one after the
+	// other.
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT TRUE FROM users WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to lock user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set roles"})
+	}
+
+	var missing []int64
+	query := `SELECT COALESCE(array_agg(id), '{}') FROM unnest($1::INTEGER[]) AS ids (id)
+	          WHERE NOT EXISTS (SELECT 1 FROM roles r WHERE r.id = ids.id)`
+	if err = tx.QueryRowContext(ctx, query, pq.Array(roleIDs)).Scan(pq.Array(&missing)); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to check roles")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set roles"})
+	}
+	if len(missing) > 0 {
+		tx.Rollback()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown role IDs: %v", missing)})
+	}
+
+	var previous []int64
+	query = `SELECT COALESCE(array_agg(role_id ORDER BY role_id), '{}') FROM user_roles WHERE user_id=$1 AND category_id IS NULL`
+	if err = tx.QueryRowContext(ctx, query, userID).Scan(pq.Array(&previous)); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to fetch roles of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set roles"})
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id=$1 AND category_id IS NULL AND NOT role_id = ANY($2)`, userID, pq.Array(roleIDs)); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to remove roles of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set roles"})
+	}
+	query = `INSERT INTO user_roles (user_id, role_id) SELECT $1, unnest($2::INTEGER[])
+	         ON CONFLICT (user_id, role_id, COALESCE(category_id, 0)) DO NOTHING`
+	if _, err = tx.ExecContext(ctx, query, userID, pq.Array(roleIDs)); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to add roles of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set roles"})
+	}
+
+	audit.Before(c, previous)
+	audit.After(c, roleIDs)
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to audit role replacement")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set roles"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithFields(logrus.Fields{"user_id": userID, "role_ids": roleIDs}).Info("User roles replaced")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SetDefaultRole chooses by name the role new accounts get. Requires
+// roles.manage.
+func (h *RoleHandler) SetDefaultRole(c echo.Context) error {
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&input); err != nil || input.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Role name is required"})
+	}
+
+	if !requireRolesManage(c, h.DB, h.Logger) {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE roles SET is_default = FALSE WHERE is_default AND name <> $1`, input.Name); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to clear default role")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set default role"})
+	}
+	result, err := tx.ExecContext(ctx, `UPDATE roles SET is_default = TRUE WHERE name = $1`, input.Name)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to make role %q the default", input.Name)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set default role"})
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+
+	audit.After(c, input)
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to audit default role change")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set default role"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.Infof("Default role set to %q", input.Name)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// requireRolesManage reports whether the current user holds roles.manage and
+// otherwise writes the error response.
+func requireRolesManage(c echo.Context, db queryRower, logger *logrus.Logger) bool {
+	userID, _ := auth.UserID(c)
+	allowed, err := hasPermission(c.Request().Context(), db, userID, "roles.manage")
+	if err != nil {
+		logger.WithError(err).Error("Failed to check permissions")
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check permissions"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to manage roles"})
+		return false
+	}
+	return true
+}
+
+func validateRoleInput(input *roleInput) string {
+	if input.Name != nil {
+		*input.Name = strings.TrimSpace(*input.Name)
+		if !roleNamePattern.MatchString(*input.Name) {
+			return "Role name must be 2 to 32 letters, digits, dashes or underscores"
+		}
+	}
+	if input.Description != nil {
+		*input.Description = strings.TrimSpace(*input.Description)
+		if utf8.RuneCountInString(*input.Description) > maxDescriptionLength {
+			return "Description is too long"
+		}
+	}
+	return ""
+}
+
+func roleNameTaken(ctx context.Context, db queryRower, name string, exceptID int) (bool, error) {
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM roles WHERE LOWER(name) = LOWER($1) AND id <> $2)`
+	err := db.QueryRowContext(ctx, query, name, exceptID).Scan(&taken)
+	return taken, err
+}
+
+// inheritsFrom reports whether descendant is role or inherits from it,
+// directly or through other roles. Making descendant the parent of role would
+// then create a cycle.
+func inheritsFrom(ctx context.Context, db queryRower, descendant, role int) (bool, error) {
+	var found bool
+	query := `WITH RECURSIVE ancestors (id) AS (
+	              SELECT $1::INTEGER
+	              UNION
+	              SELECT r.parent_id FROM roles r JOIN ancestors a ON r.id = a.id WHERE r.parent_id IS NOT NULL
+	          )
+	          SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`
+	err := db.QueryRowContext(ctx, query, descendant, role).Scan(&found)
+	return found, err
+}
+
+func scanRole(row rowScanner) (*models.Role, error) {
+	var role models.Role
+	err := row.Scan(&role.ID, &role.Name, &role.Description, &role.ParentID, &role.IsSystem, &role.Require2FA, &role.IsDefault)
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// assignDefaultRole gives a new account the default role, if one is set.
+func assignDefaultRole(ctx context.Context, db execer, userID int) error {
+	query := `INSERT INTO user_roles (user_id, role_id) SELECT $1, id FROM roles WHERE is_default
+	          ON CONFLICT (user_id, role_id, COALESCE(category_id, 0)) DO NOTHING`
+	_, err := db.ExecContext(ctx, query, userID)
+	return err
+}
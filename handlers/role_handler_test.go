@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/database/dbtest"
+	"context"
+	"database/sql/driver"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoleChangesRequireRolesManage(t *testing.T) {
+	requests := []struct {
+		method, target, body string
+	}{
+		{http.MethodPost, "/roles", `{"name":"moderator"}`},
+		{http.MethodDelete, "/roles/5", ""},
+		{http.MethodPut, "/users/2/roles/5", ""},
+		{http.MethodDelete, "/users/2/roles/5", ""},
+		{http.MethodPut, "/users/2/roles", `[5]`},
+	}
+	for _, tt := range requests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			db, script := dbtest.New()
+			defer db.Close()
+			script.Returns("WHERE p.name = $2 AND er.category_id IS NULL", dbtest.Result{
+				Columns: []string{"exists"}, Rows: [][]driver.Value{{false}},
+			})
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+			h := NewRoleHandler(db, logger)
+			tokens := auth.NewTokenManager("secret", time.Hour)
+			e := echo.New()
+			e.Use(tokens.Middleware(nil, func(context.Context, int, int, string, string) error { return nil }))
+			e.POST("/roles", h.CreateRole, auth.RequireUser)
+			e.DELETE("/roles/:role_id", h.DeleteRole, auth.RequireUser)
+			e.PUT("/users/:id/roles", h.SetUserRoles, auth.RequireUser)
+			e.PUT("/users/:user_id/roles/:role_id", h.AssignRoleToUser, auth.RequireUser)
+			e.DELETE("/users/:user_id/roles/:role_id", h.RemoveRoleFromUser, auth.RequireUser)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			token, _ := tokens.Issue(2, 1)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("got status %d, want %d", rec.Code, http.StatusForbidden)
+			}
+			for _, statement := range script.Statements() {
+				if !strings.Contains(statement.Query, "er.category_id IS NULL") {
+					t.Errorf("ran %q after denying the request", statement.Query)
+				}
+			}
+		})
+	}
+}
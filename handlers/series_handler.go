@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errSeriesNotFound     = errors.New("series not found")
+	errNotSeriesOwner     = errors.New("series belongs to another author")
+	errArticleNotOwned    = errors.New("article belongs to another author")
+	errArticleInSeries    = errors.New("article already belongs to a series")
+	errArticleNotInSeries = errors.New("article is not part of the series")
+)
+
+type SeriesHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+}
+
+func NewSeriesHandler(db *sql.DB, logger *logrus.Logger) *SeriesHandler {
+	return &SeriesHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+type seriesInput struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+}
+
+func (h *SeriesHandler) CreateSeries(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+
+	var input seriesInput
+	if err := c.Bind(&input); err != nil || input.Title == nil || strings.TrimSpace(*input.Title) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Series title is required"})
+	}
+
+	series := models.Series{AuthorID: userID, Title: strings.TrimSpace(*input.Title)}
+	if input.Description != nil {
+		series.Description = *input.Description
+	}
+
+	query := `INSERT INTO series (author_id, title, description) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at`
+	err := h.DB.QueryRowContext(c.Request().Context(), query, userID, series.Title, series.Description).
+		Scan(&series.ID, &series.CreatedAt, &series.UpdatedAt)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to create series for user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create series"})
+	}
+
+	h.Logger.Infof("Created series ID %d for user ID %d", series.ID, userID)
+	return c.JSON(http.StatusCreated, series)
+}
+
+// GetSeries returns the series with its table of contents. Unpublished parts
+// are only listed for the owner.
+func (h *SeriesHandler) GetSeries(c echo.Context) error {
+	seriesID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid series ID"})
+	}
+
+	ctx := c.Request().Context()
+	series, err := h.getSeries(ctx, seriesID)
+	if err != nil {
+		if errors.Is(err, errSeriesNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Series not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch series"})
+	}
+
+	currentUserID, _ := auth.UserID(c)
+	series.Parts, err = h.getSeriesParts(ctx, seriesID, currentUserID != series.AuthorID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch series"})
+	}
+
+	return c.JSON(http.StatusOK, series)
+}
+
+func (h *SeriesHandler) UpdateSeries(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	seriesID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid series ID"})
+	}
+
+	var input seriesInput
+	if err = c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+	if input.Title != nil {
+		title := strings.TrimSpace(*input.Title)
+		if title == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Series title cannot be empty"})
+		}
+		input.Title = &title
+	}
+
+	query := `UPDATE series SET title = COALESCE($1, title), description = COALESCE($2, description), updated_at = NOW()
+	          WHERE id = $3 AND author_id = $4`
+	result, err := h.DB.ExecContext(c.Request().Context(), query, input.Title, input.Description, seriesID, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to update series ID %d", seriesID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update series"})
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Series not found"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteSeries removes the series; its articles stay and simply no longer
+// belong to a series.
+func (h *SeriesHandler) DeleteSeries(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	seriesID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid series ID"})
+	}
+
+	query := `DELETE FROM series WHERE id = $1 AND author_id = $2`
+	result, err := h.DB.ExecContext(c.Request().Context(), query, seriesID, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to delete series ID %d", seriesID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete series"})
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Series not found"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AddSeriesPart puts an article the author may edit into the series, at the end
+// or at the 1-based ?position given.
+func (h *SeriesHandler) AddSeriesPart(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	seriesID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid series ID"})
+	}
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	position := 0
+	if raw := c.QueryParam("position"); raw != "" {
+		if position, err = strconv.Atoi(raw); err != nil || position < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid position"})
+		}
+	}
+
+	err = h.inSeriesTx(c.Request().Context(), seriesID, userID, func(ctx context.Context, tx *sql.Tx) error {
+		return addSeriesPart(ctx, tx, seriesID, userID, articleID, position)
+	})
+	if err != nil {
+		return h.seriesError(c, err, "Failed to add article to series")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *SeriesHandler) RemoveSeriesPart(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	seriesID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid series ID"})
+	}
+	articleID, err := strconv.Atoi(c.Param("article_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	err = h.inSeriesTx(c.Request().Context(), seriesID, userID, func(ctx context.Context, tx *sql.Tx) error {
+		return removeSeriesPart(ctx, tx, seriesID, articleID)
+	})
+	if err != nil {
+		return h.seriesError(c, err, "Failed to remove article from series")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type seriesOrder struct {
+	ArticleIDs []int `json:"article_ids"`
+}
+
+// ReorderSeries sets the order of the series from a complete list of its
+// article IDs.
+func (h *SeriesHandler) ReorderSeries(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	seriesID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid series ID"})
+	}
+
+	var order seriesOrder
+	if err = c.Bind(&order); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+
+	err = h.inSeriesTx(c.Request().Context(), seriesID, userID, func(ctx context.Context, tx *sql.Tx) error {
+		current, err := seriesArticleIDs(ctx, tx, seriesID)
+		if err != nil {
+			return err
+		}
+		if !isPermutation(current, order.ArticleIDs) {
+			return errArticleNotInSeries
+		}
+
+		for i, articleID := range order.ArticleIDs {
+			query := `UPDATE series_articles SET position = $1 WHERE series_id = $2 AND article_id = $3`
+			if _, err = tx.ExecContext(ctx, query, i+1, seriesID, articleID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errArticleNotInSeries) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "article_ids must list every article of the series exactly once"})
+	}
+	if err != nil {
+		return h.seriesError(c, err, "Failed to reorder series")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// inSeriesTx runs fn in a transaction holding a lock on the series, after
+// checking that the user owns it.
+func (h *SeriesHandler) inSeriesTx(ctx context.Context, seriesID, userID int, fn func(context.Context, *sql.Tx) error) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return err
+	}
+
+	var authorID int
+	query := `SELECT author_id FROM series WHERE id = $1 FOR UPDATE`
+	if err = tx.QueryRowContext(ctx, query, seriesID).Scan(&authorID); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return errSeriesNotFound
+		}
+		return err
+	}
+	if authorID != userID {
+		tx.Rollback()
+		return errNotSeriesOwner
+	}
+
+	if err = fn(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query = `UPDATE series SET updated_at = NOW() WHERE id = $1`
+	if _, err = tx.ExecContext(ctx, query, seriesID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (h *SeriesHandler) seriesError(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, errSeriesNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Series not found"})
+	case errors.Is(err, errNotSeriesOwner):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the series author can change it"})
+	case errors.Is(err, errArticleNotOwned):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only articles the author may edit can be added"})
+	case errors.Is(err, errArticleInSeries):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Article already belongs to a series"})
+	case errors.Is(err, errArticleNotInSeries), errors.Is(err, sql.ErrNoRows):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Article not found in series"})
+	}
+
+	h.Logger.WithError(err).Error(message)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+}
+
+func (h *SeriesHandler) getSeries(ctx context.Context, id int) (*models.Series, error) {
+	query := `SELECT id, author_id, title, description, created_at, updated_at FROM series WHERE id = $1`
+
+	var series models.Series
+	err := h.DB.QueryRowContext(ctx, query, id).Scan(&series.ID, &series.AuthorID, &series.Title,
+		&series.Description, &series.CreatedAt, &series.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSeriesNotFound
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch series ID %d", id)
+		return nil, err
+	}
+
+	return &series, nil
+}
+
+func (h *SeriesHandler) getSeriesParts(ctx context.Context, seriesID int, publishedOnly bool) ([]models.SeriesPart, error) {
+	query := `SELECT sa.position, a.id, a.title, a.published_at
+	          FROM series_articles sa
+	          JOIN articles a ON a.id = sa.article_id
+	          WHERE sa.series_id = $1 AND a.deleted_at IS NULL
+	            AND (NOT $2 OR (a.published_at IS NOT NULL AND a.published_at <= NOW()))
+	          ORDER BY sa.position`
+	rows, err := h.DB.QueryContext(ctx, query, seriesID, publishedOnly)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch parts of series ID %d", seriesID)
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []models.SeriesPart{}
+	for rows.Next() {
+		var part models.SeriesPart
+		if err = rows.Scan(&part.Position, &part.ArticleID, &part.Title, &part.PublishedAt); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan part of series ID %d", seriesID)
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+
+	return parts, rows.Err()
+}
+
+func addSeriesPart(ctx context.Context, tx *sql.Tx, seriesID, userID, articleID, position int) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM articles WHERE id = $1)`
+	if err := tx.QueryRowContext(ctx, query, articleID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	if allowed, err := canEditArticle(ctx, tx, articleID, userID); err != nil {
+		return err
+	} else if !allowed {
+		return errArticleNotOwned
+	}
+
+	var count int
+	query = `SELECT COUNT(*) FROM series_articles WHERE series_id = $1`
+	if err := tx.QueryRowContext(ctx, query, seriesID).Scan(&count); err != nil {
+		return err
+	}
+	if position == 0 || position > count+1 {
+		position = count + 1
+	}
+
+	query = `UPDATE series_articles SET position = position + 1 WHERE series_id = $1 AND position >= $2`
+	if _, err := tx.ExecContext(ctx, query, seriesID, position); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO series_articles (series_id, article_id, position) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, query, seriesID, articleID, position); err != nil {
+		if isUniqueViolation(err) {
+			return errArticleInSeries
+		}
+		return err
+	}
+	return nil
+}
+
+// removeSeriesPart takes an article out of its series and closes the gap.
+// It returns sql.ErrNoRows if the article is not part of the series.
+func removeSeriesPart(ctx context.Context, tx *sql.Tx, seriesID, articleID int) error {
+	var position int
+	query := `DELETE FROM series_articles WHERE series_id = $1 AND article_id = $2 RETURNING position`
+	if err := tx.QueryRowContext(ctx, query, seriesID, articleID).Scan(&position); err != nil {
+		return err
+	}
+
+	query = `UPDATE series_articles SET position = position - 1 WHERE series_id = $1 AND position > $2`
+	_, err := tx.ExecContext(ctx, query, seriesID, position)
+	return err
+}
+
+func seriesArticleIDs(ctx context.Context, tx *sql.Tx, seriesID int) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT article_id FROM series_articles WHERE series_id = $1`, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// getSeriesNavigation returns the series the article belongs to with links to
+// the neighbouring published parts, or nil if it is not part of a series.
+func getSeriesNavigation(ctx context.Context, db *sql.DB, articleID int) (*models.SeriesNavigation, error) {
+	var nav models.SeriesNavigation
+	query := `SELECT s.id, s.title, sa.position, (SELECT COUNT(*) FROM series_articles WHERE series_id = s.id)
+	          FROM series_articles sa
+	          JOIN series s ON s.id = sa.series_id
+	          WHERE sa.article_id = $1`
+	err := db.QueryRowContext(ctx, query, articleID).Scan(&nav.ID, &nav.Title, &nav.Position, &nav.Total)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	neighbour := `SELECT a.id, a.title
+	              FROM series_articles sa
+	              JOIN articles a ON a.id = sa.article_id
+	              WHERE sa.series_id = $1 AND sa.position %s $2
+	                AND a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+	              ORDER BY sa.position %s
+	              LIMIT 1`
+	for _, step := range []struct {
+		link       **models.ArticleLink
+		cmp, order string
+	}{
+		{&nav.Previous, "<", "DESC"},
+		{&nav.Next, ">", "ASC"},
+	} {
+		var link models.ArticleLink
+		err = db.QueryRowContext(ctx, fmt.Sprintf(neighbour, step.cmp, step.order), nav.ID, nav.Position).Scan(&link.ID, &link.Title)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		*step.link = &link
+	}
+
+	return &nav, nil
+}
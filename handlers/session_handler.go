@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionColumns = `id, user_id, ip, user_agent, created_at, last_seen_at, expires_at`
+	// sessionTouchInterval limits how often requests update the last seen
+	// time of their session.
+	sessionTouchInterval = time.Minute
+	maxUserAgentLength   = 512
+)
+
+type SessionHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+}
+
+func NewSessionHandler(db *sql.DB, logger *logrus.Logger) *SessionHandler {
+	return &SessionHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+// GetSessions lists the active sessions of a user to the user and holders of
+// users.manage, most recently used first.
+func (h *SessionHandler) GetSessions(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, userID); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch sessions"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to see other users' sessions"})
+	}
+
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+	          WHERE user_id=$1 AND revoked_at IS NULL AND expires_at > NOW()
+	          ORDER BY last_seen_at DESC, id DESC`
+	rows, err := h.DB.QueryContext(c.Request().Context(), query, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch sessions of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch sessions"})
+	}
+	defer rows.Close()
+
+	currentSessionID, _ := auth.SessionID(c)
+	sessions := make([]*models.Session, 0)
+	for rows.Next() {
+		var session models.Session
+		err = rows.Scan(&session.ID, &session.UserID, &session.IP, &session.UserAgent, &session.CreatedAt,
+			&session.LastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to scan session")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch sessions"})
+		}
+		session.Device = deviceName(session.UserAgent)
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		h.Logger.WithError(err).Error("Failed to iterate sessions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch sessions"})
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession logs a single session out.
+func (h *SessionHandler) RevokeSession(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	sessionID, err := strconv.Atoi(c.Param("session_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid session ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, userID); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke session"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to revoke other users' sessions"})
+	}
+
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL AND expires_at > NOW()`
+	res, err := h.DB.ExecContext(c.Request().Context(), query, sessionID, userID)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to revoke session ID %d", sessionID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke session"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	currentUserID, _ := auth.UserID(c)
+	h.Logger.WithFields(logrus.Fields{"session_id": sessionID, "user_id": userID, "revoked_by": currentUserID}).Info("Session revoked")
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RevokeSessions logs a user out everywhere; with ?keep_current=true the
+// session making the request stays. Holders of users.manage use it to force
+// a logout. API keys are not affected.
+func (h *SessionHandler) RevokeSessions(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, userID); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke sessions"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to revoke other users' sessions"})
+	}
+
+	keep := 0
+	if c.QueryParam("keep_current") == "true" {
+		keep, _ = auth.SessionID(c)
+	}
+
+	revoked, err := revokeSessions(c.Request().Context(), h.DB, userID, keep)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to revoke sessions of user ID %d", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke sessions"})
+	}
+
+	currentUserID, _ := auth.UserID(c)
+	h.Logger.WithFields(logrus.Fields{"user_id": userID, "revoked": revoked, "revoked_by": currentUserID}).Info("Sessions revoked")
+	return c.JSON(http.StatusOK, map[string]int64{"revoked": revoked})
+}
+
+// Validate checks the session of an access token for auth.Middleware. Sessions
+// of deleted users are no longer valid.
+func (h *SessionHandler) Validate(ctx context.Context, sessionID, userID int, ip, userAgent string) error {
+	userAgent = truncateUserAgent(userAgent)
+
+	var stale bool
+	query := `SELECT s.last_seen_at < NOW() - make_interval(secs => $3) OR s.ip <> $4 OR s.user_agent <> $5
+	          FROM sessions s
+	          JOIN users u ON u.id = s.user_id
+	          WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > NOW() AND u.deleted_at IS NULL`
+	err := h.DB.QueryRowContext(ctx, query, sessionID, userID, sessionTouchInterval.Seconds(), ip, userAgent).Scan(&stale)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.ErrInvalidToken
+	}
+	if err != nil || !stale {
+		return err
+	}
+
+	query = `UPDATE sessions SET last_seen_at = NOW(), ip = $2, user_agent = $3 WHERE id = $1`
+	_, err = h.DB.ExecContext(ctx, query, sessionID, ip, userAgent)
+	return err
+}
+
+// startSession records a login lasting ttl and returns the session ID.
+// Expired sessions of the user are cleaned up along the way.
+func startSession(ctx context.Context, db *sql.DB, userID int, ip, userAgent string, ttl time.Duration) (int, error) {
+	if _, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id=$1 AND expires_at < NOW()`, userID); err != nil {
+		return 0, err
+	}
+
+	var sessionID int
+	query := `INSERT INTO sessions (user_id, ip, user_agent, expires_at)
+	          VALUES ($1, $2, $3, NOW() + make_interval(secs => $4)) RETURNING id`
+	err := db.QueryRowContext(ctx, query, userID, ip, truncateUserAgent(userAgent), ttl.Seconds()).Scan(&sessionID)
+	return sessionID, err
+}
+
+// revokeSessions logs the user out of all sessions but keep, which may be 0,
+// and returns how many were revoked.
+func revokeSessions(ctx context.Context, db execer, userID, keep int) (int64, error) {
+	query := `UPDATE sessions SET revoked_at = NOW()
+	          WHERE user_id=$1 AND id <> $2 AND revoked_at IS NULL AND expires_at > NOW()`
+	res, err := db.ExecContext(ctx, query, userID, keep)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > maxUserAgentLength {
+		return strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	return userAgent
+}
+
+// deviceName describes a user agent as "<browser> on <system>", e.g.
+// "Firefox on Linux", good enough for users to recognise their devices.
+func deviceName(userAgent string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := ""
+	for _, s := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"GoArticles/config"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sitemapMaxURLs is the limit on entries per sitemap file set by the
+// sitemaps.org protocol.
+const sitemapMaxURLs = 50000
+
+type SitemapHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+	Config *config.Config
+}
+
+func NewSitemapHandler(db *sql.DB, logger *logrus.Logger, cfg *config.Config) *SitemapHandler {
+	return &SitemapHandler{
+		DB:     db,
+		Logger: logger,
+		Config: cfg,
+	}
+}
+
+// sitemapSection is a kind of page listed in the sitemap. query must select
+// (id, lastmod) pairs; link turns an id into the page path.
+type sitemapSection struct {
+	name  string
+	query string
+	link  string
+}
+
+var sitemapSections = []sitemapSection{
+	{
+		name: "articles",
+		query: `SELECT a.id, a.updated_at AS lastmod
+			FROM articles a
+			WHERE a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL`,
+		link: "/articles/%d",
+	},
+	{
+		name: "categories",
+		query: `SELECT ac.category_id AS id, MAX(a.updated_at) AS lastmod
+			FROM article_categories ac
+			JOIN articles a ON a.id = ac.article_id
+			WHERE a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+			GROUP BY ac.category_id`,
+		link: "/categories/%d/articles",
+	},
+	{
+		name: "tags",
+		query: `SELECT tg.tag_id AS id, MAX(a.updated_at) AS lastmod
+			FROM article_tags tg
+			JOIN articles a ON a.id = tg.article_id
+			WHERE a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+			GROUP BY tg.tag_id`,
+		link: "/tags/%d/articles",
+	},
+	{
+		name: "authors",
+		query: `SELECT a.author_id AS id, MAX(a.updated_at) AS lastmod
+			FROM articles a
+			WHERE a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+			GROUP BY a.author_id`,
+		link: "/users/%d",
+	},
+}
+
+func findSitemapSection(name string) (sitemapSection, bool) {
+	for _, section := range sitemapSections {
+		if section.name == name {
+			return section, true
+		}
+	}
+	return sitemapSection{}, false
+}
+
+// GetSitemapIndex lists one sitemap per section chunk of sitemapMaxURLs
+// entries, e.g. /sitemaps/articles-1.xml, /sitemaps/articles-2.xml.
+func (h *SitemapHandler) GetSitemapIndex(c echo.Context) error {
+	ctx := c.Request().Context()
+	h.Logger.Info("Building sitemap index")
+
+	w := h.startXML(c)
+	fmt.Fprint(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+"\n")
+
+	for _, section := range sitemapSections {
+		if err := h.writeSectionChunks(ctx, w, section); err != nil {
+			// Headers are already sent, so the best we can do is stop the
+			// document short and leave a trace in the logs.
+			h.Logger.WithError(err).Errorf("Failed to list sitemap chunks for %s", section.name)
+			return w.Flush()
+		}
+	}
+
+	fmt.Fprint(w, "</sitemapindex>\n")
+	return w.Flush()
+}
+
+func (h *SitemapHandler) writeSectionChunks(ctx context.Context, w *bufio.Writer, section sitemapSection) error {
+	query := fmt.Sprintf(`SELECT chunk, MAX(lastmod)
+		FROM (SELECT (ROW_NUMBER() OVER (ORDER BY s.id) - 1) / $1 AS chunk, s.lastmod FROM (%s) s) numbered
+		GROUP BY chunk
+		ORDER BY chunk`, section.query)
+
+	rows, err := h.DB.QueryContext(ctx, query, sitemapMaxURLs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			chunk   int
+			lastmod time.Time
+		)
+		if err = rows.Scan(&chunk, &lastmod); err != nil {
+			return err
+		}
+
+		fmt.Fprint(w, "  <sitemap>\n    <loc>")
+		xml.EscapeText(w, []byte(fmt.Sprintf("%s/sitemaps/%s-%d.xml", h.Config.BaseURL, section.name, chunk+1)))
+		fmt.Fprintf(w, "</loc>\n    <lastmod>%s</lastmod>\n  </sitemap>\n", lastmod.UTC().Format(time.RFC3339))
+	}
+
+	return rows.Err()
+}
+
+// GetSitemap streams a single chunk straight from the database cursor so
+// large sections never have to be held in memory.
+func (h *SitemapHandler) GetSitemap(c echo.Context) error {
+	section, page, err := parseSitemapName(c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Sitemap not found"})
+	}
+
+	ctx := c.Request().Context()
+	h.Logger.Infof("Streaming sitemap %s page %d", section.name, page)
+
+	query := fmt.Sprintf(`SELECT s.id, s.lastmod FROM (%s) s ORDER BY s.id OFFSET $1 LIMIT $2`, section.query)
+	rows, err := h.DB.QueryContext(ctx, query, (page-1)*sitemapMaxURLs, sitemapMaxURLs)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to query sitemap %s page %d", section.name, page)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build sitemap"})
+	}
+	defer rows.Close()
+
+	// Pull the first row before committing to a 200 so that pages past the
+	// end of the section are reported as missing.
+	hasRow := rows.Next()
+	if !hasRow {
+		if err = rows.Err(); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to read sitemap %s page %d", section.name, page)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build sitemap"})
+		}
+		if page > 1 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Sitemap not found"})
+		}
+	}
+
+	w := h.startXML(c)
+	fmt.Fprint(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+"\n")
+
+	count := 0
+	for ; hasRow; hasRow = rows.Next() {
+		var (
+			id      int
+			lastmod time.Time
+		)
+		if err = rows.Scan(&id, &lastmod); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan sitemap %s entry", section.name)
+			return w.Flush()
+		}
+
+		fmt.Fprint(w, "  <url>\n    <loc>")
+		xml.EscapeText(w, []byte(h.Config.BaseURL+fmt.Sprintf(section.link, id)))
+		fmt.Fprintf(w, "</loc>\n    <lastmod>%s</lastmod>\n  </url>\n", lastmod.UTC().Format(time.RFC3339))
+		count++
+	}
+
+	if err = rows.Err(); err != nil {
+		h.Logger.WithError(err).Errorf("Error occurred during rows iteration for sitemap %s", section.name)
+		return w.Flush()
+	}
+
+	fmt.Fprint(w, "</urlset>\n")
+	h.Logger.Infof("Streamed %d URLs for sitemap %s page %d", count, section.name, page)
+	return w.Flush()
+}
+
+func (h *SitemapHandler) startXML(c echo.Context) *bufio.Writer {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationXMLCharsetUTF8)
+	res.WriteHeader(http.StatusOK)
+
+	w := bufio.NewWriter(res)
+	fmt.Fprint(w, xml.Header)
+	return w
+}
+
+// parseSitemapName splits names such as "articles-2.xml" into the section
+// and the 1-based page number.
+func parseSitemapName(name string) (sitemapSection, int, error) {
+	base := strings.TrimSuffix(name, ".xml")
+	dash := strings.LastIndex(base, "-")
+	if base == name || dash < 0 {
+		return sitemapSection{}, 0, fmt.Errorf("invalid sitemap name %q", name)
+	}
+
+	section, ok := findSitemapSection(base[:dash])
+	if !ok {
+		return sitemapSection{}, 0, fmt.Errorf("unknown sitemap section %q", base[:dash])
+	}
+
+	page, err := strconv.Atoi(base[dash+1:])
+	if err != nil || page < 1 {
+		return sitemapSection{}, 0, fmt.Errorf("invalid sitemap page in %q", name)
+	}
+	return section, page, nil
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"GoArticles/oidc"
+	"GoArticles/trash"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ssoLoginTTL is how long a user has to log in at the provider.
+const ssoLoginTTL = 10 * time.Minute
+
+// ssoStateCookie binds a login to the browser that started it, so that a
+// callback URL from somebody else's login cannot be used to sign a victim
+// into the attacker's account.
+const ssoStateCookie = "sso_state"
+
+var (
+	errSSOUnverifiedEmail = errors.New("an account with this email exists but the address is not verified")
+	errSSONoEmail         = errors.New("the identity provider did not share an email address")
+
+	usernameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// LoginSSO starts a single sign-on login by redirecting to the OpenID Connect
+// provider. A cookie holding a hash of the state ties the callback to this
+// browser.
+func (h *AuthHandler) LoginSSO(c echo.Context) error {
+	if h.SSO == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Single sign-on is not configured"})
+	}
+
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to generate SSO login secrets")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start login"})
+	}
+
+	ctx := c.Request().Context()
+	redirect, err := h.SSO.AuthCodeURL(ctx, req)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to reach the identity provider")
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Identity provider is not available"})
+	}
+
+	if _, err = h.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expires_at < NOW()`); err != nil {
+		h.Logger.WithError(err).Warn("Failed to remove expired SSO logins")
+	}
+	query := `INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4)`
+	if _, err = h.DB.ExecContext(ctx, query, hashToken(req.State), req.Nonce, req.Verifier, time.Now().Add(ssoLoginTTL)); err != nil {
+		h.Logger.WithError(err).Error("Failed to store SSO login")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start login"})
+	}
+
+	c.SetCookie(h.stateCookie(hashToken(req.State), int(ssoLoginTTL.Seconds())))
+	return c.Redirect(http.StatusFound, redirect)
+}
+
+// SSOCallback finishes a single sign-on login. The identity is matched to a
+// user by a previous login, then by verified email; otherwise a new account
+// is created. Roles mapped from the user's groups are updated on every login.
+// The response is the same as from Login.
+func (h *AuthHandler) SSOCallback(c echo.Context) error {
+	if h.SSO == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Single sign-on is not configured"})
+	}
+	if errCode := c.QueryParam("error"); errCode != "" {
+		h.Logger.WithFields(logrus.Fields{"error": errCode, "description": c.QueryParam("error_description")}).Warn("SSO login failed at the provider")
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Login at the identity provider failed"})
+	}
+
+	state, code := c.QueryParam("state"), c.QueryParam("code")
+	if state == "" || code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "State and code are required"})
+	}
+	cookie, err := c.Cookie(ssoStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(hashToken(state))) != 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Login was started in another browser, start again"})
+	}
+	c.SetCookie(h.stateCookie("", -1))
+
+	ctx := c.Request().Context()
+	req := oidc.AuthRequest{State: state}
+	query := `DELETE FROM oidc_logins WHERE state_hash = $1 AND expires_at > NOW() RETURNING nonce, code_verifier`
+	err = h.DB.QueryRowContext(ctx, query, hashToken(state)).Scan(&req.Nonce, &req.Verifier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired login, start again"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to fetch SSO login")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	identity, err := h.SSO.Exchange(ctx, code, req)
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		h.Logger.WithError(err).Warn("Rejected ID token")
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid identity token"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to redeem authorization code")
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Login at the identity provider failed"})
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	userID, created, err := h.ssoUser(ctx, tx, identity)
+	if errors.Is(err, errSSOUnverifiedEmail) || errors.Is(err, errSSONoEmail) {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Cannot sign in: " + err.Error()})
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).WithField("subject", identity.Subject).Error("Failed to resolve SSO user")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	if err = syncSSORoles(ctx, tx, userID, identity.Groups, h.Config.OIDCRoleMapping); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to update roles of user ID %d from groups", userID)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log in"})
+	}
+
+	h.Logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"subject": identity.Subject,
+		"created": created,
+	}).Info("User signed in with SSO")
+	return h.completeLogin(c, userID)
+}
+
+// stateCookie sets the state cookie, or deletes it with a negative maxAge.
+// It is only sent to the SSO routes, and only over HTTPS when the site is.
+func (h *AuthHandler) stateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    value,
+		Path:     "/login/oidc",
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(h.Config.BaseURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ssoUser finds or creates the user for an identity and records the login.
+func (h *AuthHandler) ssoUser(ctx context.Context, tx *sql.Tx, identity *oidc.Identity) (int, bool, error) {
+	var userID int
+	query := `UPDATE user_identities i SET last_login_at = NOW(), email = $3
+	          FROM users u
+	          WHERE i.issuer = $1 AND i.subject = $2 AND u.id = i.user_id AND u.deleted_at IS NULL
+	          RETURNING i.user_id`
+	err := tx.QueryRowContext(ctx, query, identity.Issuer, identity.Subject, identity.Email).Scan(&userID)
+	if err == nil {
+		return userID, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	if identity.Email == "" {
+		return 0, false, errSSONoEmail
+	}
+
+	created := false
+	var verified bool
+	query = `SELECT id, email_verified_at IS NOT NULL FROM users WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL`
+	err = tx.QueryRowContext(ctx, query, identity.Email).Scan(&userID, &verified)
+	switch {
+	case err == nil:
+		// Only link when both sides have proven the address, otherwise
+		// whoever registered it first could take over the other account.
+		if !identity.EmailVerified || !verified {
+			return 0, false, errSSOUnverifiedEmail
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		if userID, err = createSSOUser(ctx, tx, identity); err != nil {
+			return 0, false, err
+		}
+		created = true
+	default:
+		return 0, false, err
+	}
+
+	query = `INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)
+	         ON CONFLICT (issuer, subject) DO UPDATE SET user_id = EXCLUDED.user_id, email = EXCLUDED.email, last_login_at = NOW()`
+	if _, err = tx.ExecContext(ctx, query, userID, identity.Issuer, identity.Subject, identity.Email); err != nil {
+		return 0, false, err
+	}
+	return userID, created, nil
+}
+
+// createSSOUser creates an account without a password for an identity. The
+// username is derived from the identity and made unique.
+func createSSOUser(ctx context.Context, tx *sql.Tx, identity *oidc.Identity) (int, error) {
+	base := ssoUsername(identity)
+	displayName := []rune(strings.TrimSpace(identity.Name))
+	if len(displayName) > maxDisplayNameLength {
+		displayName = displayName[:maxDisplayNameLength]
+	}
+	var verifiedAt *time.Time
+	if identity.EmailVerified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+
+	for i := 1; ; i++ {
+		username := base
+		if i > 1 {
+			suffix := fmt.Sprintf("-%d", i)
+			username = base[:min(len(base), 32-len(suffix))] + suffix
+		}
+		if strings.EqualFold(username, trash.GhostUsername) {
+			continue
+		}
+
+		var taken bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))`, username).Scan(&taken); err != nil {
+			return 0, err
+		}
+		if taken {
+			if i >= 100 {
+				return 0, fmt.Errorf("no free username for %q", base)
+			}
+			continue
+		}
+
+		var userID int
+		query := `INSERT INTO users (username, email, password_hash, display_name, email_verified_at, created_at, updated_at)
+		          VALUES ($1, $2, '!', $3, $4, NOW(), NOW()) RETURNING id`
+		if err := tx.QueryRowContext(ctx, query, username, identity.Email, string(displayName), verifiedAt).Scan(&userID); err != nil {
+			return 0, err
+		}
+
+		if err := assignDefaultRole(ctx, tx, userID); err != nil {
+			return 0, err
+		}
+
+		query = `INSERT INTO user_settings (user_id, setting_key, setting_value)
+		         SELECT $1, s.setting_key, s.default_value
+		         FROM settings s
+		         ON CONFLICT (user_id, setting_key) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return 0, err
+		}
+		return userID, nil
+	}
+}
+
+// ssoUsername picks a valid username from the preferred username or the
+// local part of the email.
+func ssoUsername(identity *oidc.Identity) string {
+	name := identity.PreferredUsername
+	if at := strings.IndexByte(name, '@'); at >= 0 {
+		name = name[:at]
+	}
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+	name = strings.Trim(usernameInvalidChars.ReplaceAllString(name, "-"), "-.")
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	for len(name) < 3 {
+		name += "_"
+	}
+	return name
+}
+
+// syncSSORoles gives the user the roles mapped from their groups and takes
+// away mapped roles whose groups they have left. Roles that no group maps to
+// are left alone, so roles assigned by hand stay.
+func syncSSORoles(ctx context.Context, tx *sql.Tx, userID int, groups []string, mapping map[string]string) error {
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	managed := make([]string, 0, len(mapping))
+	for _, role := range mapping {
+		managed = append(managed, role)
+	}
+	granted := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if role, ok := mapping[group]; ok {
+			granted = append(granted, role)
+		}
+	}
+
+	query := `DELETE FROM user_roles ur USING roles r
+	          WHERE ur.role_id = r.id AND ur.user_id = $1 AND ur.category_id IS NULL
+	            AND r.name = ANY($2) AND NOT r.name = ANY($3)`
+	if _, err := tx.ExecContext(ctx, query, userID, pq.Array(managed), pq.Array(granted)); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO user_roles (user_id, role_id)
+	         SELECT $1, r.id FROM roles r WHERE r.name = ANY($2)
+	         ON CONFLICT (user_id, role_id, COALESCE(category_id, 0)) DO NOTHING`
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(granted))
+	return err
+}
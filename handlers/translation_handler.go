@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"GoArticles/config"
+	"GoArticles/i18n"
+	"GoArticles/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// nameTranslations describes a table of localized names.
+type nameTranslations struct {
+	kind     string
+	table    string
+	column   string
+	notFound string
+}
+
+var (
+	categoryNames = nameTranslations{kind: "category", table: "category_translations", column: "category_id", notFound: "Category not found"}
+	tagNames      = nameTranslations{kind: "tag", table: "tag_translations", column: "tag_id", notFound: "Tag not found"}
+)
+
+type TranslationHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+	Config *config.Config
+}
+
+func NewTranslationHandler(db *sql.DB, logger *logrus.Logger, cfg *config.Config) *TranslationHandler {
+	return &TranslationHandler{
+		DB:     db,
+		Logger: logger,
+		Config: cfg,
+	}
+}
+
+func (h *TranslationHandler) GetCategoryNames(c echo.Context) error {
+	return h.getNames(c, categoryNames, c.Param("category_id"))
+}
+
+func (h *TranslationHandler) SetCategoryName(c echo.Context) error {
+	return h.setName(c, categoryNames, c.Param("category_id"))
+}
+
+func (h *TranslationHandler) DeleteCategoryName(c echo.Context) error {
+	return h.deleteName(c, categoryNames, c.Param("category_id"))
+}
+
+func (h *TranslationHandler) GetTagNames(c echo.Context) error {
+	return h.getNames(c, tagNames, c.Param("tag_id"))
+}
+
+func (h *TranslationHandler) SetTagName(c echo.Context) error {
+	return h.setName(c, tagNames, c.Param("tag_id"))
+}
+
+func (h *TranslationHandler) DeleteTagName(c echo.Context) error {
+	return h.deleteName(c, tagNames, c.Param("tag_id"))
+}
+
+func (h *TranslationHandler) getNames(c echo.Context, names nameTranslations, param string) error {
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid %s ID", names.kind)})
+	}
+
+	translations, err := h.listNames(c.Request().Context(), names, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch translations"})
+	}
+
+	return c.JSON(http.StatusOK, translations)
+}
+
+func (h *TranslationHandler) setName(c echo.Context, names nameTranslations, param string) error {
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid %s ID", names.kind)})
+	}
+
+	lang := i18n.Normalize(c.Param("lang"))
+	if !i18n.Contains(h.Config.Languages, lang) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported language"})
+	}
+
+	var input models.LocalizedName
+	if err = c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Name is required"})
+	}
+
+	h.Logger.Infof("Setting %s name of %s ID %d", lang, names.kind, id)
+
+	query := fmt.Sprintf(`INSERT INTO %s (%s, language, name) VALUES ($1, $2, $3)
+	                      ON CONFLICT (%s, language) DO UPDATE SET name = EXCLUDED.name`, names.table, names.column, names.column)
+	if _, err = h.DB.ExecContext(c.Request().Context(), query, id, lang, input.Name); err != nil {
+		if isForeignKeyViolation(err) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": names.notFound})
+		}
+		h.Logger.WithError(err).Errorf("Failed to set %s name of %s ID %d", lang, names.kind, id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save translation"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *TranslationHandler) deleteName(c echo.Context, names nameTranslations, param string) error {
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid %s ID", names.kind)})
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE %s = $1 AND language = $2`, names.table, names.column)
+	result, err := h.DB.ExecContext(c.Request().Context(), query, id, i18n.Normalize(c.Param("lang")))
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to delete name of %s ID %d", names.kind, id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete translation"})
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Translation not found"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *TranslationHandler) listNames(ctx context.Context, names nameTranslations, id int) ([]models.LocalizedName, error) {
+	query := fmt.Sprintf(`SELECT language, name FROM %s WHERE %s = $1 ORDER BY language`, names.table, names.column)
+	rows, err := h.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch names of %s ID %d", names.kind, id)
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []models.LocalizedName{}
+	for rows.Next() {
+		var name models.LocalizedName
+		if err = rows.Scan(&name.Language, &name.Name); err != nil {
+			return nil, err
+		}
+		translations = append(translations, name)
+	}
+	return translations, rows.Err()
+}
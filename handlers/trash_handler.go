@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/models"
+	"GoArticles/recommend"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+var trashTypes = map[string]bool{"article": true, "comment": true, "user": true}
+
+type TrashHandler struct {
+	DB      *sql.DB
+	Logger  *logrus.Logger
+	Config  *config.Config
+	Related *recommend.Cache
+}
+
+func NewTrashHandler(db *sql.DB, logger *logrus.Logger, cfg *config.Config, related *recommend.Cache) *TrashHandler {
+	return &TrashHandler{
+		DB:      db,
+		Logger:  logger,
+		Config:  cfg,
+		Related: related,
+	}
+}
+
+// GetTrash lists the current user's deleted articles and comments, newest
+// first. With ?all=true holders of trash.manage see everybody's items,
+// including deleted users. ?type= narrows the list to one kind of item.
+func (h *TrashHandler) GetTrash(c echo.Context) error {
+	userID, _ := auth.UserID(c)
+	ctx := c.Request().Context()
+
+	itemType := c.QueryParam("type")
+	if itemType != "" && !trashTypes[itemType] {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid type, expected article, comment or user"})
+	}
+
+	all, _ := strconv.ParseBool(c.QueryParam("all"))
+	if all {
+		allowed, err := hasPermission(ctx, h.DB, userID, "trash.manage")
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to check permissions")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch trash"})
+		}
+		if !allowed {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to view the whole trash"})
+		}
+	}
+
+	limit, offset := parsePagination(c)
+	items, err := h.getTrash(ctx, userID, all, itemType, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch trash"})
+	}
+
+	return c.JSON(http.StatusOK, items)
+}
+
+func (h *TrashHandler) RestoreArticle(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid article ID"})
+	}
+
+	var (
+		authorID      int
+		authorDeleted bool
+	)
+	query := `SELECT a.author_id, u.deleted_at IS NOT NULL
+	          FROM articles a JOIN users u ON u.id = a.author_id
+	          WHERE a.id=$1 AND a.deleted_at IS NOT NULL`
+	err = h.DB.QueryRowContext(c.Request().Context(), query, id).Scan(&authorID, &authorDeleted)
+	if !h.checkRestore(c, err, authorID, "Article not found in the trash") {
+		return nil
+	}
+	if authorDeleted {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The author's account is deleted; restore the user instead"})
+	}
+
+	query = `UPDATE articles SET deleted_at = NULL WHERE id=$1`
+	if _, err = h.DB.ExecContext(c.Request().Context(), query, id); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to restore article ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restore article"})
+	}
+	h.Related.Flush()
+
+	h.Logger.Infof("Restored article ID %d from the trash", id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *TrashHandler) RestoreComment(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid comment ID"})
+	}
+
+	var authorID int
+	query := `SELECT user_id FROM comments WHERE id=$1 AND deleted_at IS NOT NULL`
+	err = h.DB.QueryRowContext(c.Request().Context(), query, id).Scan(&authorID)
+	if !h.checkRestore(c, err, authorID, "Comment not found in the trash") {
+		return nil
+	}
+
+	query = `UPDATE comments SET deleted_at = NULL WHERE id=$1`
+	if _, err = h.DB.ExecContext(c.Request().Context(), query, id); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to restore comment ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restore comment"})
+	}
+
+	h.Logger.Infof("Restored comment ID %d from the trash", id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RestoreUser brings back a deleted account along with the articles and
+// comments that were trashed with it. Content the user had deleted before
+// stays in the trash.
+func (h *TrashHandler) RestoreUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NOT NULL)`
+	err = h.DB.QueryRowContext(c.Request().Context(), query, id).Scan(&exists)
+	if err == nil && !exists {
+		err = sql.ErrNoRows
+	}
+	// Deleted users cannot log in, so only trash.manage can restore them.
+	if !h.checkRestore(c, err, 0, "User not found in the trash") {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restore user"})
+	}
+
+	queries := []string{
+		`UPDATE articles SET deleted_at = NULL
+		 WHERE author_id=$1 AND deleted_at = (SELECT deleted_at FROM users WHERE id=$1)`,
+		`UPDATE comments SET deleted_at = NULL
+		 WHERE user_id=$1 AND deleted_at = (SELECT deleted_at FROM users WHERE id=$1)`,
+		`UPDATE users SET deleted_at = NULL WHERE id=$1`,
+	}
+	for _, query := range queries {
+		if _, err = tx.ExecContext(ctx, query, id); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Errorf("Failed to restore user ID %d", id)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restore user"})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restore user"})
+	}
+
+	h.Logger.Infof("Restored user ID %d from the trash", id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkRestore reports whether the current user may restore a trashed item,
+// otherwise turning the result of looking it up into an error response. The
+// owner can restore it, as can holders of trash.manage.
+func (h *TrashHandler) checkRestore(c echo.Context, lookupErr error, ownerID int, notFound string) bool {
+	if errors.Is(lookupErr, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, map[string]string{"error": notFound})
+		return false
+	}
+	if lookupErr != nil {
+		h.Logger.WithError(lookupErr).Error("Failed to look up trashed item")
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restore"})
+		return false
+	}
+
+	userID, _ := auth.UserID(c)
+	if ownerID != 0 && ownerID == userID {
+		return true
+	}
+
+	allowed, err := hasPermission(c.Request().Context(), h.DB, userID, "trash.manage")
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restore"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to restore this item"})
+		return false
+	}
+	return true
+}
+
+func (h *TrashHandler) getTrash(ctx context.Context, userID int, all bool, itemType string, limit, offset int) ([]models.TrashItem, error) {
+	h.Logger.Infof("Fetching trash for user ID %d", userID)
+
+	query := `SELECT type, id, owner_id, title, deleted_at FROM (
+	              SELECT 'article' AS type, id, author_id AS owner_id, title, deleted_at
+	              FROM articles WHERE deleted_at IS NOT NULL AND ($1 OR author_id = $2)
+	              UNION ALL
+	              SELECT 'comment', id, user_id, LEFT(content, 100), deleted_at
+	              FROM comments WHERE deleted_at IS NOT NULL AND ($1 OR user_id = $2)
+	              UNION ALL
+	              SELECT 'user', id, id, username, deleted_at
+	              FROM users WHERE deleted_at IS NOT NULL AND $1
+	          ) trash
+	          WHERE $3 = '' OR type = $3
+	          ORDER BY deleted_at DESC, type, id
+	          LIMIT $4 OFFSET $5`
+	rows, err := h.DB.QueryContext(ctx, query, all, userID, itemType, limit, offset)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch trash for user ID %d", userID)
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.TrashItem{}
+	for rows.Next() {
+		var item models.TrashItem
+		if err = rows.Scan(&item.Type, &item.ID, &item.OwnerID, &item.Title, &item.DeletedAt); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan trash item for user ID %d", userID)
+			return nil, err
+		}
+		item.PurgeAt = item.DeletedAt.Add(h.Config.TrashRetention)
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
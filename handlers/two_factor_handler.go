@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"GoArticles/auth"
+	"GoArticles/config"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorHandler struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+	Config *config.Config
+}
+
+func NewTwoFactorHandler(db *sql.DB, logger *logrus.Logger, cfg *config.Config) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		DB:     db,
+		Logger: logger,
+		Config: cfg,
+	}
+}
+
+// secondFactor is a TOTP code or, if the authenticator is lost, one of the
+// recovery codes.
+type secondFactor struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Enroll creates a new TOTP secret for the current user. It takes effect once
+// a code generated from it is confirmed.
+func (h *TwoFactorHandler) Enroll(c echo.Context) error {
+	id, ok := h.selfOnly(c)
+	if !ok {
+		return nil
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to generate TOTP secret")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to enroll"})
+	}
+
+	var username string
+	query := `UPDATE users SET totp_secret = $1 WHERE id=$2 AND deleted_at IS NULL AND totp_enabled_at IS NULL
+	          RETURNING username`
+	err = h.DB.QueryRowContext(c.Request().Context(), query, secret, id).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Two-factor authentication is already enabled"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to store TOTP secret of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to enroll"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(h.Config.SiteTitle, username, secret),
+	})
+}
+
+// Confirm enables two-factor authentication with a code from the newly
+// enrolled authenticator and returns the recovery codes. They are shown only
+// this once.
+func (h *TwoFactorHandler) Confirm(c echo.Context) error {
+	id, ok := h.selfOnly(c)
+	if !ok {
+		return nil
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&input); err != nil || input.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Code is required"})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	var secret sql.NullString
+	query := `SELECT totp_secret FROM users WHERE id=$1 AND totp_enabled_at IS NULL FOR UPDATE`
+	if err = tx.QueryRowContext(ctx, query, id).Scan(&secret); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to fetch TOTP secret of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to confirm"})
+	}
+	if !secret.Valid {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, map[string]string{"error": "No pending enrollment"})
+	}
+
+	step, ok := auth.ValidateTOTP(secret.String, input.Code, time.Now())
+	if !ok {
+		tx.Rollback()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid code"})
+	}
+
+	query = `UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $1 WHERE id=$2`
+	if _, err = tx.ExecContext(ctx, query, step, id); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to enable 2FA for user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to confirm"})
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, id)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to create recovery codes for user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to confirm"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithField("user_id", id).Info("Two-factor authentication enabled")
+	return c.JSON(http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// Disable turns two-factor authentication off. The user re-authenticates
+// with the password and a second factor. Members of roles requiring 2FA
+// cannot turn it off.
+func (h *TwoFactorHandler) Disable(c echo.Context) error {
+	id, ok := h.selfOnly(c)
+	if !ok {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	var required bool
+	query := effectiveRolesCTE + `
+	          SELECT EXISTS (SELECT 1 FROM user_role_tree WHERE require_2fa)`
+	if err := h.DB.QueryRowContext(ctx, query, id).Scan(&required); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to check 2FA requirement of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to disable two-factor authentication"})
+	}
+	if required {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "One of your roles requires two-factor authentication"})
+	}
+
+	return h.reauthenticate(c, id, func(tx *sql.Tx) (interface{}, error) {
+		query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id=$1`
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id=$1`, id); err != nil {
+			return nil, err
+		}
+		h.Logger.WithField("user_id", id).Info("Two-factor authentication disabled")
+		return nil, nil
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after re-authentication.
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c echo.Context) error {
+	id, ok := h.selfOnly(c)
+	if !ok {
+		return nil
+	}
+
+	return h.reauthenticate(c, id, func(tx *sql.Tx) (interface{}, error) {
+		codes, err := replaceRecoveryCodes(c.Request().Context(), tx, id)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]string{"recovery_codes": codes}, nil
+	})
+}
+
+// reauthenticate checks the password and second factor in the request body
+// and runs fn in the same transaction. fn's result is the response body, or
+// 204 No Content if it is nil.
+func (h *TwoFactorHandler) reauthenticate(c echo.Context, id int, fn func(*sql.Tx) (interface{}, error)) error {
+	var input struct {
+		Password string `json:"password"`
+		secondFactor
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+
+	ctx := c.Request().Context()
+	var passwordHash string
+	query := `SELECT password_hash FROM users WHERE id=$1 AND totp_enabled_at IS NOT NULL`
+	err := h.DB.QueryRowContext(ctx, query, id).Scan(&passwordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Two-factor authentication is not enabled"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to re-authenticate"})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(input.Password)) != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Invalid password or code"})
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	ok, err := verifySecondFactor(ctx, tx, id, input.secondFactor)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to verify second factor of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to re-authenticate"})
+	}
+	if !ok {
+		tx.Rollback()
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Invalid password or code"})
+	}
+
+	result, err := fn(tx)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to update 2FA of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update two-factor authentication"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	if result == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// selfOnly returns the user ID from the path. If it is invalid or not the
+// current user it writes a 400/403 response and reports false.
+func (h *TwoFactorHandler) selfOnly(c echo.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+		return 0, false
+	}
+	if currentUserID, _ := auth.UserID(c); currentUserID != id {
+		c.JSON(http.StatusForbidden, map[string]string{"error": "Users can only manage their own two-factor authentication"})
+		return 0, false
+	}
+	return id, true
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code. TOTP
+// codes are accepted once: the matched time step has to be newer than the
+// last one used.
+func verifySecondFactor(ctx context.Context, tx *sql.Tx, userID int, factor secondFactor) (bool, error) {
+	if factor.Code != "" {
+		var (
+			secret   sql.NullString
+			lastStep int64
+		)
+		query := `SELECT totp_secret, totp_last_step FROM users WHERE id=$1 AND totp_enabled_at IS NOT NULL FOR UPDATE`
+		err := tx.QueryRowContext(ctx, query, userID).Scan(&secret, &lastStep)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		step, ok := auth.ValidateTOTP(secret.String, strings.TrimSpace(factor.Code), time.Now())
+		if !ok || step <= lastStep {
+			return false, nil
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE users SET totp_last_step = $1 WHERE id=$2`, step, userID)
+		return err == nil, err
+	}
+
+	if factor.RecoveryCode != "" {
+		query := `UPDATE user_recovery_codes SET used_at = NOW()
+		          WHERE id = (SELECT id FROM user_recovery_codes
+		                      WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL LIMIT 1)`
+		result, err := tx.ExecContext(ctx, query, userID, hashToken(normalizeRecoveryCode(factor.RecoveryCode)))
+		if err != nil {
+			return false, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		return rowsAffected > 0, err
+	}
+
+	return false, nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and returns a fresh
+// set. Only their hashes are stored.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+
+		query := `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, query, userID, hashToken(code)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed with or without the dash and in
+// any case.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"GoArticles/audit"
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/mailer"
 	"GoArticles/models"
+	"GoArticles/trash"
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -15,12 +22,16 @@ import (
 type UserHandler struct {
 	DB     *sql.DB
 	Logger *logrus.Logger
+	Config *config.Config
+	Mailer mailer.Mailer
 }
 
-func NewUserHandler(db *sql.DB, logger *logrus.Logger) *UserHandler {
+func NewUserHandler(db *sql.DB, logger *logrus.Logger, cfg *config.Config, mail mailer.Mailer) *UserHandler {
 	return &UserHandler{
 		Logger: logger,
 		DB:     db,
+		Config: cfg,
+		Mailer: mail,
 	}
 }
 
@@ -40,12 +51,20 @@ func (h *UserHandler) CreateUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Empty inputs"})
 	}
 
+	if message := validateProfile(&user.Username, &user.Email, nil, nil, nil); message != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": message})
+	}
+	if len(user.Password) < minPasswordLength {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+	}
+
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		h.Logger.WithError(err).Error("Failed to hash password")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
 	}
 	user.PasswordHash = string(passwordHash)
+	user.Password = ""
 
 	tx, err := h.DB.BeginTx(c.Request().Context(), nil)
 	if err != nil {
@@ -53,19 +72,36 @@ func (h *UserHandler) CreateUser(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
 	}
 
-	query := `INSERT INTO users (username, email, password_hash, created_at, updated_at) 
+	var usernameTaken, emailTaken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER($1)),
+	                 EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($2))`
+	if err = tx.QueryRowContext(c.Request().Context(), query, user.Username, user.Email).Scan(&usernameTaken, &emailTaken); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to check username and email")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create user"})
+	}
+	if usernameTaken {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Username is already taken"})
+	}
+	if emailTaken {
+		tx.Rollback()
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Email is already in use"})
+	}
+
+	query = `INSERT INTO users (username, email, password_hash, created_at, updated_at) 
               VALUES ($1, $2, $3, NOW(), NOW()) RETURNING id`
 	err = tx.QueryRowContext(c.Request().Context(), query, user.Username, user.Email, user.PasswordHash).Scan(&user.ID)
 	if err != nil {
 		tx.Rollback() // Откат транзакции при ошибке
+		if isUniqueViolation(err) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Username or email is already taken"})
+		}
 		h.Logger.WithError(err).Error("Failed to create user")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create user"})
 	}
 
-	defaultRoleID := 3
-	query = `INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2)`
-	_, err = tx.ExecContext(c.Request().Context(), query, user.ID, defaultRoleID)
-	if err != nil {
+	if err = assignDefaultRole(c.Request().Context(), tx, user.ID); err != nil {
 		tx.Rollback() // Откат транзакции при ошибке
 		h.Logger.WithError(err).Error("Failed to assign default role")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to assign default role"})
@@ -83,17 +119,38 @@ func (h *UserHandler) CreateUser(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set default user settings"})
 	}
 
+	user.Links = []string{}
+	audit.Target(c, user.ID)
+	audit.After(c, user)
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to audit user creation")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create user"})
+	}
+
 	if err = tx.Commit(); err != nil {
 		h.Logger.WithError(err).Error("Failed to commit transaction")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
 	}
 
+	// New accounts can write drafts right away but need a verified email
+	// address to publish.
+	if err = sendEmailVerification(c.Request().Context(), h.DB, h.Config, h.Mailer, user.ID, user.Email); err != nil {
+		h.Logger.WithError(err).Errorf("Failed to send verification email to user ID %d", user.ID)
+	}
+
 	h.Logger.WithFields(logrus.Fields{
 		"user_id": user.ID,
 	}).Info("User created successfully")
 	return c.JSON(http.StatusCreated, user)
 }
 
+// DeleteUser moves the account to the trash together with the user's
+// articles and comments. Users can delete themselves; deleting someone else
+// takes the users.manage permission.
+//
+// With ?permanent=true the account is purged right away instead, see
+// purgeUser.
 func (h *UserHandler) DeleteUser(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -101,49 +158,187 @@ func (h *UserHandler) DeleteUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
 	}
 
-	if err = h.deleteUserDependencies(c.Request().Context(), id); err != nil {
-		h.Logger.WithError(err).Error("Failed to delete user dependencies")
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user dependencies"})
+	ctx := c.Request().Context()
+	if currentUserID, _ := auth.UserID(c); currentUserID != id {
+		allowed, err := hasPermission(ctx, h.DB, currentUserID, "users.manage")
+		if err != nil {
+			h.Logger.WithError(err).Error("Failed to check permissions")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
+		}
+		if !allowed {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to delete other users"})
+		}
+	}
+
+	if permanent, _ := strconv.ParseBool(c.QueryParam("permanent")); permanent {
+		return h.purgeUser(c, id)
 	}
 
-	query := `DELETE FROM users WHERE id=$1`
-	result, err := h.DB.ExecContext(c.Request().Context(), query, id)
+	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+	audit.Before(c, storedUser(ctx, tx, h.Config, id))
+
+	query := `UPDATE users SET deleted_at = NOW() WHERE id=$1 AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		tx.Rollback()
 		h.Logger.WithError(err).Error("Failed to delete user")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
+		tx.Rollback()
 		h.Logger.WithError(err).Error("Failed to check affected rows")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check affected rows"})
 	}
 
 	if rowsAffected == 0 {
+		tx.Rollback()
 		h.Logger.Warn("User not found")
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
 	}
 
-	h.Logger.WithField("user_id", id).Info("User deleted successfully")
+	// NOW() is fixed for the transaction, so restoring the user can tell the
+	// content trashed along with the account from content deleted earlier.
+	queries := []string{
+		`UPDATE articles SET deleted_at = NOW() WHERE author_id=$1 AND deleted_at IS NULL`,
+		`UPDATE comments SET deleted_at = NOW() WHERE user_id=$1 AND deleted_at IS NULL`,
+	}
+	for _, query := range queries {
+		if _, err = tx.ExecContext(ctx, query, id); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Error("Failed to delete user content")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user content"})
+		}
+	}
+
+	// The account's logins and API keys end with it and stay revoked if it
+	// is restored.
+	_, err = revokeSessions(ctx, tx, id, 0)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `UPDATE api_keys SET revoked_at = NOW() WHERE user_id=$1 AND revoked_at IS NULL`, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to revoke credentials of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
+	}
+
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to audit user deletion")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	h.Logger.WithField("user_id", id).Info("User moved to the trash")
 	return c.NoContent(http.StatusNoContent)
 }
 
-func (h *UserHandler) deleteUserDependencies(ctx context.Context, userID int) error {
-	queries := []string{
-		`DELETE FROM article_likes WHERE user_id=$1`,
-		`DELETE FROM article_tags WHERE article_id IN (SELECT id FROM articles WHERE author_id=$1)`,
-		`DELETE FROM article_categories WHERE article_id IN (SELECT id FROM articles WHERE author_id=$1)`,
-		`DELETE FROM articles WHERE author_id=$1`,
+// purgeUser deletes the account and every row depending on it in a single
+// transaction. ?content= decides what happens to the user's articles, series
+// and comments: delete (default) removes them, reassign hands articles and
+// series to the user given by ?reassign_to=, ghost moves everything to the
+// anonymous ghost account. Reassigning makes somebody else the author, so it
+// needs users.manage also when users delete themselves. ?dry_run=true rolls
+// the transaction back and only reports what would be affected.
+func (h *UserHandler) purgeUser(c echo.Context, id int) error {
+	ctx := c.Request().Context()
+	opts := trash.UserPurge{Content: c.QueryParam("content"), MediaDir: h.Config.MediaDir}
+	if opts.Content == "" {
+		opts.Content = trash.ContentDelete
 	}
+	if opts.Content == trash.ContentReassign {
+		var err error
+		if opts.ReassignTo, err = strconv.Atoi(c.QueryParam("reassign_to")); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid reassign_to user ID"})
+		}
+		if opts.ReassignTo == id {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Cannot reassign content to the user being deleted"})
+		}
 
-	for _, query := range queries {
-		_, err := h.DB.ExecContext(ctx, query, userID)
+		currentUserID, _ := auth.UserID(c)
+		allowed, err := hasPermission(ctx, h.DB, currentUserID, "users.manage")
 		if err != nil {
-			return err
+			h.Logger.WithError(err).Error("Failed to check permissions")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
+		}
+		if !allowed {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Reassigning content to another user requires users.manage, use delete or ghost instead"})
+		}
+	}
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)`
+	if err = tx.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to look up user")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
+	}
+	if !exists {
+		tx.Rollback()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	report, files, err := trash.PurgeUser(ctx, tx, id, opts)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, trash.ErrInvalidContent):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid content option, expected delete, reassign or ghost"})
+		case errors.Is(err, trash.ErrInvalidTarget):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Cannot reassign content to this user"})
+		case errors.Is(err, trash.ErrGhostUser):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "The ghost account can only be deleted with its content"})
 		}
+		h.Logger.WithError(err).Errorf("Failed to purge user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
 	}
 
-	return nil
+	deletion := models.UserDeletion{
+		UserID:   id,
+		Content:  opts.Content,
+		DryRun:   dryRun,
+		Affected: report,
+	}
+	audit.After(c, deletion)
+
+	logger := h.Logger.WithFields(logrus.Fields{"user_id": id, "content": opts.Content})
+	if dryRun {
+		tx.Rollback()
+		logger.Info("Dry run of permanent user deletion")
+	} else {
+		if err = audit.Record(c, tx); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Error("Failed to audit user deletion")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
+		}
+		if err = tx.Commit(); err != nil {
+			h.Logger.WithError(err).Error("Failed to commit transaction")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+		}
+		if err = trash.RemoveFiles(files); err != nil {
+			logger.WithError(err).Error("Failed to remove data export archives")
+		}
+		logger.Info("User deleted permanently")
+	}
+
+	return c.JSON(http.StatusOK, deletion)
 }
 
 func (h *UserHandler) GetUserByID(c echo.Context) error {
@@ -161,6 +356,15 @@ func (h *UserHandler) GetUserByID(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
 	}
 
+	if allowed, err := canManageUser(c, h.DB, id); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch user"})
+	} else if !allowed {
+		user.Email = ""
+		user.EmailVerifiedAt = nil
+		user.PendingEmail = nil
+	}
+
 	h.Logger.WithField("user_id", id).Info("User found successfully")
 	return c.JSON(http.StatusOK, user)
 }
@@ -185,11 +389,14 @@ func (h *UserHandler) GetUserRoles(c echo.Context) error {
 func (h *UserHandler) getUserByID(ctx context.Context, id int) (*models.User, error) {
 	h.Logger.Infof("Fetching user with ID %d", id)
 
-	query := `SELECT id, username, email, created_at, updated_at FROM users WHERE id=$1`
+	query := `SELECT ` + userColumns + `,
+	                 (SELECT COUNT(*) FROM follows f WHERE f.target_type = 'author' AND f.target_id = u.id),
+	                 (SELECT COUNT(*) FROM follows f WHERE f.follower_id = u.id)
+	          FROM users u WHERE u.id=$1 AND u.deleted_at IS NULL`
 	row := h.DB.QueryRowContext(ctx, query, id)
 
 	var user models.User
-	if err := row.Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := scanUser(row, h.Config, &user, &user.FollowersCount, &user.FollowingCount); err != nil {
 		h.Logger.WithError(err).Errorf("Failed to fetch user with ID %d", id)
 		return nil, err
 	}
@@ -201,7 +408,7 @@ func (h *UserHandler) getUserByID(ctx context.Context, id int) (*models.User, er
 func (h *UserHandler) getUserRoles(ctx context.Context, userID int) ([]models.UserRole, error) {
 	h.Logger.Infof("Fetching roles for user with ID %d", userID)
 
-	query := `SELECT user_id, role_id FROM user_roles WHERE user_id=$1`
+	query := `SELECT user_id, role_id, category_id FROM user_roles WHERE user_id=$1`
 	rows, err := h.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		h.Logger.WithError(err).Errorf("Failed to fetch roles for user with ID %d", userID)
@@ -212,7 +419,7 @@ func (h *UserHandler) getUserRoles(ctx context.Context, userID int) ([]models.Us
 	var roles []models.UserRole
 	for rows.Next() {
 		var role models.UserRole
-		if err = rows.Scan(&role.UserID, &role.RoleID); err != nil {
+		if err = rows.Scan(&role.UserID, &role.RoleID, &role.CategoryID); err != nil {
 			h.Logger.WithError(err).Errorf("Error scanning role for user with ID %d", userID)
 			return nil, err
 		}
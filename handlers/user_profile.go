@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"GoArticles/audit"
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/models"
+	"GoArticles/trash"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 1000
+	maxProfileLinks      = 5
+	maxAvatarSize        = 2 << 20
+
+	// recentArticlesLimit is how many articles an author's profile lists.
+	recentArticlesLimit = 5
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// avatarTypes are the accepted avatar formats with the extension they are
+// stored under.
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// userColumns is the column list read by scanUser, for queries that alias
+// the users table as "u".
+const userColumns = `u.id, u.username, u.email, u.created_at, u.updated_at, u.display_name, u.bio, u.avatar_path, u.links,
+	u.email_verified_at, u.pending_email`
+
+// scanUser reads userColumns into user, followed by any extra columns.
+func scanUser(row rowScanner, cfg *config.Config, user *models.User, extra ...interface{}) error {
+	var avatarPath sql.NullString
+	dest := []interface{}{&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt,
+		&user.DisplayName, &user.Bio, &avatarPath, pq.Array(&user.Links), &user.EmailVerifiedAt, &user.PendingEmail}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if avatarPath.Valid {
+		user.AvatarURL = mediaURL(cfg, avatarPath.String)
+	}
+	if user.Links == nil {
+		user.Links = []string{}
+	}
+	return nil
+}
+
+// storedUser reads the account for audit snapshots, or returns nil if it
+// cannot be read.
+func storedUser(ctx context.Context, db queryRower, cfg *config.Config, id int) *models.User {
+	var user models.User
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.id=$1`
+	if err := scanUser(db.QueryRowContext(ctx, query, id), cfg, &user); err != nil {
+		return nil
+	}
+	return &user
+}
+
+// mediaURL is the public address of a file in the media directory.
+func mediaURL(cfg *config.Config, file string) string {
+	return cfg.BaseURL + "/media/" + file
+}
+
+// canManageUser reports whether the current user may change the account:
+// users manage themselves, holders of users.manage everybody. API keys need
+// the users.write scope to manage their owner's account.
+func canManageUser(c echo.Context, db queryRower, userID int) (bool, error) {
+	ctx := c.Request().Context()
+	currentUserID, _ := auth.UserID(c)
+	if currentUserID == userID && auth.ScopeAllows(ctx, auth.ScopeUsersWrite) {
+		return true, nil
+	}
+	return hasPermission(ctx, db, currentUserID, "users.manage")
+}
+
+// UpdateUser changes the given profile fields; fields left out of the body
+// keep their value. A new email address becomes pending_email and a link to
+// confirm it is sent there; the current address stays in use, also for
+// password resets, until the link is opened.
+func (h *UserHandler) UpdateUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, id); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to update other users"})
+	}
+
+	var input struct {
+		Username    *string   `json:"username"`
+		Email       *string   `json:"email"`
+		DisplayName *string   `json:"display_name"`
+		Bio         *string   `json:"bio"`
+		Links       *[]string `json:"links"`
+	}
+	if err = c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+	if message := validateProfile(input.Username, input.Email, input.DisplayName, input.Bio, input.Links); message != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": message})
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to begin transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
+	}
+	audit.Before(c, storedUser(ctx, tx, h.Config, id))
+
+	if input.Username != nil || input.Email != nil {
+		var usernameTaken, emailTaken bool
+		query := `SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER($1) AND id <> $3),
+		                 EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($2) AND id <> $3)`
+		if err = tx.QueryRowContext(ctx, query, input.Username, input.Email, id).Scan(&usernameTaken, &emailTaken); err != nil {
+			tx.Rollback()
+			h.Logger.WithError(err).Error("Failed to check username and email")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+		}
+		if usernameTaken {
+			tx.Rollback()
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Username is already taken"})
+		}
+		if emailTaken {
+			tx.Rollback()
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Email is already in use"})
+		}
+	}
+
+	var links interface{}
+	if input.Links != nil {
+		links = pq.Array(*input.Links)
+	}
+	// Sending the current address again drops a pending change.
+	var pendingEmail sql.NullString
+	query := `UPDATE users SET username = COALESCE($1, username),
+	                 pending_email = CASE WHEN $2::TEXT IS NULL THEN pending_email
+	                                      WHEN LOWER($2::TEXT) = LOWER(email) THEN NULL
+	                                      ELSE $2::TEXT END,
+	                 display_name = COALESCE($3, display_name), bio = COALESCE($4, bio),
+	                 links = COALESCE($5::TEXT[], links), updated_at = NOW()
+	          WHERE id=$6 AND deleted_at IS NULL
+	          RETURNING pending_email`
+	err = tx.QueryRowContext(ctx, query, input.Username, input.Email, input.DisplayName, input.Bio, links, id).Scan(&pendingEmail)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		if isUniqueViolation(err) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Username or email is already taken"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to update user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	}
+
+	audit.After(c, storedUser(ctx, tx, h.Config, id))
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Errorf("Failed to audit update of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.Logger.WithError(err).Error("Failed to commit transaction")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
+	}
+
+	if input.Email != nil && pendingEmail.Valid {
+		if err = sendEmailVerification(ctx, h.DB, h.Config, h.Mailer, id, pendingEmail.String); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to send verification email to user ID %d", id)
+		}
+	}
+
+	user, err := h.getUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch user"})
+	}
+
+	h.Logger.WithField("user_id", id).Info("User profile updated")
+	return c.JSON(http.StatusOK, user)
+}
+
+// validateProfile checks the given profile fields and returns a message
+// describing the first invalid one, or "" if all are valid. Strings are
+// trimmed in place.
+func validateProfile(username, email, displayName, bio *string, links *[]string) string {
+	for _, field := range []*string{username, email, displayName, bio} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+
+	if username != nil {
+		if !usernamePattern.MatchString(*username) {
+			return "Username must be 3 to 32 letters, digits, dots, dashes or underscores"
+		}
+		if strings.EqualFold(*username, trash.GhostUsername) {
+			return "Username is reserved"
+		}
+	}
+	if email != nil {
+		if address, err := mail.ParseAddress(*email); err != nil || address.Address != *email {
+			return "Invalid email"
+		}
+	}
+	if displayName != nil && utf8.RuneCountInString(*displayName) > maxDisplayNameLength {
+		return "Display name is too long"
+	}
+	if bio != nil && utf8.RuneCountInString(*bio) > maxBioLength {
+		return "Bio is too long"
+	}
+	if links != nil {
+		if len(*links) > maxProfileLinks {
+			return "Too many links"
+		}
+		for _, link := range *links {
+			u, err := url.Parse(link)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return "Links must be http or https URLs"
+			}
+		}
+	}
+	return ""
+}
+
+// UploadAvatar stores the image sent in the "avatar" form field in the media
+// directory and makes it the user's avatar, replacing the previous one.
+func (h *UserHandler) UploadAvatar(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, id); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to upload avatar"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to update other users"})
+	}
+
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing avatar file"})
+	}
+	if header.Size > maxAvatarSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Avatar is too large"})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid avatar file"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid avatar file"})
+	}
+	if len(data) > maxAvatarSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Avatar is too large"})
+	}
+	ext, ok := avatarTypes[http.DetectContentType(data)]
+	if !ok {
+		return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": "Avatar must be a PNG, JPEG, GIF or WebP image"})
+	}
+
+	name, err := h.saveMedia("avatars", strconv.Itoa(id), ext, data)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to store avatar of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to upload avatar"})
+	}
+
+	previous, err := h.setAvatar(c.Request().Context(), id, &name)
+	if err != nil {
+		h.removeMedia(name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		h.Logger.WithError(err).Errorf("Failed to set avatar of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to upload avatar"})
+	}
+	if previous.Valid {
+		h.removeMedia(previous.String)
+	}
+
+	h.Logger.WithField("user_id", id).Info("Avatar uploaded")
+	return c.JSON(http.StatusOK, map[string]string{"avatar_url": mediaURL(h.Config, name)})
+}
+
+func (h *UserHandler) DeleteAvatar(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, id); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete avatar"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to update other users"})
+	}
+
+	previous, err := h.setAvatar(c.Request().Context(), id, nil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to delete avatar of user ID %d", id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete avatar"})
+	}
+	if previous.Valid {
+		h.removeMedia(previous.String)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// setAvatar points the user at a new avatar file, or none, and returns the
+// previous one so its file can be removed.
+func (h *UserHandler) setAvatar(ctx context.Context, userID int, avatarPath *string) (sql.NullString, error) {
+	var previous sql.NullString
+	query := `UPDATE users u SET avatar_path = $1, updated_at = NOW()
+	          FROM users old
+	          WHERE u.id = $2 AND old.id = u.id AND u.deleted_at IS NULL
+	          RETURNING old.avatar_path`
+	err := h.DB.QueryRowContext(ctx, query, avatarPath, userID).Scan(&previous)
+	return previous, err
+}
+
+// saveMedia writes data to a new file with a random name in dir under the
+// media directory and returns its path relative to the media directory.
+func (h *UserHandler) saveMedia(dir, prefix, ext string, data []byte) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	name := path.Join(dir, prefix+"-"+hex.EncodeToString(random)+ext)
+
+	full := filepath.Join(h.Config.MediaDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return "", err
+	}
+	return name, os.WriteFile(full, data, 0o644)
+}
+
+func (h *UserHandler) removeMedia(name string) {
+	full := filepath.Join(h.Config.MediaDir, filepath.Clean("/"+name))
+	if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+		h.Logger.WithError(err).Warnf("Failed to remove media file %q", name)
+	}
+}
+
+// GetAuthorProfile is the public profile of an author with the number of
+// published articles they wrote or contributed to and the most recent ones.
+func (h *UserHandler) GetAuthorProfile(c echo.Context) error {
+	username := c.Param("username")
+	ctx := c.Request().Context()
+
+	var profile models.AuthorProfile
+	query := `SELECT ` + userColumns + `,
+	                 (SELECT COUNT(*) FROM follows f WHERE f.target_type = 'author' AND f.target_id = u.id),
+	                 (SELECT COUNT(*) FROM follows f WHERE f.follower_id = u.id),
+	                 (SELECT COUNT(*) FROM articles a
+	                  WHERE ` + byAuthor + ` AND a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL)
+	          FROM users u WHERE LOWER(u.username) = LOWER($1) AND u.deleted_at IS NULL`
+	err := scanUser(h.DB.QueryRowContext(ctx, query, username), h.Config, &profile.User,
+		&profile.FollowersCount, &profile.FollowingCount, &profile.ArticlesCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Author not found"})
+	}
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch author %q", username)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch author"})
+	}
+	profile.Email = ""
+	profile.EmailVerifiedAt = nil
+	profile.PendingEmail = nil
+
+	profile.RecentArticles, err = h.getRecentArticles(ctx, profile.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch author"})
+	}
+
+	return c.JSON(http.StatusOK, profile)
+}
+
+// byAuthor matches the articles of the user u: those they wrote, including
+// ones handed over to them, and those they accepted to contribute to.
+const byAuthor = `(a.author_id = u.id OR EXISTS (
+	SELECT 1 FROM article_contributors ac WHERE ac.article_id = a.id AND ac.user_id = u.id AND ac.status = 'accepted'))`
+
+func (h *UserHandler) getRecentArticles(ctx context.Context, authorID int) ([]models.Article, error) {
+	query := `SELECT ` + articleColumns + ` FROM articles a, users u
+	          WHERE u.id = $1 AND ` + byAuthor + ` AND a.published_at IS NOT NULL AND a.published_at <= NOW() AND a.deleted_at IS NULL
+	          ORDER BY a.published_at DESC, a.id DESC
+	          LIMIT $2`
+	rows, err := h.DB.QueryContext(ctx, query, authorID, recentArticlesLimit)
+	if err != nil {
+		h.Logger.WithError(err).Errorf("Failed to fetch recent articles of user ID %d", authorID)
+		return nil, err
+	}
+	defer rows.Close()
+
+	articles := []models.Article{}
+	for rows.Next() {
+		var article models.Article
+		if err = scanArticle(rows, &article); err != nil {
+			h.Logger.WithError(err).Errorf("Failed to scan article of user ID %d", authorID)
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+	return articles, rows.Err()
+}
@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"GoArticles/audit"
 	"GoArticles/models"
+	"GoArticles/settings"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"sort"
 	"strconv"
 )
 
@@ -22,6 +28,8 @@ func NewUserSettingsHandler(db *sql.DB, logger *logrus.Logger) *UserSettingsHand
 	}
 }
 
+// GetUserSettings lists the user's settings with values typed per the
+// settings schema, to the user and holders of users.manage.
 func (h *UserSettingsHandler) GetUserSettings(c echo.Context) error {
 	userID, err := strconv.Atoi(c.Param("user_id"))
 	if err != nil {
@@ -29,6 +37,13 @@ func (h *UserSettingsHandler) GetUserSettings(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
 	}
 
+	if allowed, err := canManageUser(c, h.DB, userID); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch user settings"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to see other users' settings"})
+	}
+
 	settings, err := h.getUserSettings(c.Request().Context(), userID)
 	if err != nil {
 		h.Logger.WithFields(logrus.Fields{
@@ -41,65 +56,113 @@ func (h *UserSettingsHandler) GetUserSettings(c echo.Context) error {
 	return c.JSON(http.StatusOK, settings)
 }
 
+// GetSettingsSchema describes every setting: its type, allowed values,
+// default and description, for building settings forms.
+func (h *UserSettingsHandler) GetSettingsSchema(c echo.Context) error {
+	definitions, err := settingDefinitions(c.Request().Context(), h.DB)
+	if err != nil {
+		h.Logger.WithError(err).Error("Failed to fetch settings schema")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch settings schema"})
+	}
+
+	schema := make([]*models.SettingDefinition, 0, len(definitions))
+	for _, def := range definitions {
+		schema = append(schema, def)
+	}
+	sort.Slice(schema, func(i, j int) bool { return schema[i].Key < schema[j].Key })
+	return c.JSON(http.StatusOK, schema)
+}
+
+// UpdateUserSettings changes the settings given in the JSON object body.
+// Values are checked against the settings schema; if any is invalid nothing
+// is changed and the response lists an error message per key. Users change
+// their own settings, holders of users.manage anybody's.
 func (h *UserSettingsHandler) UpdateUserSettings(c echo.Context) error {
-	userID := c.Param("user_id")
-	if userID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "User ID is required"})
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if allowed, err := canManageUser(c, h.DB, userID); err != nil {
+		h.Logger.WithError(err).Error("Failed to check permissions")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user settings"})
+	} else if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Not allowed to change other users' settings"})
 	}
 
-	var inputSettings map[string]string
+	var inputSettings map[string]json.RawMessage
 	if err := c.Bind(&inputSettings); err != nil {
 		h.Logger.WithError(err).Warn("Failed to bind user settings data")
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
 	}
 
-	if _, ok := inputSettings["user_id"]; ok {
-		delete(inputSettings, "user_id")
-	}
-
-	query := `SELECT setting_key FROM settings`
-	rows, err := h.DB.QueryContext(c.Request().Context(), query)
+	ctx := c.Request().Context()
+	definitions, err := settingDefinitions(ctx, h.DB)
 	if err != nil {
-		h.Logger.WithError(err).Error("Failed to retrieve settings keys")
+		h.Logger.WithError(err).Error("Failed to retrieve settings schema")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve settings keys"})
 	}
-	defer rows.Close()
 
-	validKeys := make(map[string]bool)
-	for rows.Next() {
-		var key string
-		if err = rows.Scan(&key); err != nil {
-			h.Logger.WithError(err).Error("Failed to scan setting key")
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to process settings keys"})
+	values := make(map[string]string, len(inputSettings))
+	invalid := make(map[string]string)
+	for key, raw := range inputSettings {
+		def, ok := definitions[key]
+		if !ok {
+			invalid[key] = "unknown setting"
+			continue
 		}
-		validKeys[key] = true
+		value, err := settings.Parse(def, raw)
+		if errors.Is(err, settings.ErrInvalidDefinition) {
+			h.Logger.WithError(err).Error("Invalid setting definition")
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to validate settings"})
+		}
+		if err != nil {
+			invalid[key] = err.Error()
+			continue
+		}
+		values[key] = value
+	}
+	if len(invalid) > 0 {
+		h.Logger.WithField("settings", invalid).Warn("Invalid user settings")
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "Invalid settings", "settings": invalid})
 	}
 
-	tx, err := h.DB.BeginTx(c.Request().Context(), nil)
+	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
 		h.Logger.WithError(err).Error("Failed to begin transaction")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to begin transaction"})
 	}
 
-	for key, value := range inputSettings {
-		if !validKeys[key] {
-			h.Logger.Warnf("Invalid setting key: %s", key)
-			tx.Rollback() // Откат транзакции при ошибке
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid setting key: " + key})
-		}
+	previous, err := settingValues(ctx, tx, userID)
+	if err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to fetch user settings")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user settings"})
+	}
 
-		// Выполняем вставку или обновление настроек
-		query = `INSERT INTO user_settings (user_id, setting_key, setting_value)
-		         VALUES ($1, $2, $3)
-		         ON CONFLICT (user_id, setting_key) DO UPDATE
-		         SET setting_value = EXCLUDED.setting_value`
-		if _, err = tx.ExecContext(c.Request().Context(), query, userID, key, value); err != nil {
+	for key, value := range values {
+		query := `INSERT INTO user_settings (user_id, setting_key, setting_value)
+		          VALUES ($1, $2, $3)
+		          ON CONFLICT (user_id, setting_key) DO UPDATE
+		          SET setting_value = EXCLUDED.setting_value`
+		if _, err = tx.ExecContext(ctx, query, userID, key, value); err != nil {
+			tx.Rollback()
+			if isForeignKeyViolation(err) {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+			}
 			h.Logger.WithError(err).Error("Failed to update user setting")
-			tx.Rollback() // Откат транзакции при ошибке
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user settings"})
 		}
 	}
 
+	audit.Before(c, previous)
+	audit.After(c, values)
+	if err = audit.Record(c, tx); err != nil {
+		tx.Rollback()
+		h.Logger.WithError(err).Error("Failed to audit user settings update")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user settings"})
+	}
+
 	if err = tx.Commit(); err != nil {
 		h.Logger.WithError(err).Error("Failed to commit transaction")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to commit transaction"})
@@ -111,24 +174,89 @@ func (h *UserSettingsHandler) UpdateUserSettings(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// settingValues maps the user's setting keys to their stored values.
+func settingValues(ctx context.Context, tx *sql.Tx, userID int) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT setting_key, setting_value FROM user_settings WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, rows.Err()
+}
+
+// settingDefinitions reads the settings schema, keyed by setting. Defaults
+// that do not fit the setting's type are returned as stored.
+func settingDefinitions(ctx context.Context, db *sql.DB) (map[string]*models.SettingDefinition, error) {
+	query := `SELECT setting_key, value_type, description, default_value, allowed_values, pattern, min_value, max_value
+	          FROM settings`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	definitions := make(map[string]*models.SettingDefinition)
+	for rows.Next() {
+		var def models.SettingDefinition
+		err = rows.Scan(&def.Key, &def.Type, &def.Description, &def.DefaultValue, pq.Array(&def.AllowedValues),
+			&def.Pattern, &def.Min, &def.Max)
+		if err != nil {
+			return nil, err
+		}
+		if def.Default, err = settings.Decode(&def, def.DefaultValue); err != nil {
+			def.Default = def.DefaultValue
+		}
+		definitions[def.Key] = &def
+	}
+	return definitions, rows.Err()
+}
+
+// getUserSettings returns the user's stored settings, typed per the schema.
+// A stored value that does not fit its setting's type, e.g. one saved before
+// the type was declared, is replaced by the default.
 func (h *UserSettingsHandler) getUserSettings(ctx context.Context, userID int) ([]models.UserSettings, error) {
-	query := `SELECT user_id, setting_key, setting_value FROM user_settings WHERE user_id = $1`
+	definitions, err := settingDefinitions(ctx, h.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT user_id, setting_key, setting_value FROM user_settings WHERE user_id = $1 ORDER BY setting_key`
 	rows, err := h.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var settings []models.UserSettings
+	var userSettings []models.UserSettings
 	for rows.Next() {
-		var setting models.UserSettings
-		if err = rows.Scan(&setting.UserID, &setting.SettingKey, &setting.SettingValue); err != nil {
+		var (
+			setting models.UserSettings
+			stored  string
+		)
+		if err = rows.Scan(&setting.UserID, &setting.SettingKey, &stored); err != nil {
 			return nil, err
 		}
-		settings = append(settings, setting)
+
+		setting.SettingValue = stored
+		if def, ok := definitions[setting.SettingKey]; ok {
+			if setting.SettingValue, err = settings.Decode(def, stored); err != nil {
+				h.Logger.WithError(err).Warnf("Using the default for user ID %d", userID)
+				setting.SettingValue = def.Default
+			}
+		}
+		userSettings = append(userSettings, setting)
 	}
 
-	return settings, nil
+	return userSettings, rows.Err()
 }
 
 func (h *UserSettingsHandler) updateUserSettings(ctx context.Context, settings models.UserSettings) error {
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	tokenPasswordReset     = "password_reset"
+	tokenEmailVerification = "email_verification"
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// newSecretToken returns a random token for links sent to users. Only its
+// hash is stored.
+func newSecretToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueUserToken creates a single-use token for the user. Earlier unused
+// tokens with the same purpose stop working.
+func issueUserToken(ctx context.Context, tx *sql.Tx, userID int, purpose, email string, ttl time.Duration) (string, error) {
+	if err := revokeUserTokens(ctx, tx, userID, purpose); err != nil {
+		return "", err
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	if _, err = tx.ExecContext(ctx, query, userID, purpose, hashToken(token), email, time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken marks a valid token as used and returns its user and
+// email. Unknown, used and expired tokens yield errInvalidUserToken.
+func consumeUserToken(ctx context.Context, tx *sql.Tx, purpose, token string) (userID int, email string, err error) {
+	query := `UPDATE user_tokens SET used_at = NOW()
+	          WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+	          RETURNING user_id, email`
+	err = tx.QueryRowContext(ctx, query, hashToken(token), purpose).Scan(&userID, &email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", errInvalidUserToken
+	}
+	return userID, email, err
+}
+
+func revokeUserTokens(ctx context.Context, db execer, userID int, purpose string) error {
+	query := `UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	_, err := db.ExecContext(ctx, query, userID, purpose)
+	return err
+}
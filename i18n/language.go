@@ -0,0 +1,73 @@
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Normalize reduces a language tag to its lowercase primary subtag, so that
+// "en-US" and "EN" both become "en".
+func Normalize(tag string) string {
+	tag = strings.TrimSpace(tag)
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return strings.ToLower(tag)
+}
+
+// Contains reports whether lang is one of the given languages.
+func Contains(languages []string, lang string) bool {
+	for _, l := range languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+type preference struct {
+	lang string
+	q    float64
+}
+
+// Negotiate picks the language from available that the client prefers most
+// according to an Accept-Language header. Region subtags are ignored and a
+// "*" range matches the first available language. ok is false when the
+// header accepts none of them.
+func Negotiate(acceptLanguage string, available []string) (lang string, ok bool) {
+	var prefs []preference
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = Normalize(tag)
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		prefs = append(prefs, preference{lang: tag, q: q})
+	}
+
+	// Equal weights keep the order in which the client listed them.
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, pref := range prefs {
+		if pref.lang == "*" && len(available) > 0 {
+			return available[0], true
+		}
+		if Contains(available, pref.lang) {
+			return pref.lang, true
+		}
+	}
+	return "", false
+}
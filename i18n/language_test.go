@@ -0,0 +1,49 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	available := []string{"ru", "en", "de"}
+
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{header: "en", want: "en", ok: true},
+		{header: "en-US,en;q=0.9", want: "en", ok: true},
+		{header: "DE-at", want: "de", ok: true},
+		{header: "fr, de;q=0.5, en;q=0.8", want: "en", ok: true},
+		{header: "de;q=0.5, en;q=0.5", want: "de", ok: true},
+		{header: "en;q=0, ru;q=0.1", want: "ru", ok: true},
+		{header: "fr, *;q=0.1", want: "ru", ok: true},
+		{header: "en;q=abc, de;q=0.2", want: "de", ok: true},
+		{header: " ,en_GB", want: "en", ok: true},
+		{header: "fr, es", ok: false},
+		{header: "en;q=0", ok: false},
+		{header: "", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := Negotiate(tt.header, available)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Negotiate(%q) = %q, %t, want %q, %t", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if got, ok := Negotiate("*", nil); ok {
+		t.Errorf("Negotiate(\"*\") without languages = %q, want none", got)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for tag, want := range map[string]string{
+		"en-US": "en",
+		" EN ":  "en",
+		"pt_BR": "pt",
+		"":      "",
+	} {
+		if got := Normalize(tag); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"GoArticles/analytics"
+	"GoArticles/config"
 	"GoArticles/database"
+	"GoArticles/export"
 	"GoArticles/logging"
+	"GoArticles/publishing"
 	"GoArticles/routes"
+	"GoArticles/trash"
+	"context"
 	"database/sql"
+	"errors"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
+	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -44,8 +56,70 @@ func main() {
 
 	defer db.Close()
 
+	cfg := config.Load()
+	if cfg.AuthSecret == "" {
+		logging.Log.Fatal("AUTH_SECRET is not set")
+	}
+
+	if err := database.Migrate(db, cfg.Languages); err != nil {
+		logging.Log.WithError(err).Fatalf("Migration error: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Background workers flush their state once ctx is cancelled, so wait for
+	// them before closing the database.
+	var workers sync.WaitGroup
+	views := analytics.NewViewTracker(db, logging.Log, cfg.ViewDedupWindow, cfg.ViewFlushInterval)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		views.Run(ctx)
+	}()
+
+	announcer := publishing.NewAnnouncer(db, logging.Log, cfg.AnnounceInterval)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		announcer.Run(ctx)
+	}()
+
+	purger := trash.NewPurger(db, logging.Log, cfg.MediaDir, cfg.TrashRetention, cfg.TrashPurgeInterval)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		purger.Run(ctx)
+	}()
+
+	exporter := export.NewExporter(db, logging.Log, cfg.ExportDir, cfg.MediaDir, cfg.ExportTTL)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		exporter.Run(ctx)
+	}()
+
 	e := echo.New()
-	routes.InitRoutes(e, db)
+	ipExtractor, err := routes.IPExtractor(cfg)
+	if err != nil {
+		logging.Log.WithError(err).Fatal("Invalid TRUSTED_PROXIES")
+	}
+	e.IPExtractor = ipExtractor
+	routes.InitRoutes(e, db, cfg, views, exporter)
+
+	go func() {
+		if err := e.Start(":8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		e.Logger.Error(err)
+	}
 
-	e.Logger.Fatal(e.Start(":8080"))
+	workers.Wait()
 }
@@ -3,12 +3,22 @@ package models
 import "time"
 
 type Article struct {
-	ID        int       `json:"id"`
-	AuthorID  int       `json:"author"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          int        `json:"id"`
+	AuthorID    int        `json:"author"`
+	Title       string     `json:"title"`
+	Content     string     `json:"content"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	LikesCount  int        `json:"likes_count"`
+	ViewsCount  int64      `json:"views_count"`
+
+	Language         string `json:"language"`
+	Slug             string `json:"slug"`
+	TranslationGroup int    `json:"translation_group"`
+
+	Series       *SeriesNavigation    `json:"series,omitempty"`
+	Contributors []ArticleContributor `json:"contributors,omitempty"`
 }
 
 type ArticleCategory struct {
@@ -22,6 +32,23 @@ type ArticleTag struct {
 }
 
 type ArticleLike struct {
-	ArticleID int `json:"article_id"`
-	UserID    int `json:"user_id"`
+	ArticleID int       `json:"article_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArticleLiker struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	LikedAt  time.Time `json:"liked_at"`
+}
+
+type RankedArticle struct {
+	Article
+	Score float64 `json:"score"`
+}
+
+type LikedArticle struct {
+	Article
+	LikedAt time.Time `json:"liked_at"`
 }
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+type Bookmark struct {
+	UserID    int        `json:"user_id"`
+	ArticleID int        `json:"article_id"`
+	IsRead    bool       `json:"is_read"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	Article   *Article   `json:"article,omitempty"`
+}
+
+type BookmarkCollection struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	IsPublic    bool      `json:"is_public"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	Items []CollectionItem `json:"items,omitempty"`
+}
+
+type CollectionItem struct {
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Article  Article   `json:"article"`
+}
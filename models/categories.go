@@ -3,4 +3,6 @@ package models
 type Category struct {
 	ID   int    `json:"id"`
 	Name string `json:"name"`
+	// ParentID is the category this one is nested in.
+	ParentID *int `json:"parent_id,omitempty"`
 }
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+const (
+	ContributorRoleAuthor     = "author"
+	ContributorRoleCoAuthor   = "co-author"
+	ContributorRoleEditor     = "editor"
+	ContributorRoleTranslator = "translator"
+
+	ContributorStatusPending  = "pending"
+	ContributorStatusAccepted = "accepted"
+	ContributorStatusDeclined = "declined"
+)
+
+type ArticleContributor struct {
+	ArticleID int       `json:"article_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Contribution is an article as seen from one of its contributors.
+type Contribution struct {
+	Article
+	Role string `json:"role"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	ExportStatusPending = "pending"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+	ExportStatusExpired = "expired"
+)
+
+// UserExport is a request for an archive of a user's personal data.
+// DownloadURL carries the secret download token and is only filled in the
+// response to the request itself.
+type UserExport struct {
+	ID               int        `json:"id"`
+	UserID           int        `json:"user_id"`
+	RequestedBy      int        `json:"requested_by"`
+	Status           string     `json:"status"`
+	SizeBytes        *int64     `json:"size_bytes,omitempty"`
+	Error            *string    `json:"error,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	CompletedAt      *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	DownloadCount    int        `json:"download_count"`
+	LastDownloadedAt *time.Time `json:"last_downloaded_at,omitempty"`
+	DownloadURL      string     `json:"download_url,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Follower struct {
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type FollowedSource struct {
+	Type       string    `json:"type"`
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type FeedPage struct {
+	Articles   []Article `json:"articles"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+type Series struct {
+	ID          int       `json:"id"`
+	AuthorID    int       `json:"author_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	Parts []SeriesPart `json:"parts,omitempty"`
+}
+
+// SeriesPart is an entry of a series' table of contents.
+type SeriesPart struct {
+	Position    int        `json:"position"`
+	ArticleID   int        `json:"article_id"`
+	Title       string     `json:"title"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+type ArticleLink struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// SeriesNavigation places an article within its series.
+type SeriesNavigation struct {
+	ID       int          `json:"id"`
+	Title    string       `json:"title"`
+	Position int          `json:"position"`
+	Total    int          `json:"total"`
+	Previous *ArticleLink `json:"previous,omitempty"`
+	Next     *ArticleLink `json:"next,omitempty"`
+}
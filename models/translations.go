@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Translation is one language version of an article.
+type Translation struct {
+	ArticleID   int        `json:"article_id"`
+	Language    string     `json:"language"`
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+// LocalizedName is the name of a category or tag in one language.
+type LocalizedName struct {
+	Language string `json:"language"`
+	Name     string `json:"name"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// TrashItem is a deleted article, comment or user that can still be restored
+// until PurgeAt.
+type TrashItem struct {
+	Type      string    `json:"type"`
+	ID        int       `json:"id"`
+	OwnerID   int       `json:"owner_id"`
+	Title     string    `json:"title"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
@@ -4,38 +4,113 @@ import (
 	"time"
 )
 
+// User is an account. Email is only shown to the user themselves and to
+// holders of users.manage.
 type User struct {
 	ID           int       `json:"id"`
 	Username     string    `json:"username"`
-	Email        string    `json:"email"`
+	Email        string    `json:"email,omitempty"`
 	Password     string    `json:"password,omitempty"`
 	PasswordHash string    `json:"-"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// PendingEmail is a new address waiting to be confirmed; Email stays
+	// in use until then.
+	PendingEmail *string `json:"pending_email,omitempty"`
+
+	DisplayName string   `json:"display_name"`
+	Bio         string   `json:"bio"`
+	AvatarURL   string   `json:"avatar_url,omitempty"`
+	Links       []string `json:"links"`
+
+	FollowersCount int `json:"followers_count"`
+	FollowingCount int `json:"following_count"`
+}
+
+// AuthorProfile is the public page of a user.
+type AuthorProfile struct {
+	User
+	ArticlesCount  int       `json:"articles_count"`
+	RecentArticles []Article `json:"recent_articles"`
 }
 
 type UserRole struct {
 	UserID int `json:"user_id"`
 	RoleID int `json:"role_id"`
+	// CategoryID limits the role to articles in the category and the
+	// categories below it.
+	CategoryID *int `json:"category_id,omitempty"`
 }
 
+// UserSettings is a user's value of a setting. SettingValue is a bool,
+// int64, string or json.RawMessage depending on the setting's type, or the
+// stored text where no type applies.
 type UserSettings struct {
-	UserID       int    `json:"user_id"`
-	SettingKey   string `json:"setting_key"`
-	SettingValue string `json:"setting_value"`
+	UserID       int         `json:"user_id"`
+	SettingKey   string      `json:"setting_key"`
+	SettingValue interface{} `json:"setting_value"`
+}
+
+// SettingDefinition describes a setting for validation and for building
+// forms. Default is typed like the values; DefaultValue is its stored text.
+type SettingDefinition struct {
+	Key          string      `json:"key"`
+	Type         string      `json:"type"`
+	Description  string      `json:"description"`
+	Default      interface{} `json:"default"`
+	DefaultValue string      `json:"-"`
+	// AllowedValues lists the values of an enum setting.
+	AllowedValues []string `json:"allowed_values,omitempty"`
+	// Pattern is a regular expression a string value has to match in full.
+	Pattern string `json:"pattern,omitempty"`
+	// Min and Max bound the values of an int setting.
+	Min *int64 `json:"min,omitempty"`
+	Max *int64 `json:"max,omitempty"`
 }
 
 type Role struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// ParentID is the role this one inherits permissions from.
+	ParentID *int `json:"parent_id,omitempty"`
+	// IsSystem marks built-in roles, which cannot be renamed or deleted.
+	IsSystem bool `json:"is_system"`
+	// Require2FA makes the role's permissions depend on two-factor
+	// authentication being enabled.
+	Require2FA bool `json:"require_2fa"`
+	// IsDefault marks the role new accounts get.
+	IsDefault bool `json:"is_default"`
 }
 
 type Permission struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	IsSystem    bool   `json:"is_system"`
+}
+
+// EffectivePermission is a permission a user holds, directly or through
+// role inheritance, with the names of the roles granting it. CategoryIDs is
+// set when the permission is only held for articles in these categories.
+type EffectivePermission struct {
+	Permission
+	GrantedBy   []string `json:"granted_by"`
+	CategoryIDs []int    `json:"category_ids,omitempty"`
 }
 
 type RolePermission struct {
 	RoleID       int `json:"role_id"`
 	PermissionID int `json:"permission_id"`
 }
+
+// UserDeletion reports the rows a permanent account deletion removed or
+// changed per table, or would have with DryRun set.
+type UserDeletion struct {
+	UserID   int              `json:"user_id"`
+	Content  string           `json:"content"`
+	DryRun   bool             `json:"dry_run"`
+	Affected map[string]int64 `json:"affected"`
+}
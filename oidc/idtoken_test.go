@@ -0,0 +1,280 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID = "articles"
+	testNonce    = "nonce-1"
+)
+
+// mockProvider serves discovery and a key set that tests can change to
+// simulate key rotation.
+type mockProvider struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]crypto.Signer
+	keysFetches int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	m := &mockProvider{keys: make(map[string]crypto.Signer)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.keysFetches++
+		set := struct {
+			Keys []jsonWebKey `json:"keys"`
+		}{}
+		for kid, key := range m.keys {
+			set.Keys = append(set.Keys, publicJWK(kid, key.Public()))
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) addKey(kid string, key crypto.Signer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[kid] = key
+}
+
+func (m *mockProvider) fetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keysFetches
+}
+
+func (m *mockProvider) client() *Provider {
+	return New(Config{Issuer: m.server.URL, ClientID: testClientID, GroupsClaim: "groups"})
+}
+
+// claims returns valid claims for the provider, to be changed by each test.
+func (m *mockProvider) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    m.server.URL,
+		"sub":    "user-1",
+		"aud":    testClientID,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"nonce":  testNonce,
+		"email":  "user@example.com",
+		"groups": []string{"editors"},
+	}
+}
+
+func publicJWK(kid string, key crypto.PublicKey) jsonWebKey {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{KeyType: "RSA", KeyID: kid, Use: "sig", N: enc(k.N.Bytes()), E: enc(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return jsonWebKey{KeyType: "EC", KeyID: kid, Use: "sig", Curve: "P-256", X: enc(k.X.FillBytes(make([]byte, 32))), Y: enc(k.Y.FillBytes(make([]byte, 32)))}
+	}
+	panic("unsupported key")
+}
+
+// sign builds a compact JWS over the claims with alg in the header.
+func sign(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func generateKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsaKey, ecKey
+}
+
+func TestVerify(t *testing.T) {
+	m := newMockProvider(t)
+	rsaKey, ecKey := generateKeys(t)
+	m.addKey("rsa", rsaKey)
+	m.addKey("ec", ecKey)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		key    crypto.Signer
+		alg    string
+		kid    string
+		change func(claims map[string]interface{})
+		token  func(token string) string
+		nonce  string
+		valid  bool
+	}{
+		{name: "RS256", key: rsaKey, alg: "RS256", kid: "rsa", valid: true},
+		{name: "ES256", key: ecKey, alg: "ES256", kid: "ec", valid: true},
+		{name: "audience list with azp", key: rsaKey, alg: "RS256", kid: "rsa", valid: true,
+			change: func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"}; c["azp"] = testClientID }},
+		{name: "expired within clock skew", key: rsaKey, alg: "RS256", kid: "rsa", valid: true,
+			change: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-clockSkew / 2).Unix() }},
+
+		{name: "signed by another key", key: otherKey, alg: "RS256", kid: "rsa"},
+		{name: "payload changed after signing", key: rsaKey, alg: "RS256", kid: "rsa",
+			token: func(token string) string {
+				payload, _ := json.Marshal(map[string]interface{}{"iss": m.server.URL, "sub": "admin", "aud": testClientID,
+					"exp": time.Now().Add(time.Hour).Unix(), "nonce": testNonce})
+				parts := strings.Split(token, ".")
+				return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+			}},
+		{name: "ES256 header on an RSA key", key: rsaKey, alg: "ES256", kid: "rsa"},
+		{name: "RS256 header on an EC key", key: ecKey, alg: "RS256", kid: "ec"},
+		{name: "alg none", key: rsaKey, alg: "none", kid: "rsa"},
+		{name: "wrong issuer", key: rsaKey, alg: "RS256", kid: "rsa",
+			change: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", key: rsaKey, alg: "RS256", kid: "rsa",
+			change: func(c map[string]interface{}) { c["aud"] = "other" }},
+		{name: "audience list without azp", key: rsaKey, alg: "RS256", kid: "rsa",
+			change: func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} }},
+		{name: "wrong azp", key: rsaKey, alg: "RS256", kid: "rsa",
+			change: func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"}; c["azp"] = "other" }},
+		{name: "wrong nonce", key: rsaKey, alg: "RS256", kid: "rsa", nonce: "nonce-2"},
+		{name: "missing subject", key: rsaKey, alg: "RS256", kid: "rsa",
+			change: func(c map[string]interface{}) { delete(c, "sub") }},
+		{name: "expired", key: rsaKey, alg: "RS256", kid: "rsa",
+			change: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * clockSkew).Unix() }},
+		{name: "malformed", key: rsaKey, alg: "RS256", kid: "rsa",
+			token: func(token string) string { return token + ".extra" }},
+	}
+
+	p := m.client()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.claims()
+			if tt.change != nil {
+				tt.change(claims)
+			}
+			token := sign(t, tt.key, tt.alg, tt.kid, claims)
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+			nonce := testNonce
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			identity, err := p.verify(context.Background(), token, nonce)
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidIDToken) {
+					t.Fatalf("verify() error = %v, want ErrInvalidIDToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify() error = %v", err)
+			}
+			if identity.Subject != "user-1" || identity.Issuer != m.server.URL || identity.Email != "user@example.com" {
+				t.Errorf("verify() identity = %+v", identity)
+			}
+			if len(identity.Groups) != 1 || identity.Groups[0] != "editors" {
+				t.Errorf("verify() groups = %v, want [editors]", identity.Groups)
+			}
+		})
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	oldKey, newKey := generateKeys(t)
+	m.addKey("old", oldKey)
+	p := m.client()
+	ctx := context.Background()
+
+	if _, err := p.verify(ctx, sign(t, oldKey, "RS256", "old", m.claims()), testNonce); err != nil {
+		t.Fatalf("verify() with the current key: %v", err)
+	}
+	if got := m.fetches(); got != 1 {
+		t.Fatalf("key set fetched %d times, want 1", got)
+	}
+
+	// A key the provider does not publish is not looked up again within the
+	// refresh interval.
+	unknown := sign(t, newKey, "ES256", "new", m.claims())
+	if _, err := p.verify(ctx, unknown, testNonce); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("verify() with an unknown key: error = %v, want ErrInvalidIDToken", err)
+	}
+	if got := m.fetches(); got != 1 {
+		t.Fatalf("key set fetched %d times within the refresh interval, want 1", got)
+	}
+
+	// After rotation and once the interval has passed, the new key is
+	// fetched and accepted.
+	m.addKey("new", newKey)
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-keysRefreshInterval)
+	p.mu.Unlock()
+	if _, err := p.verify(ctx, unknown, testNonce); err != nil {
+		t.Fatalf("verify() with the rotated key: %v", err)
+	}
+	if got := m.fetches(); got != 2 {
+		t.Fatalf("key set fetched %d times, want 2", got)
+	}
+
+	// A key ID that is still unknown after the refresh is rejected.
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-keysRefreshInterval)
+	p.mu.Unlock()
+	if _, err := p.verify(ctx, sign(t, newKey, "ES256", "missing", m.claims()), testNonce); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("verify() with a key missing after refresh: error = %v, want ErrInvalidIDToken", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Package publishing tells followers about newly published articles, both
+// those published right away and those scheduled for later.
+package publishing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// announce marks the unannounced articles matching the condition as announced
+// and notifies everyone following their authors, in one statement so that no
+// article is announced twice.
+const announce = `WITH announced AS (
+	UPDATE articles SET followers_notified_at = NOW()
+	WHERE followers_notified_at IS NULL AND %s
+	RETURNING id, author_id, title
+)
+INSERT INTO notifications (user_id, message, is_read, created_at)
+SELECT f.follower_id, u.username || ' published "' || a.title || '"', FALSE, NOW()
+FROM announced a
+JOIN users u ON u.id = a.author_id
+JOIN follows f ON f.target_type = 'author' AND f.target_id = a.author_id`
+
+var (
+	announceArticle = fmt.Sprintf(announce, `id = $1`)
+	announceDue     = fmt.Sprintf(announce, `published_at <= NOW() AND deleted_at IS NULL`)
+)
+
+// NotifyFollowers tells everyone following the article's author that it has
+// been published, unless they were told before. It runs inside the caller's
+// transaction so the notifications only appear if the publication itself is
+// committed.
+func NotifyFollowers(ctx context.Context, tx *sql.Tx, articleID int) error {
+	_, err := tx.ExecContext(ctx, announceArticle, articleID)
+	return err
+}
+
+// Announcer notifies followers about scheduled articles once their
+// publication time has passed.
+type Announcer struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+
+	interval time.Duration
+}
+
+func NewAnnouncer(db *sql.DB, logger *logrus.Logger, interval time.Duration) *Announcer {
+	return &Announcer{
+		DB:       db,
+		Logger:   logger,
+		interval: interval,
+	}
+}
+
+// Run announces due articles every interval until ctx is cancelled.
+func (a *Announcer) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	a.announceDue(ctx)
+
+	for {
+		select {
+		case <-ticker.C:
+			a.announceDue(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *Announcer) announceDue(ctx context.Context) {
+	result, err := a.DB.ExecContext(ctx, announceDue)
+	if err != nil {
+		a.Logger.WithError(err).Error("Failed to announce scheduled articles")
+		return
+	}
+	if sent, _ := result.RowsAffected(); sent > 0 {
+		a.Logger.Infof("Sent %d notifications about scheduled articles", sent)
+	}
+}
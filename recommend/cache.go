@@ -0,0 +1,91 @@
+package recommend
+
+import (
+	"GoArticles/models"
+	"sync"
+	"time"
+)
+
+type cacheKey struct {
+	articleID     int
+	excludeAuthor bool
+}
+
+type cacheEntry struct {
+	articles []models.RankedArticle
+	expires  time.Time
+}
+
+// Cache keeps computed related-article lists. Entries expire after the TTL
+// and are dropped early by Invalidate, when an article can only drop out of
+// lists, or by Flush, when it can enter lists it is not in yet.
+//
+// The cache is per process and only sees changes made through its own
+// handlers. Lists may therefore lag behind changes made by other instances,
+// scheduled articles going live and similar for up to the TTL.
+type Cache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[cacheKey]cacheEntry
+}
+
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		entries: make(map[cacheKey]cacheEntry),
+	}
+}
+
+func (c *Cache) Get(articleID int, excludeAuthor bool) ([]models.RankedArticle, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[cacheKey{articleID, excludeAuthor}]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.articles, true
+}
+
+func (c *Cache) Set(articleID int, excludeAuthor bool, articles []models.RankedArticle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[cacheKey{articleID, excludeAuthor}] = cacheEntry{
+		articles: articles,
+		expires:  time.Now().Add(c.ttl),
+	}
+}
+
+// Invalidate forgets everything that depends on the article: its own related
+// lists and every list in which it appears.
+func (c *Cache) Invalidate(articleID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if key.articleID == articleID || now.After(entry.expires) || contains(entry.articles, articleID) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Flush forgets every list, e.g. after an article is published or gains a
+// tag or category, which can make it related to any other article.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[cacheKey]cacheEntry)
+}
+
+func contains(articles []models.RankedArticle, articleID int) bool {
+	for _, article := range articles {
+		if article.ID == articleID {
+			return true
+		}
+	}
+	return false
+}
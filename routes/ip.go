@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"GoArticles/config"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net"
+)
+
+// IPExtractor decides where c.RealIP() comes from, which login throttling,
+// sessions and the audit log rely on. X-Forwarded-For is only read when
+// the request comes from one of cfg.TrustedProxies, taking the last address
+// not added by a trusted proxy; otherwise clients could claim any address.
+func IPExtractor(cfg *config.Config) (echo.IPExtractor, error) {
+	if len(cfg.TrustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range cfg.TrustedProxies {
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q: %v", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
@@ -0,0 +1,183 @@
+package routes
+
+import (
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/database/dbtest"
+	"GoArticles/handlers"
+	"database/sql/driver"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPExtractor(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    []string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "direct",
+			remoteAddr: "192.0.2.1:4000",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "spoofed forwarded for",
+			remoteAddr: "192.0.2.1:4000",
+			headers:    map[string]string{echo.HeaderXForwardedFor: "203.0.113.5"},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "spoofed real ip",
+			remoteAddr: "192.0.2.1:4000",
+			headers:    map[string]string{echo.HeaderXRealIP: "203.0.113.5"},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "spoofed from loopback",
+			remoteAddr: "127.0.0.1:4000",
+			headers:    map[string]string{echo.HeaderXForwardedFor: "203.0.113.5"},
+			want:       "127.0.0.1",
+		},
+		{
+			name:       "trusted proxy",
+			proxies:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{echo.HeaderXForwardedFor: "203.0.113.5"},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "client prepends to trusted proxy",
+			proxies:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{echo.HeaderXForwardedFor: "198.51.100.9, 203.0.113.5"},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "untrusted proxy",
+			proxies:    []string{"10.0.0.0/8"},
+			remoteAddr: "192.0.2.1:4000",
+			headers:    map[string]string{echo.HeaderXForwardedFor: "203.0.113.5"},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "private network not trusted by default",
+			proxies:    []string{"10.0.0.0/8"},
+			remoteAddr: "192.168.1.1:4000",
+			headers:    map[string]string{echo.HeaderXForwardedFor: "203.0.113.5"},
+			want:       "192.168.1.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extract, err := IPExtractor(&config.Config{TrustedProxies: tt.proxies})
+			if err != nil {
+				t.Fatalf("IPExtractor: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := extract(req); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPExtractorInvalidRange(t *testing.T) {
+	if _, err := IPExtractor(&config.Config{TrustedProxies: []string{"10.0.0.1"}}); err == nil {
+		t.Error("accepted an address without prefix length")
+	}
+}
+
+// loginServer serves Login behind the address extraction of cfg. Logins are
+// unknown, and the counters of locked keys report a lockout.
+func loginServer(t *testing.T, cfg *config.Config, locked ...string) (*echo.Echo, *dbtest.DB) {
+	t.Helper()
+	extract, err := IPExtractor(cfg)
+	if err != nil {
+		t.Fatalf("IPExtractor: %v", err)
+	}
+
+	db, script := dbtest.New()
+	t.Cleanup(func() { db.Close() })
+	script.On("SELECT failures, last_failure_at, locked_until, NOW() FROM login_attempts", func(args []driver.Value) dbtest.Result {
+		result := dbtest.Result{Columns: []string{"failures", "last_failure_at", "locked_until", "now"}}
+		now := time.Now()
+		for _, key := range locked {
+			if strings.Contains(fmt.Sprint(args[0]), `"`+key+`"`) {
+				result.Rows = append(result.Rows, []driver.Value{int64(0), now.Add(-time.Minute), now.Add(time.Hour), now})
+			}
+		}
+		return result
+	})
+	script.Returns("RETURNING failures", dbtest.Result{Columns: []string{"failures"}, Rows: [][]driver.Value{{int64(1)}}})
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg.LoginFailureWindow = 15 * time.Minute
+	cfg.LoginBaseDelay = time.Second
+	cfg.LoginMaxDelay = 30 * time.Second
+	cfg.LoginMaxFailures = 5
+	cfg.LoginIPMaxFailures = 20
+	cfg.LoginLockout = 15 * time.Minute
+
+	e := echo.New()
+	e.IPExtractor = extract
+	authHandler := handlers.NewAuthHandler(db, logger, auth.NewTokenManager("secret", time.Hour), cfg, nil, nil)
+	e.POST("/login", authHandler.Login)
+	return e, script
+}
+
+func postLogin(e *echo.Echo, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"login":"someone","password":"wrong"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoginThrottleIgnoresSpoofedAddress(t *testing.T) {
+	spoofed := map[string]string{echo.HeaderXForwardedFor: "203.0.113.5", echo.HeaderXRealIP: "203.0.113.6"}
+
+	e, _ := loginServer(t, &config.Config{}, "ip:192.0.2.1")
+	if rec := postLogin(e, "192.0.2.1:4000", spoofed); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("locked address with spoofed headers: got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	e, script := loginServer(t, &config.Config{})
+	if rec := postLogin(e, "192.0.2.1:4000", spoofed); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	failures := script.Ran("RETURNING failures")
+	if len(failures) != 1 || failures[0].Args[0] != "ip:192.0.2.1" {
+		t.Errorf("failure recorded as %v, want for ip:192.0.2.1", failures)
+	}
+}
+
+func TestLoginThrottleTrustedProxy(t *testing.T) {
+	forwarded := map[string]string{echo.HeaderXForwardedFor: "203.0.113.5"}
+
+	e, _ := loginServer(t, &config.Config{TrustedProxies: []string{"10.0.0.0/8"}}, "ip:203.0.113.5")
+	if rec := postLogin(e, "10.1.2.3:4000", forwarded); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("locked client behind proxy: got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec := postLogin(e, "10.1.2.3:4000", map[string]string{echo.HeaderXForwardedFor: "198.51.100.9"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("other client behind proxy: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
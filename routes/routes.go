@@ -1,22 +1,116 @@
 package routes
 
 import (
+	"GoArticles/analytics"
+	"GoArticles/audit"
+	"GoArticles/auth"
+	"GoArticles/config"
+	"GoArticles/export"
 	"GoArticles/handlers"
+	"GoArticles/mailer"
+	"GoArticles/oidc"
+	"GoArticles/recommend"
 	"database/sql"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
+	"time"
 )
 
-func InitRoutes(e *echo.Echo, db *sql.DB) {
+func InitRoutes(e *echo.Echo, db *sql.DB, cfg *config.Config, views *analytics.ViewTracker, exporter *export.Exporter) {
 
 	logger := logrus.New()
+	related := recommend.NewCache(time.Hour)
+	tokens := auth.NewTokenManager(cfg.AuthSecret, cfg.AuthTokenTTL)
+	auditLog := audit.NewLog(db, logger)
+	mail := mailer.New(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom, logger)
 
-	userHandler := handlers.NewUserHandler(db, logger)
-	articleHandler := handlers.NewArticleHandler(db, logger)
-	categoryHandler := handlers.NewCategoryHandler(db, logger)
+	var sso *oidc.Provider
+	if cfg.OIDCIssuer != "" {
+		sso = oidc.New(oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+			GroupsClaim:  cfg.OIDCGroupsClaim,
+		})
+	}
+
+	userHandler := handlers.NewUserHandler(db, logger, cfg, mail)
+	articleHandler := handlers.NewArticleHandler(db, logger, views, related, cfg)
+	categoryHandler := handlers.NewCategoryHandler(db, logger, related, cfg)
 	roleHandler := handlers.NewRoleHandler(db, logger)
 	permissionHandler := handlers.NewPermissionHandler(db, logger)
 	userSettingsHandler := handlers.NewUserSettingsHandler(db, logger)
+	feedHandler := handlers.NewFeedHandler(db, logger, cfg)
+	sitemapHandler := handlers.NewSitemapHandler(db, logger, cfg)
+	authHandler := handlers.NewAuthHandler(db, logger, tokens, cfg, mail, sso)
+	followHandler := handlers.NewFollowHandler(db, logger)
+	bookmarkHandler := handlers.NewBookmarkHandler(db, logger)
+	seriesHandler := handlers.NewSeriesHandler(db, logger)
+	contributorHandler := handlers.NewContributorHandler(db, logger)
+	translationHandler := handlers.NewTranslationHandler(db, logger, cfg)
+	commentHandler := handlers.NewCommentHandler(db, logger)
+	trashHandler := handlers.NewTrashHandler(db, logger, cfg, related)
+	exportHandler := handlers.NewExportHandler(db, logger, cfg, exporter)
+	accountHandler := handlers.NewAccountHandler(db, logger, cfg, mail)
+	twoFactorHandler := handlers.NewTwoFactorHandler(db, logger, cfg)
+	apiKeyHandler := handlers.NewAPIKeyHandler(db, logger)
+	sessionHandler := handlers.NewSessionHandler(db, logger)
+	auditHandler := handlers.NewAuditHandler(db, logger, auditLog)
+
+	e.Use(auditLog.Middleware())
+	e.Use(tokens.Middleware(apiKeyHandler.Authenticate, sessionHandler.Validate))
+
+	// Uploaded files such as avatars
+	e.Static("/media", cfg.MediaDir)
+
+	// Exchange username/email and password for a bearer token
+	e.POST("/login", authHandler.Login)
+	// Second login step for users with two-factor authentication
+	e.POST("/login/2fa", authHandler.LoginTwoFactor)
+
+	// Start single sign-on; redirects to the OpenID Connect provider
+	e.GET("/login/oidc", authHandler.LoginSSO)
+	// Provider redirects back here; responds like /login
+	e.GET("/login/oidc/callback", authHandler.SSOCallback)
+
+	// Lift a login lockout of a user
+	e.DELETE("/users/:id/lockout", authHandler.UnlockUser, auth.RequireUser)
+
+	// Start TOTP enrollment; returns the secret and otpauth URI
+	e.POST("/users/:id/2fa", twoFactorHandler.Enroll, auth.RequireUser, auth.DenyAPIKeys)
+	// Enable 2FA with a code from the authenticator; returns recovery codes
+	e.POST("/users/:id/2fa/confirm", twoFactorHandler.Confirm, auth.RequireUser, auth.DenyAPIKeys)
+	// Disable 2FA (requires password and code)
+	e.DELETE("/users/:id/2fa", twoFactorHandler.Disable, auth.RequireUser, auth.DenyAPIKeys)
+	// Replace the recovery codes (requires password and code)
+	e.POST("/users/:id/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes, auth.RequireUser, auth.DenyAPIKeys)
+
+	// Active sessions of a user
+	e.GET("/users/:id/sessions", sessionHandler.GetSessions, auth.RequireUser)
+	// Log out of one session
+	e.DELETE("/users/:id/sessions/:session_id", sessionHandler.RevokeSession, auth.RequireUser, auth.DenyAPIKeys)
+	// Log out everywhere (?keep_current=true keeps the session making the request)
+	e.DELETE("/users/:id/sessions", sessionHandler.RevokeSessions, auth.RequireUser, auth.DenyAPIKeys)
+
+	// Create an API key for the current user; the response holds the only copy of the key
+	e.POST("/users/:id/api-keys", apiKeyHandler.CreateAPIKey, auth.RequireUser, auth.DenyAPIKeys)
+	// API keys of a user
+	e.GET("/users/:id/api-keys", apiKeyHandler.GetAPIKeys, auth.RequireUser)
+	// Revoke an API key
+	e.DELETE("/users/:id/api-keys/:key_id", apiKeyHandler.RevokeAPIKey, auth.RequireUser, auth.DenyAPIKeys)
+
+	// Change the current user's password (requires the current one)
+	e.POST("/users/:id/password", accountHandler.ChangePassword, auth.RequireUser, auth.DenyAPIKeys)
+	// Email a password reset link
+	e.POST("/password/forgot", accountHandler.ForgotPassword)
+	// Set a new password with a token from the reset email
+	e.POST("/password/reset", accountHandler.ResetPassword)
+	// Send a new email verification link to the current user
+	e.POST("/users/:id/email/verification", accountHandler.SendVerification, auth.RequireUser)
+	// Confirm an email address with a token from the verification email
+	e.POST("/email/verify", accountHandler.VerifyEmail)
 
 	// Get user by id
 	e.GET("/users/:id", userHandler.GetUserByID)
@@ -24,49 +118,225 @@ func InitRoutes(e *echo.Echo, db *sql.DB) {
 	e.GET("/users/:id/roles", userHandler.GetUserRoles)
 	// Creating new user
 	e.POST("/users", userHandler.CreateUser)
-	// Removing a user by id
-	e.DELETE("/users/:id", userHandler.DeleteUser)
+	// Update a user's profile (username, email, display_name, bio, links)
+	e.PATCH("/users/:id", userHandler.UpdateUser, auth.RequireUser, auth.DenyAPIKeys)
+	// Upload a new avatar (multipart field "avatar")
+	e.PUT("/users/:id/avatar", userHandler.UploadAvatar, auth.RequireUser)
+	// Remove the avatar
+	e.DELETE("/users/:id/avatar", userHandler.DeleteAvatar, auth.RequireUser)
+	// Public author profile with published article count and recent articles
+	e.GET("/authors/:username", userHandler.GetAuthorProfile)
+	// Move a user and their content to the trash (?permanent=true&content=delete|reassign|ghost&reassign_to=&dry_run=true to purge instead)
+	e.DELETE("/users/:id", userHandler.DeleteUser, auth.RequireUser, auth.DenyAPIKeys)
+
+	// Request an archive of a user's personal data
+	e.POST("/users/:id/export", exportHandler.RequestExport, auth.RequireUser, auth.DenyAPIKeys)
+	// Exports visible to the current user (all of them with users.manage, ?user_id= to filter)
+	e.GET("/exports", exportHandler.GetExports, auth.RequireUser)
+	// Status of an export
+	e.GET("/exports/:id", exportHandler.GetExport, auth.RequireUser)
+	// Download a ready export; the token in the link is the only credential
+	e.GET("/exports/download/:token", exportHandler.DownloadExport)
+
+	// Move a comment to the trash
+	e.DELETE("/comments/:id", commentHandler.DeleteComment, auth.RequireUser)
+
+	// Deleted items of the current user (?all=true for everybody's, ?type=article|comment|user)
+	e.GET("/trash", trashHandler.GetTrash, auth.RequireUser)
+	// Restore a deleted article
+	e.POST("/articles/:id/restore", trashHandler.RestoreArticle, auth.RequireUser)
+	// Restore a deleted comment
+	e.POST("/comments/:id/restore", trashHandler.RestoreComment, auth.RequireUser)
+	// Restore a deleted user with the content deleted along with the account
+	e.POST("/users/:id/restore", trashHandler.RestoreUser, auth.RequireUser)
+
+	// Follow an author, category or tag
+	e.PUT("/follows/:type/:target_id", followHandler.Follow, auth.RequireUser)
+	// Stop following an author, category or tag
+	e.DELETE("/follows/:type/:target_id", followHandler.Unfollow, auth.RequireUser)
+	// Users following an author
+	e.GET("/users/:id/followers", followHandler.GetFollowers)
+	// Authors, categories and tags a user follows
+	e.GET("/users/:id/following", followHandler.GetFollowing)
+	// Personal feed of newest articles from followed sources
+	e.GET("/feed", followHandler.GetFeed, auth.RequireUser)
+
+	// Bookmarks of the current user (?unread=true)
+	e.GET("/bookmarks", bookmarkHandler.GetBookmarks, auth.RequireUser)
+	// Bookmark an article
+	e.PUT("/bookmarks/:article_id", bookmarkHandler.AddBookmark, auth.RequireUser)
+	// Mark a bookmarked article as read or unread
+	e.PATCH("/bookmarks/:article_id", bookmarkHandler.UpdateBookmark, auth.RequireUser)
+	// Remove a bookmark
+	e.DELETE("/bookmarks/:article_id", bookmarkHandler.RemoveBookmark, auth.RequireUser)
+	// Collections of the current user
+	e.GET("/collections", bookmarkHandler.GetMyCollections, auth.RequireUser)
+	// Create a collection
+	e.POST("/collections", bookmarkHandler.CreateCollection, auth.RequireUser)
+	// Get a collection with its articles in order
+	e.GET("/collections/:id", bookmarkHandler.GetCollection)
+	// Rename a collection or change its visibility
+	e.PATCH("/collections/:id", bookmarkHandler.UpdateCollection, auth.RequireUser)
+	// Delete a collection
+	e.DELETE("/collections/:id", bookmarkHandler.DeleteCollection, auth.RequireUser)
+	// Append an article to a collection
+	e.PUT("/collections/:id/articles/:article_id", bookmarkHandler.AddToCollection, auth.RequireUser)
+	// Remove an article from a collection
+	e.DELETE("/collections/:id/articles/:article_id", bookmarkHandler.RemoveFromCollection, auth.RequireUser)
+	// Reorder a collection
+	e.PUT("/collections/:id/order", bookmarkHandler.ReorderCollection, auth.RequireUser)
+	// Public collections of a user
+	e.GET("/users/:id/collections", bookmarkHandler.GetUserCollections)
+
+	// Create a series owned by the current user
+	e.POST("/series", seriesHandler.CreateSeries, auth.RequireUser)
+	// Get a series with its table of contents
+	e.GET("/series/:id", seriesHandler.GetSeries)
+	// Update series title or description
+	e.PATCH("/series/:id", seriesHandler.UpdateSeries, auth.RequireUser)
+	// Delete a series, keeping its articles
+	e.DELETE("/series/:id", seriesHandler.DeleteSeries, auth.RequireUser)
+	// Add an article to a series (?position=n inserts instead of appending)
+	e.PUT("/series/:id/articles/:article_id", seriesHandler.AddSeriesPart, auth.RequireUser)
+	// Remove an article from a series
+	e.DELETE("/series/:id/articles/:article_id", seriesHandler.RemoveSeriesPart, auth.RequireUser)
+	// Reorder a series
+	e.PUT("/series/:id/order", seriesHandler.ReorderSeries, auth.RequireUser)
 
 	// Get user settings by ID
-	e.GET("/users/:user_id/settings", userSettingsHandler.GetUserSettings)
+	e.GET("/users/:user_id/settings", userSettingsHandler.GetUserSettings, auth.RequireUser)
 	// Update user settings
-	e.PATCH("/users/:user_id/settings", userSettingsHandler.UpdateUserSettings)
+	e.PATCH("/users/:user_id/settings", userSettingsHandler.UpdateUserSettings, auth.RequireUser)
+	// Type, allowed values, default and description of every setting
+	e.GET("/settings/schema", userSettingsHandler.GetSettingsSchema)
 
+	// Articles ranked by recent, time-decayed activity
+	e.GET("/articles/trending", articleHandler.GetTrendingArticles)
+	// Most popular articles over a period (?period=day|week|month|year)
+	e.GET("/articles/popular", articleHandler.GetPopularArticles)
 	// Get article by ID
 	e.GET("/articles/:id", articleHandler.GetArticleByID)
 	// Create a new article
-	e.POST("/articles", articleHandler.CreateArticle)
+	e.POST("/articles", articleHandler.CreateArticle, auth.RequireUser)
 	// Update existing article by ID
-	e.PUT("/articles/:id", articleHandler.UpdateArticle)
-	// Delete article by ID
-	e.DELETE("/articles/:id", articleHandler.DeleteArticle)
+	e.PUT("/articles/:id", articleHandler.UpdateArticle, auth.RequireUser)
+	// Move article to the trash by ID
+	e.DELETE("/articles/:id", articleHandler.DeleteArticle, auth.RequireUser)
+	// Publish an article now; also allowed with articles.publish for its categories
+	e.PUT("/articles/:id/publish", articleHandler.PublishArticle, auth.RequireUser)
+	// Turn a published article back into a draft
+	e.DELETE("/articles/:id/publish", articleHandler.UnpublishArticle, auth.RequireUser)
 	// Add a tag to an article
-	e.POST("/articles/:article_id/tags/:tag_id", articleHandler.AddArticleTag)
-	// Like an article by user ID
-	e.POST("/articles/:article_id/like/:user_id", articleHandler.LikeArticle)
+	e.POST("/articles/:article_id/tags/:tag_id", articleHandler.AddArticleTag, auth.RequireUser)
+	// Published versions of an article in other languages
+	e.GET("/articles/:id/translations", articleHandler.GetArticleTranslations)
+	// Article by language and slug, e.g. /en/articles/getting-started
+	e.GET("/:lang/articles/:slug", articleHandler.GetArticleBySlug)
+	// "You may also like" recommendations (?exclude_author=true)
+	e.GET("/articles/:id/related", articleHandler.GetRelatedArticles)
+	// Like a published article; the user ID has to be the current user's
+	e.POST("/articles/:article_id/like/:user_id", articleHandler.LikeArticle, auth.RequireUser)
+	e.PUT("/articles/:article_id/like/:user_id", articleHandler.LikeArticle, auth.RequireUser)
+	// Remove the current user's like from an article
+	e.DELETE("/articles/:article_id/like/:user_id", articleHandler.UnlikeArticle, auth.RequireUser)
+	// Users who liked an article
+	e.GET("/articles/:id/likes", articleHandler.GetArticleLikes)
+	// Articles liked by a user
+	e.GET("/users/:id/likes", articleHandler.GetLikedArticles)
+
+	// Accepted contributors of an article (the author also sees pending invitations)
+	e.GET("/articles/:id/contributors", contributorHandler.GetContributors)
+	// Invite a co-author, editor or translator
+	e.POST("/articles/:id/contributors", contributorHandler.InviteContributor, auth.RequireUser)
+	// Accept an invitation to contribute
+	e.POST("/articles/:id/contributors/accept", contributorHandler.AcceptInvitation, auth.RequireUser)
+	// Decline an invitation to contribute
+	e.POST("/articles/:id/contributors/decline", contributorHandler.DeclineInvitation, auth.RequireUser)
+	// Remove a contributor, or leave an article
+	e.DELETE("/articles/:id/contributors/:user_id", contributorHandler.RemoveContributor, auth.RequireUser)
+	// Published articles a user wrote or contributed to
+	e.GET("/users/:id/articles", contributorHandler.GetUserArticles)
 
 	// Add a category to an article
-	e.POST("/articles/:article_id/categories/:category_id", categoryHandler.AddArticleCategory)
+	e.POST("/articles/:article_id/categories/:category_id", categoryHandler.AddArticleCategory, auth.RequireUser)
 	// Delete category from article
-	e.DELETE("/articles/:article_id/categories/:category_id", categoryHandler.RemoveCategoryFromArticle)
+	e.DELETE("/articles/:article_id/categories/:category_id", categoryHandler.RemoveCategoryFromArticle, auth.RequireUser)
 	// Get articles for categories
 	e.GET("/articles/:article_id/categories", categoryHandler.GetCategoriesForArticle)
 	// Get categories for articles
 	e.GET("/categories/:category_id/articles", categoryHandler.GetArticlesForCategory)
 
+	// Localized names of a category
+	e.GET("/categories/:category_id/translations", translationHandler.GetCategoryNames)
+	// Set the name of a category in a language
+	e.PUT("/categories/:category_id/translations/:lang", translationHandler.SetCategoryName, auth.RequireUser)
+	// Delete the name of a category in a language
+	e.DELETE("/categories/:category_id/translations/:lang", translationHandler.DeleteCategoryName, auth.RequireUser)
+	// Published articles with a tag
+	e.GET("/tags/:tag_id/articles", articleHandler.GetTagArticles)
+	// Localized names of a tag
+	e.GET("/tags/:tag_id/translations", translationHandler.GetTagNames)
+	// Set the name of a tag in a language
+	e.PUT("/tags/:tag_id/translations/:lang", translationHandler.SetTagName, auth.RequireUser)
+	// Delete the name of a tag in a language
+	e.DELETE("/tags/:tag_id/translations/:lang", translationHandler.DeleteTagName, auth.RequireUser)
+
 	// Get role
 	e.GET("/roles", roleHandler.GetRoles)
-	// Add role to user
-	e.PUT("/users/:user_id/roles/:role_id", roleHandler.AssignRoleToUser)
-	// Delete role from user
-	e.DELETE("/users/:user_id/roles/:role_id", roleHandler.RemoveRoleFromUser)
+	// Create a role (name, description, parent_id to inherit from)
+	e.POST("/roles", roleHandler.CreateRole, auth.RequireUser)
+	// Rename a role or change its description or parent (parent_id 0 removes it)
+	e.PATCH("/roles/:role_id", roleHandler.UpdateRole, auth.RequireUser)
+	// Delete a role that is neither built in nor the default
+	e.DELETE("/roles/:role_id", roleHandler.DeleteRole, auth.RequireUser)
+	// Require two-factor authentication for a role's members
+	e.PUT("/roles/:role_id/2fa", roleHandler.SetTwoFactorRequirement, auth.RequireUser)
+	// Choose the role new accounts get by name
+	e.PUT("/roles/default", roleHandler.SetDefaultRole, auth.RequireUser)
+	// Replace all roles of a user with a JSON list of role IDs
+	e.PUT("/users/:id/roles", roleHandler.SetUserRoles, auth.RequireUser)
+	// Add role to user, optionally only for one category and those below it (?category_id=)
+	e.PUT("/users/:user_id/roles/:role_id", roleHandler.AssignRoleToUser, auth.RequireUser)
+	// Delete role from user (?category_id= for a scoped assignment)
+	e.DELETE("/users/:user_id/roles/:role_id", roleHandler.RemoveRoleFromUser, auth.RequireUser)
 
 	// Add permission to role
-	e.PUT("/roles/:role_id/permissions/:permission_id", permissionHandler.AssignPermissionToRole)
+	e.PUT("/roles/:role_id/permissions/:permission_id", permissionHandler.AssignPermissionToRole, auth.RequireUser)
 	// Delete permission from role
-	e.DELETE("/roles/:role_id/permissions/:permission_id", permissionHandler.RemovePermissionFromRole)
+	e.DELETE("/roles/:role_id/permissions/:permission_id", permissionHandler.RemovePermissionFromRole, auth.RequireUser)
 	// Get permission
 	e.GET("/roles/:role_id/permissions", permissionHandler.GetPermissionsByRole)
+	// All permissions
+	e.GET("/permissions", permissionHandler.GetPermissions)
+	// Create a permission
+	e.POST("/permissions", permissionHandler.CreatePermission, auth.RequireUser)
+	// Rename a permission or change its description
+	e.PATCH("/permissions/:permission_id", permissionHandler.UpdatePermission, auth.RequireUser)
+	// Delete a permission that is not built in
+	e.DELETE("/permissions/:permission_id", permissionHandler.DeletePermission, auth.RequireUser)
+	// Effective permissions of a user, including inherited ones
+	e.GET("/users/:id/permissions", permissionHandler.GetUserPermissions, auth.RequireUser)
+
+	// Audit log of changes, newest first (?actor_id=, ?action=, ?target_type=, ?target_id=, ?from=, ?to=)
+	e.GET("/audit", auditHandler.GetAuditLog, auth.RequireUser)
+	// Audit log entries matching the same filters as CSV
+	e.GET("/audit/export", auditHandler.ExportAuditLog, auth.RequireUser)
+	// Check the audit log's hash chain for tampering
+	e.GET("/audit/verify", auditHandler.VerifyAuditLog, auth.RequireUser)
+
+	// Newest published articles as RSS, Atom or JSON Feed
+	e.GET("/feeds/articles.:format", feedHandler.GetArticlesFeed)
+	// Feed of a category, e.g. /feeds/categories/3.atom
+	e.GET("/feeds/categories/:feed", feedHandler.GetCategoryFeed)
+	// Feed of a tag
+	e.GET("/feeds/tags/:feed", feedHandler.GetTagFeed)
+	// Feed of an author
+	e.GET("/feeds/authors/:feed", feedHandler.GetAuthorFeed)
+
+	// Sitemap index pointing to the chunked sitemaps
+	e.GET("/sitemap.xml", sitemapHandler.GetSitemapIndex)
+	// Single sitemap chunk, e.g. /sitemaps/articles-1.xml
+	e.GET("/sitemaps/:name", sitemapHandler.GetSitemap)
 
 	//TODO: add tags to articles
 }
@@ -0,0 +1,132 @@
+package settings
+
+import (
+	"GoArticles/models"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Setting types.
+const (
+	Bool   = "bool"
+	Int    = "int"
+	Enum   = "enum"
+	String = "string"
+	JSON   = "json"
+)
+
+// MaxLength limits string and JSON values, in characters.
+const MaxLength = 2000
+
+// ErrInvalidDefinition means the setting itself is misconfigured, e.g. with
+// a pattern that does not compile, rather than the value being wrong.
+var ErrInvalidDefinition = errors.New("invalid setting definition")
+
+// Parse validates a value sent by a client and returns the text it is
+// stored as. Booleans and integers may also be sent as strings, as clients
+// did before settings were typed. Error messages are meant for the client.
+func Parse(def *models.SettingDefinition, raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	var text string
+	isString := json.Unmarshal(raw, &text) == nil
+
+	switch def.Type {
+	case Bool:
+		if !isString {
+			text = string(raw)
+		}
+		if text != "true" && text != "false" {
+			return "", errors.New("must be true or false")
+		}
+		return text, nil
+
+	case Int:
+		if !isString {
+			text = string(raw)
+		}
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return "", errors.New("must be a whole number")
+		}
+		if def.Min != nil && n < *def.Min {
+			return "", fmt.Errorf("must be at least %d", *def.Min)
+		}
+		if def.Max != nil && n > *def.Max {
+			return "", fmt.Errorf("must be at most %d", *def.Max)
+		}
+		return strconv.FormatInt(n, 10), nil
+
+	case Enum:
+		if !isString {
+			return "", errors.New("must be a string")
+		}
+		for _, allowed := range def.AllowedValues {
+			if text == allowed {
+				return text, nil
+			}
+		}
+		return "", fmt.Errorf("must be one of %s", strings.Join(def.AllowedValues, ", "))
+
+	case String:
+		if !isString {
+			return "", errors.New("must be a string")
+		}
+		if utf8.RuneCountInString(text) > MaxLength {
+			return "", fmt.Errorf("must be at most %d characters", MaxLength)
+		}
+		if def.Pattern != "" {
+			pattern, err := regexp.Compile(`^(?:` + def.Pattern + `)$`)
+			if err != nil {
+				return "", fmt.Errorf("%w: %s: %v", ErrInvalidDefinition, def.Key, err)
+			}
+			if !pattern.MatchString(text) {
+				return "", fmt.Errorf("must match %s", def.Pattern)
+			}
+		}
+		return text, nil
+
+	case JSON:
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil || compact.Len() == 0 {
+			return "", errors.New("must be valid JSON")
+		}
+		if utf8.RuneCount(compact.Bytes()) > MaxLength {
+			return "", fmt.Errorf("must be at most %d characters", MaxLength)
+		}
+		return compact.String(), nil
+	}
+	return "", fmt.Errorf("%w: %s: unknown type %q", ErrInvalidDefinition, def.Key, def.Type)
+}
+
+// Decode converts a stored value to its type: bool, int64, string or, for
+// JSON settings, json.RawMessage.
+func Decode(def *models.SettingDefinition, stored string) (interface{}, error) {
+	switch def.Type {
+	case Bool:
+		switch stored {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("setting %s: %q is not a bool", def.Key, stored)
+	case Int:
+		n, err := strconv.ParseInt(stored, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("setting %s: %q is not an int", def.Key, stored)
+		}
+		return n, nil
+	case JSON:
+		if !json.Valid([]byte(stored)) {
+			return nil, fmt.Errorf("setting %s: stored value is not valid JSON", def.Key)
+		}
+		return json.RawMessage(stored), nil
+	}
+	return stored, nil
+}
@@ -0,0 +1,107 @@
+package settings
+
+import (
+	"GoArticles/models"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func int64p(n int64) *int64 { return &n }
+
+func TestParse(t *testing.T) {
+	boolean := &models.SettingDefinition{Key: "notifications", Type: Bool}
+	number := &models.SettingDefinition{Key: "page_size", Type: Int, Min: int64p(5), Max: int64p(100)}
+	enum := &models.SettingDefinition{Key: "theme", Type: Enum, AllowedValues: []string{"light", "dark"}}
+	text := &models.SettingDefinition{Key: "signature", Type: String}
+	pattern := &models.SettingDefinition{Key: "color", Type: String, Pattern: `#[0-9a-f]{6}`}
+	badPattern := &models.SettingDefinition{Key: "broken", Type: String, Pattern: `(`}
+	object := &models.SettingDefinition{Key: "layout", Type: JSON}
+	unknown := &models.SettingDefinition{Key: "other", Type: "float"}
+
+	tests := []struct {
+		name    string
+		def     *models.SettingDefinition
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{name: "bool", def: boolean, raw: `true`, want: "true"},
+		{name: "bool as string", def: boolean, raw: ` "false" `, want: "false"},
+		{name: "bool as number", def: boolean, raw: `1`, wantErr: true},
+		{name: "int", def: number, raw: `20`, want: "20"},
+		{name: "int as string", def: number, raw: `"050"`, want: "50"},
+		{name: "int below min", def: number, raw: `4`, wantErr: true},
+		{name: "int above max", def: number, raw: `101`, wantErr: true},
+		{name: "int with fraction", def: number, raw: `20.5`, wantErr: true},
+		{name: "enum", def: enum, raw: `"dark"`, want: "dark"},
+		{name: "enum not allowed", def: enum, raw: `"blue"`, wantErr: true},
+		{name: "enum not a string", def: enum, raw: `1`, wantErr: true},
+		{name: "string", def: text, raw: `"Regards"`, want: "Regards"},
+		{name: "string too long", def: text, raw: `"` + strings.Repeat("я", MaxLength+1) + `"`, wantErr: true},
+		{name: "string at max length", def: text, raw: `"` + strings.Repeat("я", MaxLength) + `"`, want: strings.Repeat("я", MaxLength)},
+		{name: "string not a string", def: text, raw: `true`, wantErr: true},
+		{name: "pattern", def: pattern, raw: `"#a0b1c2"`, want: "#a0b1c2"},
+		{name: "pattern is anchored", def: pattern, raw: `"x#a0b1c2"`, wantErr: true},
+		{name: "json", def: object, raw: `{ "columns": [1, 2] }`, want: `{"columns":[1,2]}`},
+		{name: "json string", def: object, raw: `"text"`, want: `"text"`},
+		{name: "invalid json", def: object, raw: `{"columns":`, wantErr: true},
+		{name: "empty json", def: object, raw: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.def, json.RawMessage(tt.raw))
+			if tt.wantErr {
+				if err == nil || errors.Is(err, ErrInvalidDefinition) {
+					t.Fatalf("Parse() = %q, %v, want a validation error", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Parse() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	for _, def := range []*models.SettingDefinition{badPattern, unknown} {
+		if _, err := Parse(def, json.RawMessage(`"value"`)); !errors.Is(err, ErrInvalidDefinition) {
+			t.Errorf("Parse() with definition %s: error = %v, want ErrInvalidDefinition", def.Key, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     string
+		stored  string
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "bool", typ: Bool, stored: "true", want: true},
+		{name: "bool false", typ: Bool, stored: "false", want: false},
+		{name: "invalid bool", typ: Bool, stored: "yes", wantErr: true},
+		{name: "int", typ: Int, stored: "-7", want: int64(-7)},
+		{name: "invalid int", typ: Int, stored: "seven", wantErr: true},
+		{name: "enum", typ: Enum, stored: "dark", want: "dark"},
+		{name: "string", typ: String, stored: "Regards", want: "Regards"},
+		{name: "json", typ: JSON, stored: `{"a":1}`, want: json.RawMessage(`{"a":1}`)},
+		{name: "invalid json", typ: JSON, stored: `{"a":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(&models.SettingDefinition{Key: "key", Type: tt.typ}, tt.stored)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Decode() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, %v, want %#v", got, err, tt.want)
+			}
+		})
+	}
+}
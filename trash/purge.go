@@ -0,0 +1,193 @@
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// Purger permanently removes articles, comments and users that have been in
+// the trash for longer than the retention period.
+type Purger struct {
+	DB     *sql.DB
+	Logger *logrus.Logger
+
+	mediaDir  string
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewPurger(db *sql.DB, logger *logrus.Logger, mediaDir string, retention, interval time.Duration) *Purger {
+	return &Purger{
+		DB:        db,
+		Logger:    logger,
+		mediaDir:  mediaDir,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+// Run purges expired items every interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.purgeExpired(ctx)
+
+	for {
+		select {
+		case <-ticker.C:
+			p.purgeExpired(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// purgeExpired removes every expired item in its own transaction, so one
+// failing item does not keep the others in the trash. Users go first as
+// purging them takes their articles and comments along.
+func (p *Purger) purgeExpired(ctx context.Context) {
+	kinds := []struct {
+		name  string
+		query string
+		purge func(context.Context, *sql.Tx, int) ([]string, error)
+	}{
+		{"user", `SELECT id FROM users WHERE deleted_at < $1`, p.purgeUserContent},
+		{"article", `SELECT id FROM articles WHERE deleted_at < $1`, withoutFiles(PurgeArticle)},
+		{"comment", `SELECT id FROM comments WHERE deleted_at < $1`, withoutFiles(PurgeComment)},
+	}
+
+	cutoff := time.Now().Add(-p.retention)
+	for _, kind := range kinds {
+		ids, err := p.expiredIDs(ctx, kind.query, cutoff)
+		if err != nil {
+			p.Logger.WithError(err).Errorf("Failed to find expired %ss in the trash", kind.name)
+			continue
+		}
+
+		for _, id := range ids {
+			var files []string
+			err = p.inTx(ctx, func(tx *sql.Tx) error {
+				var err error
+				files, err = kind.purge(ctx, tx, id)
+				return err
+			})
+			if err != nil {
+				p.Logger.WithError(err).Errorf("Failed to purge %s ID %d", kind.name, id)
+				continue
+			}
+			if err = RemoveFiles(files); err != nil {
+				p.Logger.WithError(err).Errorf("Failed to remove files of %s ID %d", kind.name, id)
+			}
+			p.Logger.Infof("Purged %s ID %d from the trash", kind.name, id)
+		}
+	}
+}
+
+// purgeUserContent purges an expired user together with their content, which
+// went to the trash with the account.
+func (p *Purger) purgeUserContent(ctx context.Context, tx *sql.Tx, id int) ([]string, error) {
+	_, files, err := PurgeUser(ctx, tx, id, UserPurge{Content: ContentDelete, MediaDir: p.mediaDir})
+	return files, err
+}
+
+// withoutFiles adapts a purge that leaves no files behind.
+func withoutFiles(purge func(context.Context, *sql.Tx, int) error) func(context.Context, *sql.Tx, int) ([]string, error) {
+	return func(ctx context.Context, tx *sql.Tx, id int) ([]string, error) {
+		return nil, purge(ctx, tx, id)
+	}
+}
+
+func (p *Purger) expiredIDs(ctx context.Context, query string, cutoff time.Time) ([]int, error) {
+	rows, err := p.DB.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (p *Purger) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Report counts the rows a purge deleted or changed, keyed by table and
+// action, e.g. "comments.deleted".
+type Report map[string]int64
+
+// statement is one step of a purge. Steps without a key only keep other
+// rows consistent and are not reported.
+type statement struct {
+	key   string
+	query string
+}
+
+func (r Report) exec(ctx context.Context, tx *sql.Tx, statements []statement, args ...interface{}) error {
+	for _, s := range statements {
+		result, err := tx.ExecContext(ctx, s.query, args...)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n > 0 && s.key != "" {
+			r[s.key] += n
+		}
+	}
+	return nil
+}
+
+// PurgeArticle deletes the article with everything attached to it. Series and
+// collections it was part of are renumbered to keep positions contiguous.
+func PurgeArticle(ctx context.Context, tx *sql.Tx, id int) error {
+	return purgeArticle(ctx, tx, id, Report{})
+}
+
+// purgeArticle removes the database rows of an article. Uploaded files of its
+// media are left on disk.
+func purgeArticle(ctx context.Context, tx *sql.Tx, id int, report Report) error {
+	return report.exec(ctx, tx, []statement{
+		{"", `UPDATE series_articles sa SET position = sa.position - 1
+		 FROM series_articles removed
+		 WHERE removed.article_id = $1 AND sa.series_id = removed.series_id AND sa.position > removed.position`},
+		{"series_articles.deleted", `DELETE FROM series_articles WHERE article_id=$1`},
+		{"", `UPDATE bookmark_collection_items i SET position = i.position - 1
+		 FROM bookmark_collection_items removed
+		 WHERE removed.article_id = $1 AND i.collection_id = removed.collection_id AND i.position > removed.position`},
+		{"bookmark_collection_items.deleted", `DELETE FROM bookmark_collection_items WHERE article_id=$1`},
+		{"bookmarks.deleted", `DELETE FROM bookmarks WHERE article_id=$1`},
+		{"article_contributors.deleted", `DELETE FROM article_contributors WHERE article_id=$1`},
+		{"article_tags.deleted", `DELETE FROM article_tags WHERE article_id=$1`},
+		{"article_categories.deleted", `DELETE FROM article_categories WHERE article_id=$1`},
+		{"article_likes.deleted", `DELETE FROM article_likes WHERE article_id=$1`},
+		{"article_daily_stats.deleted", `DELETE FROM article_daily_stats WHERE article_id=$1`},
+		{"media.deleted", `DELETE FROM media WHERE article_id=$1`},
+		{"comment_likes.deleted", `DELETE FROM comment_likes WHERE comment_id IN (SELECT id FROM comments WHERE article_id=$1)`},
+		{"comments.deleted", `DELETE FROM comments WHERE article_id=$1`},
+		{"articles.deleted", `DELETE FROM articles WHERE id=$1`},
+	}, id)
+}
+
+func PurgeComment(ctx context.Context, tx *sql.Tx, id int) error {
+	return Report{}.exec(ctx, tx, []statement{
+		{"", `DELETE FROM comment_likes WHERE comment_id=$1`},
+		{"", `DELETE FROM comments WHERE id=$1`},
+	}, id)
+}
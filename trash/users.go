@@ -0,0 +1,238 @@
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// What happens to a user's articles, series and comments when the account is
+// purged.
+const (
+	// ContentDelete purges the content together with the account.
+	ContentDelete = "delete"
+	// ContentReassign hands articles and series over to another user. Comments
+	// are personal and get deleted.
+	ContentReassign = "reassign"
+	// ContentGhost keeps articles, series and comments under the shared ghost
+	// account so discussions stay readable.
+	ContentGhost = "ghost"
+)
+
+// GhostUsername is the account anonymized content is moved to. It is created
+// on first use and cannot log in.
+const GhostUsername = "ghost"
+
+var (
+	ErrInvalidContent = errors.New("unknown content option")
+	ErrInvalidTarget  = errors.New("invalid user to reassign content to")
+	ErrGhostUser      = errors.New("the ghost account cannot be anonymized into itself")
+)
+
+// UserPurge describes how to purge a user. ReassignTo is only used with
+// ContentReassign. MediaDir is where the user's avatar is stored.
+type UserPurge struct {
+	Content    string
+	ReassignTo int
+	MediaDir   string
+}
+
+// PurgeUser deletes the user with everything attached to the account and
+// handles their content as opts says. It reports the affected rows of every
+// table, so running it in a transaction that is rolled back afterwards gives
+// a dry run. It also returns the user's files, their avatar and the archives
+// of their data exports, which the caller removes with RemoveFiles once the
+// transaction is committed.
+func PurgeUser(ctx context.Context, tx *sql.Tx, id int, opts UserPurge) (Report, []string, error) {
+	report := Report{}
+
+	switch opts.Content {
+	case ContentDelete:
+		articleIDs, err := userArticleIDs(ctx, tx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, articleID := range articleIDs {
+			if err = purgeArticle(ctx, tx, articleID, report); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err = report.exec(ctx, tx, []statement{
+			{"series.deleted", `DELETE FROM series WHERE author_id=$1`},
+		}, id); err != nil {
+			return nil, nil, err
+		}
+	case ContentReassign:
+		if opts.ReassignTo == id {
+			return nil, nil, ErrInvalidTarget
+		}
+		if err := checkTarget(ctx, tx, opts.ReassignTo); err != nil {
+			return nil, nil, err
+		}
+		if err := reassignContent(ctx, tx, id, opts.ReassignTo, false, report); err != nil {
+			return nil, nil, err
+		}
+	case ContentGhost:
+		ghostID, err := ghostUserID(ctx, tx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ghostID == id {
+			return nil, nil, ErrGhostUser
+		}
+		if err = reassignContent(ctx, tx, id, ghostID, true, report); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, ErrInvalidContent
+	}
+
+	files, err := userFiles(ctx, tx, id, opts.MediaDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = report.exec(ctx, tx, []statement{
+		{"", `UPDATE articles SET likes_count = GREATEST(likes_count - 1, 0)
+		 WHERE id IN (SELECT article_id FROM article_likes WHERE user_id=$1)`},
+		{"article_likes.deleted", `DELETE FROM article_likes WHERE user_id=$1`},
+		{"comment_likes.deleted", `DELETE FROM comment_likes WHERE user_id=$1 OR comment_id IN (SELECT id FROM comments WHERE user_id=$1)`},
+		{"comments.deleted", `DELETE FROM comments WHERE user_id=$1`},
+		{"follows.deleted", `DELETE FROM follows WHERE follower_id=$1 OR (target_type = 'author' AND target_id=$1)`},
+		{"bookmark_collections.deleted", `DELETE FROM bookmark_collections WHERE user_id=$1`},
+		{"bookmarks.deleted", `DELETE FROM bookmarks WHERE user_id=$1`},
+		{"article_contributors.deleted", `DELETE FROM article_contributors WHERE user_id=$1`},
+		{"notifications.deleted", `DELETE FROM notifications WHERE user_id=$1`},
+		{"user_tokens.deleted", `DELETE FROM user_tokens WHERE user_id=$1`},
+		{"user_recovery_codes.deleted", `DELETE FROM user_recovery_codes WHERE user_id=$1`},
+		{"api_keys.deleted", `DELETE FROM api_keys WHERE user_id=$1`},
+		{"sessions.deleted", `DELETE FROM sessions WHERE user_id=$1`},
+		{"user_identities.deleted", `DELETE FROM user_identities WHERE user_id=$1`},
+		{"login_attempts.deleted", `DELETE FROM login_attempts WHERE key = 'user:' || $1::TEXT`},
+		{"user_settings.deleted", `DELETE FROM user_settings WHERE user_id=$1`},
+		{"user_exports.deleted", `DELETE FROM user_exports WHERE user_id=$1`},
+		{"user_roles.deleted", `DELETE FROM user_roles WHERE user_id=$1`},
+		{"users.deleted", `DELETE FROM users WHERE id=$1`},
+	}, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return report, files, nil
+}
+
+// userFiles lists the user's avatar, which is stored under mediaDir, and the
+// archives of their data exports still on disk.
+func userFiles(ctx context.Context, tx *sql.Tx, userID int, mediaDir string) ([]string, error) {
+	var files []string
+	var avatar sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT avatar_path FROM users WHERE id=$1`, userID).Scan(&avatar)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if avatar.Valid && avatar.String != "" {
+		files = append(files, filepath.Join(mediaDir, filepath.Clean("/"+avatar.String)))
+	}
+
+	query := `SELECT file_path FROM user_exports WHERE user_id=$1 AND file_path IS NOT NULL`
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		if err = rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		files = append(files, path)
+	}
+	return files, rows.Err()
+}
+
+// RemoveFiles deletes the files of a committed purge. Files that are already
+// gone are not an error.
+func RemoveFiles(paths []string) error {
+	var errs []error
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reassignContent moves the user's articles and series, and with comments set
+// also their comments, to targetID. Content trashed together with the account
+// is restored, as keeping it is the point of reassigning.
+func reassignContent(ctx context.Context, tx *sql.Tx, id, targetID int, comments bool, report Report) error {
+	restore := []statement{
+		{"articles.restored", `UPDATE articles SET deleted_at = NULL
+		 WHERE author_id=$1 AND deleted_at = (SELECT deleted_at FROM users WHERE id=$1)`},
+	}
+	reassign := []statement{
+		// The target becomes the author, which supersedes any other role they
+		// had on these articles.
+		{"", `DELETE FROM article_contributors ac USING articles a
+		 WHERE ac.article_id = a.id AND a.author_id=$1 AND ac.user_id=$2`},
+		{"", `UPDATE article_contributors SET user_id=$2 WHERE user_id=$1 AND role = 'author'`},
+		{"articles.reassigned", `UPDATE articles SET author_id=$2 WHERE author_id=$1`},
+		{"series.reassigned", `UPDATE series SET author_id=$2 WHERE author_id=$1`},
+	}
+	if comments {
+		restore = append(restore, statement{"comments.restored", `UPDATE comments SET deleted_at = NULL
+		 WHERE user_id=$1 AND deleted_at = (SELECT deleted_at FROM users WHERE id=$1)`})
+		reassign = append(reassign, statement{"comments.reassigned", `UPDATE comments SET user_id=$2 WHERE user_id=$1`})
+	}
+
+	if err := report.exec(ctx, tx, restore, id); err != nil {
+		return err
+	}
+	return report.exec(ctx, tx, reassign, id, targetID)
+}
+
+// checkTarget makes sure content can be reassigned to the user.
+func checkTarget(ctx context.Context, tx *sql.Tx, userID int) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NULL)`
+	if err := tx.QueryRowContext(ctx, query, userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrInvalidTarget
+	}
+	return nil
+}
+
+// ghostUserID returns the ghost account, creating it if needed. Its password
+// hash matches no password, so nobody can log in as the ghost.
+func ghostUserID(ctx context.Context, tx *sql.Tx) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE username=$1`, GhostUsername).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		query := `INSERT INTO users (username, email, password_hash, created_at, updated_at)
+		          VALUES ($1, $2, '!', NOW(), NOW()) RETURNING id`
+		err = tx.QueryRowContext(ctx, query, GhostUsername, GhostUsername+"@users.invalid").Scan(&id)
+	}
+	return id, err
+}
+
+func userArticleIDs(ctx context.Context, tx *sql.Tx, userID int) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM articles WHERE author_id=$1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}